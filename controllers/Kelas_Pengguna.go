@@ -1,12 +1,21 @@
 package controllers
 
 import (
+	"fmt"
+
 	"github.com/Joko206/UAS_PWEB1/database"
 	"github.com/Joko206/UAS_PWEB1/models"
 	"github.com/gofiber/fiber/v2"
 )
 
+// JoinKelas mendaftarkan user ke kelas tanpa join code (permission kelas.enroll): admin untuk semua kelas,
+// guru hanya untuk kelas yang diajarnya. Siswa bergabung lewat JoinKelasByCode.
 func JoinKelas(c *fiber.Ctx) error {
+	authUser, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
 	// Get database connection (reuse global connection)
 	db, err := database.GetDBConnection()
 	if err != nil {
@@ -34,6 +43,10 @@ func JoinKelas(c *fiber.Ctx) error {
 		return sendResponse(c, fiber.StatusBadRequest, false, "Class does not exist", nil)
 	}
 
+	if err := requireEnrollment(c, authUser, kelas, user); err != nil {
+		return err
+	}
+
 	// Cek apakah user sudah tergabung dengan kelas
 	var existingRecord models.Kelas_Pengguna
 	if err := db.Where("users_id = ? AND kelas_id = ?", requestData.User_id, requestData.Kelas_id).First(&existingRecord).Error; err == nil {
@@ -50,6 +63,12 @@ func JoinKelas(c *fiber.Ctx) error {
 		return handleError(c, err, "Failed to join the class")
 	}
 
+	action := "kelas_enroll"
+	if hasPermission(authUser, models.PermContentManageAny) {
+		action = "admin_enroll"
+	}
+	LogAudit(authUser.ID, action, fmt.Sprintf("kelas:%d user:%d", kelas.ID, user.ID), "success", c)
+
 	return sendResponse(c, fiber.StatusOK, true, "User joined the class successfully", newRecord)
 }
 
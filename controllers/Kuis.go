@@ -1,6 +1,10 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+
 	"github.com/Joko206/UAS_PWEB1/database"
 	"github.com/Joko206/UAS_PWEB1/models"
 	"github.com/gofiber/fiber/v2"
@@ -22,19 +26,8 @@ func GetKuis(c *fiber.Ctx) error {
 	return sendResponse(c, fiber.StatusOK, true, "Accessible quizzes retrieved successfully", result)
 }
 
-// GetAllKuis retrieves all kuis (admin only)
+// GetAllKuis retrieves all kuis (permission kuis.read_all, checked by the route)
 func GetAllKuis(c *fiber.Ctx) error {
-	// Authenticate the user using the JWT token
-	user, err := Authenticate(c)
-	if err != nil {
-		return err
-	}
-
-	// Check if user is admin
-	if user.Role != "admin" {
-		return sendResponse(c, fiber.StatusForbidden, false, "Access denied. Admin only.", nil)
-	}
-
 	result, err := database.GetKuis()
 	if err != nil {
 		return handleError(c, err, "Failed to retrieve quizzes")
@@ -79,6 +72,9 @@ func AddKuis(c *fiber.Ctx) error {
 	if err := db.First(&kelas, newKuis.Kelas_id).Error; err != nil {
 		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid Kelas ID", nil)
 	}
+	if err := requireKuisKelas(c, user, kelas); err != nil {
+		return err
+	}
 
 	// Validate Pendidikan
 	var pendidikan models.Pendidikan
@@ -87,8 +83,11 @@ func AddKuis(c *fiber.Ctx) error {
 	}
 
 	// Create Kuis using database function
-	result, err := database.CreateKuis(newKuis.Title, newKuis.Description, newKuis.IsPrivate, newKuis.Kategori_id, newKuis.Tingkatan_id, newKuis.Kelas_id, newKuis.Pendidikan_id, user.ID)
+	result, err := database.CreateKuis(newKuis.Title, newKuis.Description, newKuis.IsPrivate, newKuis.Kategori_id, newKuis.Tingkatan_id, newKuis.Kelas_id, newKuis.Pendidikan_id, user.ID, newKuis.KuisSettings)
 	if err != nil {
+		if errors.Is(err, database.ErrInvalidKuisSettings) {
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		}
 		return handleError(c, err, "Failed to create quiz")
 	}
 
@@ -96,19 +95,30 @@ func AddKuis(c *fiber.Ctx) error {
 }
 
 func UpdateKuis(c *fiber.Ctx) error {
-
-	id := c.Params("id")
-	if id == "" {
-		return sendResponse(c, fiber.StatusBadRequest, false, "ID cannot be empty", nil)
+	// Hanya pembuat kuis, guru kelasnya, atau admin yang boleh mengubah
+	user, kuis, err := authoredKuis(c)
+	if err != nil {
+		return err
 	}
+	id := strconv.FormatUint(uint64(kuis.ID), 10)
 
 	// Parse request body
 	newTask := new(models.Kuis)
-	err := c.BodyParser(newTask)
-	if err != nil {
+	if err := c.BodyParser(newTask); err != nil {
 		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
 	}
 
+	// Kuis hanya boleh dipindah ke kelas yang juga diajar
+	if newTask.Kelas_id != 0 && newTask.Kelas_id != kuis.Kelas_id {
+		kelas, err := database.GetKelasByID(newTask.Kelas_id)
+		if err != nil {
+			return sendResponse(c, fiber.StatusBadRequest, false, "Invalid Kelas ID", nil)
+		}
+		if err := requireKuisKelas(c, user, kelas); err != nil {
+			return err
+		}
+	}
+
 	result, err := database.UpdateKuis(newTask.Title, newTask.Description, newTask.IsPrivate, newTask.Kategori_id, newTask.Tingkatan_id, newTask.Kelas_id, newTask.Pendidikan_id, id)
 	if err != nil {
 		return handleError(c, err, "Failed to update quiz")
@@ -117,49 +127,113 @@ func UpdateKuis(c *fiber.Ctx) error {
 	return sendResponse(c, fiber.StatusOK, true, "Quiz updated successfully", result)
 }
 
-func DeleteKuis(c *fiber.Ctx) error {
+// UpdateKuisSettings replaces the attempt settings (time limit, ...) of a kuis
+func UpdateKuisSettings(c *fiber.Ctx) error {
+	_, kuis, err := authoredKuis(c)
+	if err != nil {
+		return err
+	}
+	id := strconv.FormatUint(uint64(kuis.ID), 10)
 
-	id := c.Params("id")
-	if id == "" {
-		return sendResponse(c, fiber.StatusBadRequest, false, "ID cannot be empty", nil)
+	settings := new(models.KuisSettings)
+	if err := c.BodyParser(settings); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
 	}
 
-	err := database.DeleteKuis(id)
+	result, err := database.UpdateKuisSettings(id, *settings)
 	if err != nil {
-		return handleError(c, err, "Failed to delete quiz")
+		if errors.Is(err, database.ErrInvalidKuisSettings) {
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		}
+		if errors.Is(err, database.ErrKuisNotFound) {
+			return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to update quiz settings")
 	}
 
-	return sendResponse(c, fiber.StatusOK, true, "Quiz deleted successfully", nil)
+	message := "Quiz settings updated successfully"
+	if result.DraftChanges {
+		message = "Quiz settings saved to the draft; publish the quiz to apply them"
+	}
+	return sendResponse(c, fiber.StatusOK, true, message, result)
 }
-func FilterKuis(c *fiber.Ctx) error {
-	// Ambil parameter dari query string
-	kategoriID := c.Query("kategori_id")     // Misalnya ?kategori_id=1
-	tingkatanID := c.Query("tingkatan_id")   // Misalnya ?tingkatan_id=1
-	pendidikanID := c.Query("pendidikan_id") // Misalnya ?pendidikan_id=1
 
-	// Membuat query untuk filter
-	var kuis []models.Kuis
-	query := database.DB.Model(&models.Kuis{})
+// PublishKuis menyalin kuis, pengaturan, dan soalnya menjadi versi baru yang dikerjakan siswa
+func PublishKuis(c *fiber.Ctx) error {
+	user, kuis, err := authoredKuis(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := database.PublishKuis(kuis.ID, user.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrKuisHasNoQuestion) {
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to publish quiz")
+	}
+
+	LogAudit(user.ID, "publish_kuis", fmt.Sprintf("kuis:%d", kuis.ID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Quiz published successfully", version)
+}
 
-	// Jika kategori_id disediakan, filter berdasarkan kategori_id
-	if kategoriID != "" {
-		query = query.Where("kategori_id = ?", kategoriID)
+// GetKuisVersions mengembalikan riwayat versi kuis yang pernah dipublikasikan
+func GetKuisVersions(c *fiber.Ctx) error {
+	_, kuis, err := authoredKuis(c)
+	if err != nil {
+		return err
 	}
 
-	// Jika tingkatan_id disediakan, filter berdasarkan tingkatan_id
-	if tingkatanID != "" {
-		query = query.Where("tingkatan_id = ?", tingkatanID)
+	versions, err := database.GetKuisVersions(kuis.ID)
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve quiz versions")
 	}
 
-	// Jika pendidikan_id disediakan, filter berdasarkan pendidikan_id
-	if pendidikanID != "" {
-		query = query.Where("pendidikan_id = ?", pendidikanID)
+	return sendResponse(c, fiber.StatusOK, true, "Quiz versions retrieved successfully", versions)
+}
+
+func DeleteKuis(c *fiber.Ctx) error {
+	_, kuis, err := authoredKuis(c)
+	if err != nil {
+		return err
+	}
+
+	if err := database.DeleteKuis(strconv.FormatUint(uint64(kuis.ID), 10)); err != nil {
+		return handleError(c, err, "Failed to delete quiz")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Quiz deleted successfully", nil)
+}
+
+// FilterKuis retrieves the accessible kuis of one kategori, tingkatan or pendidikan, with the same rules as GetKuis
+func FilterKuis(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
 	}
 
-	// Menjalankan query untuk mendapatkan kuis yang sesuai dengan filter
-	err := query.Find(&kuis).Error
+	// Ambil parameter dari query string, misalnya ?kategori_id=1&tingkatan_id=1&pendidikan_id=1
+	var filter database.KuisFilter
+	for param, target := range map[string]*uint{
+		"kategori_id":   &filter.Kategori_id,
+		"tingkatan_id":  &filter.Tingkatan_id,
+		"pendidikan_id": &filter.Pendidikan_id,
+	} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return sendResponse(c, fiber.StatusBadRequest, false, "Invalid "+param, nil)
+		}
+		*target = uint(id)
+	}
+
+	// Draft, kuis privat kelas lain dan kuis yang belum dibuka tidak ikut, sama seperti GetKuis
+	kuis, err := database.FilterKuisForUser(user.ID, filter)
 	if err != nil {
-		return sendResponse(c, fiber.StatusInternalServerError, false, "Failed to fetch quizzes", nil)
+		return handleError(c, err, "Failed to fetch quizzes")
 	}
 
 	// Mengembalikan daftar kuis yang telah difilter
@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListUsers menampilkan user dengan pagination dan filter role, nama, email, status kunci, dan tanggal daftar (admin only)
+func ListUsers(c *fiber.Ctx) error {
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "10"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	filter := database.UserFilter{
+		Role:    c.Query("role"),
+		Name:    c.Query("name"),
+		Email:   c.Query("email"),
+		Deleted: c.Query("deleted") == "true",
+		Page:    page,
+		Limit:   limit,
+	}
+	if locked := c.Query("locked"); locked != "" {
+		value := locked == "true"
+		filter.Locked = &value
+	}
+	if dateFrom := c.Query("created_from"); dateFrom != "" {
+		from, err := time.Parse("2006-01-02", dateFrom)
+		if err != nil {
+			return sendResponse(c, fiber.StatusBadRequest, false, "Invalid created_from format. Use YYYY-MM-DD", nil)
+		}
+		filter.CreatedFrom = &from
+	}
+	if dateTo := c.Query("created_to"); dateTo != "" {
+		to, err := time.Parse("2006-01-02", dateTo)
+		if err != nil {
+			return sendResponse(c, fiber.StatusBadRequest, false, "Invalid created_to format. Use YYYY-MM-DD", nil)
+		}
+		// Set to end of day
+		to = to.Add(24*time.Hour - time.Second)
+		filter.CreatedTo = &to
+	}
+
+	users, total, err := database.ListUsers(filter)
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve users")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Users retrieved successfully", fiber.Map{
+		"users": users,
+		"pagination": fiber.Map{
+			"page":       page,
+			"limit":      limit,
+			"total":      total,
+			"totalPages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// GetUserDetail menampilkan satu user beserta statistik aktivitasnya
+func GetUserDetail(c *fiber.Ctx) error {
+	userID, err := userIDParam(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := database.GetUserByID(userID)
+	if err != nil {
+		return userManagementError(c, err, "Failed to retrieve user")
+	}
+
+	stats, err := database.GetUserStats(userID)
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve user stats")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "User retrieved successfully", fiber.Map{"user": user, "stats": stats})
+}
+
+// UpdateUserDetails mengubah nama, email, dan status verifikasi email user
+func UpdateUserDetails(c *fiber.Ctx) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+	userID, err := userIDParam(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Name          *string `json:"name"`
+		Email         *string `json:"email"`
+		EmailVerified *bool   `json:"email_verified"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+	if (body.Name != nil && *body.Name == "") || (body.Email != nil && *body.Email == "") {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Name and email cannot be empty", nil)
+	}
+
+	user, err := database.UpdateUserDetails(userID, body.Name, body.Email, body.EmailVerified)
+	if err != nil {
+		return userManagementError(c, err, "Failed to update user")
+	}
+
+	LogAudit(admin.ID, "admin_update_user", fmt.Sprintf("user:%d", userID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "User updated successfully", user)
+}
+
+// LockUser mengunci akun secara manual sampai waktu tertentu (`minutes`) atau sampai dibuka admin
+func LockUser(c *fiber.Ctx) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+	userID, err := userIDParam(c)
+	if err != nil {
+		return err
+	}
+	if userID == admin.ID {
+		return sendResponse(c, fiber.StatusBadRequest, false, database.ErrCannotTargetSelf.Error(), nil)
+	}
+
+	var body struct {
+		Minutes int `json:"minutes"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+		}
+	}
+	if body.Minutes < 0 {
+		return sendResponse(c, fiber.StatusBadRequest, false, "minutes must be positive", nil)
+	}
+
+	var until *time.Time
+	if body.Minutes > 0 {
+		lockTime := time.Now().Add(time.Duration(body.Minutes) * time.Minute)
+		until = &lockTime
+	}
+
+	user, err := database.LockUser(userID, until)
+	if err != nil {
+		return userManagementError(c, err, "Failed to lock user")
+	}
+
+	LogAudit(admin.ID, "admin_lock_user", fmt.Sprintf("user:%d", userID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "User locked successfully", user)
+}
+
+// UnlockUser membuka kunci akun dan mereset percobaan login yang gagal
+func UnlockUser(c *fiber.Ctx) error {
+	return updateAccount(c, database.UnlockUser, "admin_unlock_user", "User unlocked successfully")
+}
+
+// ResetFailedAttempts mereset jumlah percobaan login yang gagal
+func ResetFailedAttempts(c *fiber.Ctx) error {
+	return updateAccount(c, database.ResetFailedAttempts, "admin_reset_failed_attempts", "Failed attempts reset successfully")
+}
+
+// RestoreUser mengembalikan user yang sudah dihapus
+func RestoreUser(c *fiber.Ctx) error {
+	return updateAccount(c, database.RestoreUser, "admin_restore_user", "User restored successfully")
+}
+
+// DeleteUser menghapus user (soft delete) dan mencabut semua session-nya
+func DeleteUser(c *fiber.Ctx) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+	userID, err := userIDParam(c)
+	if err != nil {
+		return err
+	}
+	if userID == admin.ID {
+		return sendResponse(c, fiber.StatusBadRequest, false, database.ErrCannotTargetSelf.Error(), nil)
+	}
+
+	if err := database.DeleteUser(userID); err != nil {
+		return userManagementError(c, err, "Failed to delete user")
+	}
+
+	LogAudit(admin.ID, "admin_delete_user", fmt.Sprintf("user:%d", userID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "User deleted successfully", nil)
+}
+
+// updateAccount runs an account change on the user in the URL and audits it
+func updateAccount(c *fiber.Ctx, change func(uint) (models.Users, error), action string, message string) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+	userID, err := userIDParam(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := change(userID)
+	if err != nil {
+		return userManagementError(c, err, "Failed to update user")
+	}
+
+	LogAudit(admin.ID, action, fmt.Sprintf("user:%d", userID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, message, user)
+}
+
+// userIDParam reads the :id of the user being managed
+func userIDParam(c *fiber.Ctx) (uint, error) {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusNotFound, database.ErrUserNotFound.Error())
+	}
+	return uint(id), nil
+}
+
+// userManagementError maps the user management errors from the database package to HTTP responses
+func userManagementError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+	case errors.Is(err, database.ErrEmailTaken), errors.Is(err, database.ErrUserNotDeleted), errors.Is(err, database.ErrLastAdmin):
+		return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+	}
+	return handleError(c, err, message)
+}
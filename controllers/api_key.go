@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Batas masa berlaku API key; key tanpa expires_in_days berlaku 90 hari
+const (
+	defaultAPIKeyDays = 90
+	maxAPIKeyDays     = 365
+)
+
+// apiKeyScopes menentukan scope yang dibutuhkan API key untuk setiap route. Route yang tidak
+// ada di sini, seperti pengelolaan akun dan API key itu sendiri, tidak bisa diakses dengan API key.
+var apiKeyScopes = []struct {
+	method string // kosong untuk semua method
+	prefix string
+	scope  string
+}{
+	{fiber.MethodGet, "/hasil-kuis/", models.ScopeResultsRead},
+	{fiber.MethodGet, "/grading/queue", models.ScopeResultsRead},
+	{"", "/soal/", models.ScopeSoalManage},
+	{"", "/kuis/", models.ScopeKuisManage},
+}
+
+// requiredScope returns the scope an API key needs for the request, or "" if API keys cannot be used
+func requiredScope(c *fiber.Ctx) string {
+	for _, route := range apiKeyScopes {
+		if (route.method == "" || route.method == c.Method()) && strings.HasPrefix(c.Path(), route.prefix) {
+			return route.scope
+		}
+	}
+	return ""
+}
+
+// authenticateAPIKey checks an API key from the Authorization header against the scope of the route
+func authenticateAPIKey(c *fiber.Ctx, key string) (*models.Users, error) {
+	// Authenticate dipanggil beberapa kali per request (middleware dan handler)
+	if user, ok := c.Locals("api_key_user").(*models.Users); ok {
+		return user, nil
+	}
+
+	apiKey, user, err := database.AuthenticateAPIKey(key)
+	if err != nil {
+		if errors.Is(err, database.ErrAPIKeyInvalid) {
+			LogAudit(0, "api_key_auth", "authentication", "failure", c)
+			return nil, fiber.NewError(fiber.StatusUnauthorized, err.Error())
+		}
+		if errors.Is(err, database.ErrAPIKeyUserLocked) {
+			LogAudit(user.ID, "api_key_auth_locked", fmt.Sprintf("api_key:%d", apiKey.ID), "failure", c)
+			return nil, fiber.NewError(fiber.StatusForbidden, err.Error())
+		}
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to check API key")
+	}
+
+	scope := requiredScope(c)
+	if scope == "" || !apiKey.HasScope(scope) {
+		LogAudit(user.ID, "api_key_auth", fmt.Sprintf("api_key:%d path:%s", apiKey.ID, c.Path()), "failure", c)
+		return nil, fiber.NewError(fiber.StatusForbidden, "API key does not have the scope for this endpoint")
+	}
+
+	c.Locals("api_key_id", apiKey.ID)
+	c.Locals("api_key_user", &user)
+	return &user, nil
+}
+
+// CreateAPIKey membuat API key baru; key hanya ditampilkan sekali di response ini
+func CreateAPIKey(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays *int     `json:"expires_in_days"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Name is required", nil)
+	}
+
+	days := defaultAPIKeyDays
+	if body.ExpiresInDays != nil {
+		days = *body.ExpiresInDays
+	}
+	if days < 1 || days > maxAPIKeyDays {
+		return sendResponse(c, fiber.StatusBadRequest, false, fmt.Sprintf("expires_in_days must be between 1 and %d", maxAPIKeyDays), nil)
+	}
+	expiresAt := time.Now().AddDate(0, 0, days)
+
+	apiKey, key, err := database.CreateAPIKey(*user, body.Name, body.Scopes, &expiresAt)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidScope) {
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), fiber.Map{"available_scopes": database.APIScopes})
+		}
+		return handleError(c, err, "Failed to create API key")
+	}
+
+	LogAudit(user.ID, "api_key_created", fmt.Sprintf("api_key:%d scopes:%s", apiKey.ID, strings.Join(apiKey.ScopeList(), ",")), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "API key created. Copy it now, it will not be shown again", fiber.Map{
+		"api_key": apiKey,
+		"key":     key,
+	})
+}
+
+// GetAPIKeys menampilkan API key milik user yang sedang login
+func GetAPIKeys(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	keys, err := database.GetUserAPIKeys(user.ID)
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve API keys")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "API keys retrieved successfully", keys)
+}
+
+// RevokeAPIKey mencabut salah satu API key milik user yang sedang login
+func RevokeAPIKey(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	keyID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, database.ErrAPIKeyNotFound.Error(), nil)
+	}
+
+	apiKey, err := database.RevokeAPIKey(user.ID, uint(keyID))
+	if err != nil {
+		if errors.Is(err, database.ErrAPIKeyNotFound) {
+			return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to revoke API key")
+	}
+
+	LogAudit(user.ID, "api_key_revoked", fmt.Sprintf("api_key:%d", apiKey.ID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "API key revoked successfully", apiKey)
+}
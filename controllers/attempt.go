@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// StartAttempt memulai (atau melanjutkan) sesi pengerjaan kuis untuk user yang login
+func StartAttempt(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var requestData struct {
+		Kuis_id uint `json:"kuis_id"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+	if requestData.Kuis_id == 0 {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Kuis ID is required", nil)
+	}
+
+	attempt, err := database.StartAttempt(user.ID, requestData.Kuis_id)
+	if err != nil {
+		return attemptError(c, err, "Failed to start attempt")
+	}
+
+	attempt.RemainingSeconds = database.AttemptRemainingSeconds(attempt)
+	return sendResponse(c, fiber.StatusOK, true, "Attempt started successfully", attempt)
+}
+
+// GetAttempt mengembalikan status attempt beserta sisa waktunya
+func GetAttempt(c *fiber.Ctx) error {
+	attempt, err := ownAttempt(c)
+	if err != nil {
+		return attemptError(c, err, "Failed to retrieve attempt")
+	}
+
+	// Attempt yang sudah lewat batas waktu langsung diselesaikan
+	if attempt.Status == models.AttemptInProgress {
+		if remaining := database.AttemptRemainingSeconds(attempt); remaining != nil && *remaining == 0 {
+			if finished, err := database.FinishAttempt(attempt.ID); err == nil {
+				attempt = finished
+			}
+		}
+	}
+
+	attempt.RemainingSeconds = database.AttemptRemainingSeconds(attempt)
+	return sendResponse(c, fiber.StatusOK, true, "Attempt retrieved successfully", attemptWithAnswers(attempt))
+}
+
+// GetAttemptSoal mengembalikan soal attempt sesuai urutan dan label pilihan yang diacak untuk attempt ini
+func GetAttemptSoal(c *fiber.Ctx) error {
+	attempt, err := ownAttempt(c)
+	if err != nil {
+		return attemptError(c, err, "Failed to retrieve attempt questions")
+	}
+
+	soalList, err := database.GetAttemptSoal(attempt)
+	if err != nil {
+		return attemptError(c, err, "Failed to retrieve attempt questions")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Attempt questions retrieved successfully", soalList)
+}
+
+// SaveAttemptAnswer menyimpan jawaban satu soal di dalam attempt yang sedang berjalan
+func SaveAttemptAnswer(c *fiber.Ctx) error {
+	attempt, err := ownAttempt(c)
+	if err != nil {
+		return attemptError(c, err, "Failed to save answer")
+	}
+
+	var requestData struct {
+		Soal_id uint            `json:"soal_id"`
+		Answer  json.RawMessage `json:"answer"` // bentuknya mengikuti tipe soal
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+	if requestData.Soal_id == 0 {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Soal ID is required", nil)
+	}
+
+	answer, err := database.SaveAttemptAnswer(attempt.ID, requestData.Soal_id, requestData.Answer)
+	if err != nil {
+		return attemptError(c, err, "Failed to save answer")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Answer saved successfully", answer)
+}
+
+// FinishAttempt menilai attempt dan menutupnya
+func FinishAttempt(c *fiber.Ctx) error {
+	attempt, err := ownAttempt(c)
+	if err != nil {
+		return attemptError(c, err, "Failed to finish attempt")
+	}
+
+	result, err := database.FinishAttempt(attempt.ID)
+	if err != nil {
+		return attemptError(c, err, "Failed to finish attempt")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Attempt finished successfully", result)
+}
+
+// attemptWithAnswers attaches the saved answers to an attempt for the response
+func attemptWithAnswers(attempt models.KuisAttempt) models.KuisAttempt {
+	if answers, err := database.GetAttemptAnswers(attempt.ID); err == nil {
+		attempt.Answers = answers
+	}
+	return attempt
+}
+
+// ownAttempt loads the attempt from the :attempt_id param and checks that it belongs to the caller
+func ownAttempt(c *fiber.Ctx) (models.KuisAttempt, error) {
+	user, err := Authenticate(c)
+	if err != nil {
+		return models.KuisAttempt{}, err
+	}
+
+	id, err := strconv.ParseUint(c.Params("attempt_id"), 10, 64)
+	if err != nil {
+		return models.KuisAttempt{}, database.ErrAttemptNotFound
+	}
+
+	attempt, err := database.GetAttempt(uint(id))
+	if err != nil {
+		return attempt, err
+	}
+
+	// Attempt milik user lain diperlakukan seperti tidak ada
+	if attempt.Users_id != user.ID {
+		return models.KuisAttempt{}, database.ErrAttemptNotFound
+	}
+
+	return attempt, nil
+}
+
+// attemptError maps the attempt errors from the database package to HTTP responses
+func attemptError(c *fiber.Ctx, err error, message string) error {
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &fiberErr):
+		return sendResponse(c, fiberErr.Code, false, fiberErr.Message, nil)
+	case database.KuisRuleCode(err) != "":
+		// Jadwal dan batas attempt dikembalikan dengan kode yang bisa dibaca aplikasi
+		status := fiber.StatusForbidden
+		if errors.Is(err, database.ErrAttemptCooldown) {
+			status = fiber.StatusTooManyRequests
+		}
+		return sendResponse(c, status, false, err.Error(), fiber.Map{"code": database.KuisRuleCode(err)})
+	case errors.Is(err, database.ErrAttemptRequired):
+		return sendResponse(c, fiber.StatusConflict, false, err.Error(), fiber.Map{"code": "attempt_required"})
+	case errors.Is(err, database.ErrAttemptNotFound), errors.Is(err, database.ErrKuisNotFound):
+		return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+	case errors.Is(err, database.ErrKuisAccessDenied):
+		return sendResponse(c, fiber.StatusForbidden, false, err.Error(), nil)
+	case errors.Is(err, database.ErrAttemptClosed), errors.Is(err, database.ErrAttemptExpired), errors.Is(err, database.ErrKuisNotPublished):
+		return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+	case errors.Is(err, database.ErrSoalNotInKuis), errors.Is(err, database.ErrKuisHasNoQuestion), errors.Is(err, database.ErrInvalidAnswer):
+		return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+	}
+	return handleError(c, err, message)
+}
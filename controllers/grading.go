@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetGradingQueue mengembalikan jawaban essay yang belum dinilai (guru: hanya kuis buatannya dan kuis di kelas yang diajarnya)
+func GetGradingQueue(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	// Role dengan content.manage_any melihat antrian semua guru
+	var teacherID uint
+	if !hasPermission(user, models.PermContentManageAny) {
+		teacherID = user.ID
+	}
+
+	kuisID, _ := strconv.ParseUint(c.Query("kuis_id"), 10, 64)
+
+	result, err := database.GetGradingQueue(teacherID, uint(kuisID))
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve grading queue")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Grading queue retrieved successfully", result)
+}
+
+// GradeEssayAnswer memberi nilai dan feedback untuk satu jawaban essay
+func GradeEssayAnswer(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseUint(c.Params("answer_id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid answer ID", nil)
+	}
+
+	var requestData struct {
+		Points   *float64 `json:"points"`
+		Feedback string   `json:"feedback"`
+	}
+	if err := c.BodyParser(&requestData); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+	if requestData.Points == nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Points are required", nil)
+	}
+
+	// Hanya pembuat kuis, guru kelasnya, atau admin yang boleh menilai
+	answer, err := database.GetSoalAnswer(uint(id))
+	if err != nil {
+		return gradingError(c, err)
+	}
+	// Soal bisa dipindah ke kuis lain; yang menentukan adalah kuis dari attempt-nya
+	kuisID := answer.Soal.Kuis_id
+	if answer.Attempt_id != nil {
+		kuisID = answer.Attempt.Kuis_id
+	}
+	kuis, err := database.GetKuisByID(kuisID)
+	if err != nil {
+		return gradingError(c, err)
+	}
+	if err := requireKuisAuthor(c, user, kuis); err != nil {
+		return err
+	}
+
+	result, err := database.GradeEssayAnswer(answer.ID, *requestData.Points, requestData.Feedback, user.ID)
+	if err != nil {
+		return gradingError(c, err)
+	}
+
+	LogAudit(user.ID, "grade_essay", fmt.Sprintf("soal_answer:%d", result.ID), "success", c)
+
+	return sendResponse(c, fiber.StatusOK, true, "Answer graded successfully", result)
+}
+
+// gradingError maps the manual grading errors to HTTP responses
+func gradingError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, database.ErrAnswerNotFound), errors.Is(err, database.ErrKuisNotFound):
+		return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+	case errors.Is(err, database.ErrNotEssayAnswer), errors.Is(err, database.ErrInvalidPoints):
+		return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+	case errors.Is(err, database.ErrAttemptNotFinished):
+		return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+	}
+	return handleError(c, err, "Failed to grade answer")
+}
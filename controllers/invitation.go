@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/notifier"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Masa berlaku undangan jika admin tidak menentukan expires_in_hours
+const defaultInvitationHours = 72
+
+// CreateInvitation membuat undangan untuk akun dengan role selain student
+func CreateInvitation(c *fiber.Ctx) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Role           string `json:"role"`
+		Email          string `json:"email"`
+		ExpiresInHours int    `json:"expires_in_hours"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+	if body.ExpiresInHours < 0 {
+		return sendResponse(c, fiber.StatusBadRequest, false, "expires_in_hours must be positive", nil)
+	}
+	if body.ExpiresInHours == 0 {
+		body.ExpiresInHours = defaultInvitationHours
+	}
+
+	invitation, token, err := database.CreateInvitation(body.Role, body.Email, time.Duration(body.ExpiresInHours)*time.Hour, admin.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidRole) {
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		}
+		if errors.Is(err, database.ErrRoleNotGrantable) {
+			LogAudit(admin.ID, "create_invitation", "role:"+body.Role, "failure", c)
+			return sendResponse(c, fiber.StatusForbidden, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to create invitation")
+	}
+
+	// Undangan yang terikat ke email langsung dikirim; token tetap dikembalikan ke admin
+	link := frontendLink("INVITATION_URL", "http://localhost:5173/register", "invitation_token", token)
+	sent := false
+	if invitation.Email != "" {
+		err := notifier.Default().Send(notifier.Message{
+			To:      invitation.Email,
+			Subject: "Undangan BrainQuiz",
+			Body: fmt.Sprintf("Halo,\n\nKamu diundang untuk bergabung di BrainQuiz sebagai %s. Daftar melalui link berikut sebelum %s:\n%s",
+				invitation.Role, invitation.ExpiresAt.Format("2 January 2006 15:04"), link),
+		})
+		sent = err == nil
+	}
+
+	LogAudit(admin.ID, "create_invitation", fmt.Sprintf("invitation:%d role:%s email:%s", invitation.ID, invitation.Role, invitation.Email), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Invitation created successfully", fiber.Map{
+		"invitation": invitation,
+		"token":      token,
+		"link":       link,
+		"email_sent": sent,
+	})
+}
+
+// GetInvitations menampilkan semua undangan
+func GetInvitations(c *fiber.Ctx) error {
+	invitations, err := database.GetInvitations()
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve invitations")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Invitations retrieved successfully", invitations)
+}
+
+// RevokeInvitation membatalkan undangan yang belum dipakai
+func RevokeInvitation(c *fiber.Ctx) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, database.ErrInvitationNotFound.Error(), nil)
+	}
+
+	invitation, err := database.RevokeInvitation(uint(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrInvitationNotFound):
+			return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+		case errors.Is(err, database.ErrInvitationInvalid):
+			return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to revoke invitation")
+	}
+
+	LogAudit(admin.ID, "revoke_invitation", fmt.Sprintf("invitation:%d", invitation.ID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Invitation revoked successfully", invitation)
+}
+
+// ChangeUserRole menaikkan atau menurunkan role user
+func ChangeUserRole(c *fiber.Ctx) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	userID, err := strconv.ParseUint(c.Params("user_id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, database.ErrUserNotFound.Error(), nil)
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	user, previous, err := database.ChangeUserRole(uint(userID), body.Role, admin.ID)
+	if err != nil {
+		resource := fmt.Sprintf("user:%d role:->%s", userID, body.Role)
+		switch {
+		case errors.Is(err, database.ErrUserNotFound):
+			return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+		case errors.Is(err, database.ErrInvalidRole), errors.Is(err, database.ErrRoleUnchanged):
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		case errors.Is(err, database.ErrLastAdmin):
+			LogAudit(admin.ID, "role_change", resource, "failure", c)
+			return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+		case errors.Is(err, database.ErrRoleNotGrantable):
+			LogAudit(admin.ID, "role_change", resource, "failure", c)
+			return sendResponse(c, fiber.StatusForbidden, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to change role")
+	}
+
+	LogAudit(admin.ID, "role_change", fmt.Sprintf("user:%d role:%s->%s", user.ID, previous, user.Role), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Role changed successfully", user)
+}
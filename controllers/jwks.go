@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"github.com/Joko206/UAS_PWEB1/jwtkeys"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetJWKS publishes the public keys that verify access tokens, so other services can check them
+// without sharing a secret. The response is a plain JWK Set as expected by JWT libraries.
+func GetJWKS(c *fiber.Ctx) error {
+	keys, err := jwtkeys.Default()
+	if err != nil {
+		return handleError(c, err, "Token signing is not configured")
+	}
+
+	// Key yang dirotasi tetap dipublikasikan, jadi cache singkat sudah cukup
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(fiber.Map{"keys": keys.JWKS()})
+}
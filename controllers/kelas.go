@@ -1,6 +1,10 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+
 	"github.com/Joko206/UAS_PWEB1/database"
 	"github.com/Joko206/UAS_PWEB1/models"
 	"github.com/gofiber/fiber/v2"
@@ -42,12 +46,12 @@ func AddKelas(c *fiber.Ctx) error {
 }
 
 func UpdateKelas(c *fiber.Ctx) error {
-	// Authenticate the user using the JWT token
-
-	id := c.Params("id")
-	if id == "" {
-		return sendResponse(c, fiber.StatusBadRequest, false, "ID cannot be empty", nil)
+	// Hanya pembuat kelas, co-teacher, atau admin yang boleh mengubah
+	_, kelas, err := managedKelas(c)
+	if err != nil {
+		return err
 	}
+	id := strconv.FormatUint(uint64(kelas.ID), 10)
 
 	newTask := new(models.Kelas)
 	if err := c.BodyParser(newTask); err != nil {
@@ -63,15 +67,12 @@ func UpdateKelas(c *fiber.Ctx) error {
 }
 
 func DeleteKelas(c *fiber.Ctx) error {
-	// Authenticate the user using the JWT token
-
-	id := c.Params("id")
-	if id == "" {
-		return sendResponse(c, fiber.StatusBadRequest, false, "ID cannot be empty", nil)
+	_, kelas, err := managedKelas(c)
+	if err != nil {
+		return err
 	}
 
-	err := database.DeleteKelas(id)
-	if err != nil {
+	if err := database.DeleteKelas(strconv.FormatUint(uint64(kelas.ID), 10)); err != nil {
 		return handleError(c, err, "Failed to delete class")
 	}
 
@@ -112,3 +113,90 @@ func JoinKelasByCode(c *fiber.Ctx) error {
 
 	return sendResponse(c, fiber.StatusOK, true, "Successfully joined class", kelas)
 }
+
+// GetKelasTeachers menampilkan co-teacher sebuah kelas
+func GetKelasTeachers(c *fiber.Ctx) error {
+	_, kelas, err := managedKelas(c)
+	if err != nil {
+		return err
+	}
+
+	teachers, err := database.GetKelasTeachers(kelas.ID)
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve class teachers")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Class teachers retrieved successfully", fiber.Map{
+		"created_by": kelas.CreatedBy,
+		"teachers":   teachers,
+	})
+}
+
+// AddKelasTeacher menambahkan guru lain sebagai co-teacher; hanya pembuat kelas atau admin
+func AddKelasTeacher(c *fiber.Ctx) error {
+	user, kelas, err := kelasOwner(c)
+	if err != nil {
+		return err
+	}
+
+	var requestData struct {
+		User_id uint `json:"user_id"`
+	}
+	if err := c.BodyParser(&requestData); err != nil || requestData.User_id == 0 {
+		return sendResponse(c, fiber.StatusBadRequest, false, "user_id is required", nil)
+	}
+
+	teacher, err := database.AddKelasTeacher(kelas, requestData.User_id, user.ID)
+	if err != nil {
+		return kelasTeacherError(c, err)
+	}
+
+	LogAudit(user.ID, "kelas_teacher_added", fmt.Sprintf("kelas:%d user:%d", kelas.ID, teacher.Users_id), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Co-teacher added successfully", teacher)
+}
+
+// RemoveKelasTeacher mencabut co-teacher dari kelas; hanya pembuat kelas atau admin
+func RemoveKelasTeacher(c *fiber.Ctx) error {
+	user, kelas, err := kelasOwner(c)
+	if err != nil {
+		return err
+	}
+
+	teacherID, err := strconv.ParseUint(c.Params("user_id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, database.ErrCoTeacherNotFound.Error(), nil)
+	}
+
+	if err := database.RemoveKelasTeacher(kelas, uint(teacherID)); err != nil {
+		return kelasTeacherError(c, err)
+	}
+
+	LogAudit(user.ID, "kelas_teacher_removed", fmt.Sprintf("kelas:%d user:%d", kelas.ID, teacherID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Co-teacher removed successfully", nil)
+}
+
+// kelasOwner is managedKelas for actions that co-teachers may not do themselves
+func kelasOwner(c *fiber.Ctx) (*models.Users, models.Kelas, error) {
+	user, kelas, err := managedKelas(c)
+	if err != nil {
+		return nil, kelas, err
+	}
+	if kelas.CreatedBy != user.ID && !hasPermission(user, models.PermContentManageAny) {
+		LogAudit(user.ID, "ownership_denied", fmt.Sprintf("kelas:%d", kelas.ID), "failure", c)
+		return nil, kelas, fiber.NewError(fiber.StatusForbidden, "Only the creator of the kelas can manage its teachers")
+	}
+	return user, kelas, nil
+}
+
+// kelasTeacherError maps the co-teacher errors from the database package to HTTP responses
+func kelasTeacherError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, database.ErrUserNotFound), errors.Is(err, database.ErrCoTeacherNotFound):
+		return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+	case errors.Is(err, database.ErrNotTeacher), errors.Is(err, database.ErrCannotRemoveCreator):
+		return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+	case errors.Is(err, database.ErrAlreadyTeacher):
+		return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+	}
+	return handleError(c, err, "Failed to update class teachers")
+}
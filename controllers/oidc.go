@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/oidc"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Waktu bagi user untuk login di identity provider sebelum state-nya kedaluwarsa
+const oidcStateTTL = 10 * time.Minute
+
+// oidcProvider returns the configured provider with the given name
+func oidcProvider(name string) (*oidc.Provider, error) {
+	providers, err := oidc.Default()
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Identity providers are misconfigured")
+	}
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Identity provider not found")
+	}
+	return provider, nil
+}
+
+// GetOIDCProviders lists the identity providers that can be used to log in
+func GetOIDCProviders(c *fiber.Ctx) error {
+	providers, err := oidc.Default()
+	if err != nil {
+		return handleError(c, err, "Identity providers are misconfigured")
+	}
+	return sendResponse(c, fiber.StatusOK, true, "Identity providers retrieved successfully", oidc.Names(providers))
+}
+
+// OIDCLogin starts a login at an identity provider: the user is redirected to the provider,
+// or with ?redirect=false the authorization URL is returned for the frontend to open
+func OIDCLogin(c *fiber.Ctx) error {
+	provider, err := oidcProvider(c.Params("provider"))
+	if err != nil {
+		return err
+	}
+
+	nonce, err := oidc.NewVerifier()
+	if err != nil {
+		return handleError(c, err, "Failed to start login")
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return handleError(c, err, "Failed to start login")
+	}
+	state, err := database.CreateOIDCLoginState(provider.Name, nonce, verifier, oidcStateTTL)
+	if err != nil {
+		return handleError(c, err, "Failed to start login")
+	}
+
+	authURL, err := provider.AuthCodeURL(c.UserContext(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC discovery for %s failed: %v", provider.Name, err)
+		return sendResponse(c, fiber.StatusBadGateway, false, "Identity provider is not reachable", nil)
+	}
+
+	if c.Query("redirect") == "false" {
+		return sendResponse(c, fiber.StatusOK, true, "Authorization URL created", fiber.Map{
+			"authorization_url": authURL,
+			"expires_in":        int(oidcStateTTL.Seconds()),
+		})
+	}
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// OIDCCallback finishes a login with the code and state the provider sent back,
+// either as query parameters or in the request body
+func OIDCCallback(c *fiber.Ctx) error {
+	data := map[string]string{
+		"code":              c.Query("code"),
+		"state":             c.Query("state"),
+		"error":             c.Query("error"),
+		"error_description": c.Query("error_description"),
+	}
+	if c.Method() == fiber.MethodPost {
+		if err := c.BodyParser(&data); err != nil {
+			return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+		}
+	}
+
+	if data["error"] != "" {
+		LogAudit(0, "failed_oidc_login", "authentication", "failure", c)
+		return sendResponse(c, fiber.StatusUnauthorized, false, "Login was cancelled at the identity provider",
+			fiber.Map{"error": data["error"], "error_description": data["error_description"]})
+	}
+	if data["code"] == "" || data["state"] == "" {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Code and state are required", nil)
+	}
+
+	// State hanya bisa dipakai sekali dan menentukan provider, nonce dan PKCE verifier
+	login, err := database.UseOIDCLoginState(data["state"])
+	if errors.Is(err, database.ErrOIDCStateInvalid) {
+		LogAudit(0, "failed_oidc_login", "authentication", "failure", c)
+		return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+	}
+	if err != nil {
+		return handleError(c, err, "Failed to finish login")
+	}
+
+	provider, err := oidcProvider(login.Provider)
+	if err != nil {
+		return err
+	}
+
+	claims, err := provider.Exchange(c.UserContext(), data["code"], login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("OIDC login with %s failed: %v", provider.Name, err)
+		LogAudit(0, "failed_oidc_login", "oidc:"+provider.Name, "failure", c)
+		return sendResponse(c, fiber.StatusUnauthorized, false, "Login at the identity provider could not be verified", nil)
+	}
+	if !provider.EmailAllowed(claims.Email) {
+		LogAudit(0, "failed_oidc_login_domain", "oidc:"+provider.Name, "failure", c)
+		return sendResponse(c, fiber.StatusForbidden, false, "This email domain is not allowed to log in", nil)
+	}
+
+	result, err := database.LinkOIDCIdentity(database.OIDCIdentity{
+		Provider:      provider.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Role:          provider.MappedRole(claims),
+	})
+	if errors.Is(err, database.ErrOIDCAccountLocked) {
+		// Penguncian akun tetap berlaku untuk login lewat provider
+		LogAudit(result.User.ID, "failed_login_locked", "authentication", "failure", c)
+		return lockedResponse(c, result.User)
+	}
+	if err != nil {
+		return oidcLinkError(c, provider.Name, err)
+	}
+	user := result.User
+
+	switch {
+	case result.Created:
+		LogAudit(user.ID, "oidc_user_created", "oidc:"+provider.Name, "success", c)
+	case result.Linked:
+		LogAudit(user.ID, "oidc_link", "oidc:"+provider.Name, "success", c)
+	}
+	if result.PreviousRole != "" {
+		LogAudit(user.ID, "role_change", fmt.Sprintf("user:%d role:%s->%s oidc:%s", user.ID, result.PreviousRole, user.Role, provider.Name), "success", c)
+	}
+	LogAudit(user.ID, "oidc_login", "oidc:"+provider.Name, "success", c)
+
+	// Dengan 2FA, token baru diberikan setelah kode dicek di langkah kedua
+	required, err := database.TwoFactorRequired(user.Role)
+	if err != nil {
+		return handleError(c, err, "Failed to check two-factor policy")
+	}
+	if user.TwoFactorEnabled || required {
+		return startTwoFactorLogin(c, user)
+	}
+
+	tokens, err := loginTokens(c, user)
+	if err != nil {
+		return handleError(c, err, "Failed to generate token")
+	}
+	tokens["created"] = result.Created
+	return sendResponse(c, fiber.StatusOK, true, "Login successful", tokens)
+}
+
+// oidcLinkError maps errors from linking an identity to a response
+func oidcLinkError(c *fiber.Ctx, provider string, err error) error {
+	switch {
+	case errors.Is(err, database.ErrOIDCEmailUnverified):
+		LogAudit(0, "failed_oidc_login_unverified", "oidc:"+provider, "failure", c)
+		return sendResponse(c, fiber.StatusForbidden, false, err.Error(), nil)
+	case errors.Is(err, database.ErrOIDCAccountUnavailable):
+		LogAudit(0, "failed_oidc_login_disabled", "oidc:"+provider, "failure", c)
+		return sendResponse(c, fiber.StatusForbidden, false, err.Error(), nil)
+	case errors.Is(err, database.ErrInvalidRole):
+		log.Printf("OIDC role mapping of %s points to an unknown role", provider)
+		return sendResponse(c, fiber.StatusInternalServerError, false, "Identity provider role mapping is misconfigured", nil)
+	case errors.Is(err, database.ErrLastAdmin):
+		return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+	}
+	return handleError(c, err, "Failed to finish login")
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// canAuthorKuis reports whether the user may modify a kuis and see its authoring view (with answer key):
+// roles with content.manage_any, and the kuis creator and the teachers of its kelas if their role may update kuis
+func canAuthorKuis(user *models.Users, kuis models.Kuis) (bool, error) {
+	switch {
+	case hasPermission(user, models.PermContentManageAny):
+		return true, nil
+	case !hasPermission(user, models.PermKuisUpdate) && !hasPermission(user, models.PermSoalUpdate):
+		return false, nil
+	case kuis.CreatedBy == user.ID:
+		return true, nil
+	}
+	return database.TeachesKelas(user.ID, kuis.Kelas_id)
+}
+
+// canManageKelas reports whether the user may modify a kelas: roles with content.manage_any,
+// and its creator and co-teachers if their role may update kelas
+func canManageKelas(user *models.Users, kelas models.Kelas) (bool, error) {
+	switch {
+	case hasPermission(user, models.PermContentManageAny):
+		return true, nil
+	case !hasPermission(user, models.PermKelasUpdate):
+		return false, nil
+	case kelas.CreatedBy == user.ID:
+		return true, nil
+	}
+	return database.TeachesKelas(user.ID, kelas.ID)
+}
+
+// ownershipResult turns an ownership check into the error a handler returns, auditing denials
+func ownershipResult(c *fiber.Ctx, user *models.Users, resource string, allowed bool, err error) error {
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to check permissions")
+	}
+	if !allowed {
+		LogAudit(user.ID, "ownership_denied", resource, "failure", c)
+		return fiber.NewError(fiber.StatusForbidden, "Only the creator or the teachers of the kelas can do this")
+	}
+	return nil
+}
+
+// requireKuisAuthor returns a 403 error unless the user may modify the kuis
+func requireKuisAuthor(c *fiber.Ctx, user *models.Users, kuis models.Kuis) error {
+	allowed, err := canAuthorKuis(user, kuis)
+	return ownershipResult(c, user, fmt.Sprintf("kuis:%d", kuis.ID), allowed, err)
+}
+
+// requireKuisKelas returns a 403 error unless the user may put a kuis in the kelas, so that nobody
+// adds a kuis to (or moves one into) a kelas they do not teach
+func requireKuisKelas(c *fiber.Ctx, user *models.Users, kelas models.Kelas) error {
+	allowed, err := canManageKelas(user, kelas)
+	return ownershipResult(c, user, fmt.Sprintf("kelas:%d", kelas.ID), allowed, err)
+}
+
+// authoredKuis loads the kuis from the :id param and checks that the caller may author it
+func authoredKuis(c *fiber.Ctx) (*models.Users, models.Kuis, error) {
+	user, err := Authenticate(c)
+	if err != nil {
+		return nil, models.Kuis{}, err
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, models.Kuis{}, fiber.NewError(fiber.StatusNotFound, "Kuis not found")
+	}
+
+	kuis, err := database.GetKuisByID(uint(id))
+	if err != nil {
+		return nil, models.Kuis{}, fiber.NewError(fiber.StatusNotFound, "Kuis not found")
+	}
+
+	if err := requireKuisAuthor(c, user, kuis); err != nil {
+		return nil, models.Kuis{}, err
+	}
+
+	return user, kuis, nil
+}
+
+// requireSoalAuthor checks that the user may author the kuis the soal belongs to
+func requireSoalAuthor(c *fiber.Ctx, user *models.Users, soalID uint) (models.Soal, error) {
+	db, err := database.GetDBConnection()
+	if err != nil {
+		return models.Soal{}, err
+	}
+
+	var soal models.Soal
+	if err := db.Preload("Kuis").First(&soal, soalID).Error; err != nil {
+		return soal, fiber.NewError(fiber.StatusNotFound, "Soal not found")
+	}
+
+	allowed, err := canAuthorKuis(user, soal.Kuis)
+	if err := ownershipResult(c, user, fmt.Sprintf("soal:%d", soal.ID), allowed, err); err != nil {
+		return soal, err
+	}
+	return soal, nil
+}
+
+// managedKelas loads the kelas from the :id param and checks that the caller may modify it
+func managedKelas(c *fiber.Ctx) (*models.Users, models.Kelas, error) {
+	user, err := Authenticate(c)
+	if err != nil {
+		return nil, models.Kelas{}, err
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, models.Kelas{}, fiber.NewError(fiber.StatusNotFound, database.ErrKelasNotFound.Error())
+	}
+
+	kelas, err := database.GetKelasByID(uint(id))
+	if err != nil {
+		return nil, models.Kelas{}, fiber.NewError(fiber.StatusNotFound, database.ErrKelasNotFound.Error())
+	}
+
+	allowed, err := canManageKelas(user, kelas)
+	if err := ownershipResult(c, user, fmt.Sprintf("kelas:%d", kelas.ID), allowed, err); err != nil {
+		return nil, models.Kelas{}, err
+	}
+
+	return user, kelas, nil
+}
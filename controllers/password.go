@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/Joko206/UAS_PWEB1/notifier"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Masa berlaku link reset password
+var passwordResetTTL = envDuration("PASSWORD_RESET_MINUTES", 30, time.Minute)
+
+// ForgotPassword mengirim link reset password ke email user.
+// Response selalu sama supaya tidak bisa dipakai untuk menebak email yang terdaftar.
+func ForgotPassword(c *fiber.Ctx) error {
+	var data map[string]string
+	if err := c.BodyParser(&data); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	email := strings.TrimSpace(data["email"])
+	if email == "" {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Email is required", nil)
+	}
+
+	const message = "If the email is registered, a password reset link has been sent"
+
+	var user models.Users
+	if err := database.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		LogAudit(0, "password_reset_requested", "authentication", "failure", c)
+		return sendResponse(c, fiber.StatusOK, true, message, nil)
+	}
+	LogAudit(user.ID, "password_reset_requested", "authentication", "success", c)
+
+	// Token dan email dibuat di background, supaya email yang terdaftar tidak bisa dikenali
+	// dari lama response (SMTP bisa butuh beberapa detik)
+	go sendPasswordResetLink(user, utils.CopyString(c.IP()))
+
+	return sendResponse(c, fiber.StatusOK, true, message, nil)
+}
+
+// sendPasswordResetLink creates a reset token for the user and mails the link. It runs after the
+// response was sent, so failures are only logged.
+func sendPasswordResetLink(user models.Users, ip string) {
+	token, err := database.CreatePasswordResetToken(user.ID, ip, passwordResetTTL)
+	if err != nil {
+		log.Printf("Failed to create password reset token for user %d: %v", user.ID, err)
+		return
+	}
+
+	err = notifier.Default().Send(notifier.Message{
+		To:      user.Email,
+		Subject: "Reset password BrainQuiz",
+		Body: fmt.Sprintf("Halo %s,\n\nBuka link berikut untuk membuat password baru. Link berlaku %d menit dan hanya bisa dipakai sekali:\n%s\n\nAbaikan email ini jika kamu tidak meminta reset password.",
+			user.Name, int(passwordResetTTL.Minutes()), frontendLink("RESET_PASSWORD_URL", "http://localhost:5173/reset-password", "token", token)),
+	})
+	if err != nil {
+		log.Printf("Failed to send password reset link to user %d: %v", user.ID, err)
+	}
+}
+
+// ResetPassword mengganti password memakai token dari link reset
+func ResetPassword(c *fiber.Ctx) error {
+	var data map[string]string
+	if err := c.BodyParser(&data); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	if data["token"] == "" {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Token is required", nil)
+	}
+	policy, err := database.GetPasswordPolicy()
+	if err != nil {
+		return handleError(c, err, "Failed to load password policy")
+	}
+	if problems := policy.Validate(data["password"]); len(problems) > 0 {
+		return passwordPolicyError(c, problems)
+	}
+
+	password, err := policy.Hash(data["password"])
+	if err != nil {
+		return sendResponse(c, fiber.StatusInternalServerError, false, "Error hashing password", nil)
+	}
+
+	user, err := database.ResetPassword(data["token"], password)
+	if err != nil {
+		if errors.Is(err, database.ErrResetTokenInvalid) {
+			LogAudit(0, "password_reset", "authentication", "failure", c)
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to reset password")
+	}
+
+	LogAudit(user.ID, "password_reset", "authentication", "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Password has been reset, please log in again", nil)
+}
+
+// frontendLink builds a link to a frontend page, taken from the env variable or fallback,
+// that carries a token in the given query parameter
+func frontendLink(env string, fallback string, param string, token string) string {
+	base := os.Getenv(env)
+	if base == "" {
+		base = fallback
+	}
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	return base + separator + param + "=" + url.QueryEscape(token)
+}
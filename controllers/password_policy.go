@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPasswordPolicy mengembalikan aturan password dan lockout yang berlaku
+func GetPasswordPolicy(c *fiber.Ctx) error {
+	policy, err := database.GetPasswordPolicy()
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve password policy")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Password policy retrieved successfully", policy)
+}
+
+// UpdatePasswordPolicy mengubah aturan password dan lockout; field yang tidak dikirim tetap
+func UpdatePasswordPolicy(c *fiber.Ctx) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	policy, err := database.GetPasswordPolicy()
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve password policy")
+	}
+	if err := c.BodyParser(&policy); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	if err := database.SetPasswordPolicy(policy, admin.ID); err != nil {
+		if errors.Is(err, database.ErrInvalidPolicy) {
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to update password policy")
+	}
+
+	LogAudit(admin.ID, "update_password_policy", "settings", "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Password policy updated successfully", policy)
+}
+
+// passwordPolicyError answers a password that breaks the policy with the rules it breaks
+func passwordPolicyError(c *fiber.Ctx, problems []string) error {
+	return sendResponse(c, fiber.StatusBadRequest, false, "Password does not meet the password policy", fiber.Map{"problems": problems})
+}
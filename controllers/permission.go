@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// hasPermission reports whether the role of the user grants the permission; lookup errors deny access
+func hasPermission(user *models.Users, permission string) bool {
+	allowed, err := database.RoleHasPermission(user.Role, permission)
+	return err == nil && allowed
+}
+
+// RequirePermission only lets the request through if the role of the user grants every listed permission
+func RequirePermission(permissions ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := Authenticate(c)
+		if err != nil {
+			return err
+		}
+
+		for _, permission := range permissions {
+			allowed, err := database.RoleHasPermission(user.Role, permission)
+			if err != nil {
+				return handleError(c, err, "Failed to check permissions")
+			}
+			if !allowed {
+				LogAudit(user.ID, "permission_denied", fmt.Sprintf("permission:%s %s %s", permission, c.Method(), c.Path()), "failure", c)
+				return sendResponse(c, fiber.StatusForbidden, false, "You don't have permission to access this resource", fiber.Map{
+					"missing_permission": permission,
+				})
+			}
+		}
+
+		return c.Next()
+	}
+}
+
+// GetRoles menampilkan semua role beserta permission-nya
+func GetRoles(c *fiber.Ctx) error {
+	roles, err := database.GetRoles()
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve roles")
+	}
+	return sendResponse(c, fiber.StatusOK, true, "Roles retrieved successfully", roles)
+}
+
+// GetPermissions menampilkan semua permission yang bisa diberikan ke role
+func GetPermissions(c *fiber.Ctx) error {
+	permissions, err := database.GetPermissions()
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve permissions")
+	}
+	return sendResponse(c, fiber.StatusOK, true, "Permissions retrieved successfully", permissions)
+}
+
+// CreateRole membuat role baru, misalnya "reviewer" yang boleh mengubah soal tanpa melihat nilai
+func CreateRole(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	role, err := database.CreateRole(body.Name, body.Description, body.Permissions)
+	if err != nil {
+		return roleError(c, err)
+	}
+
+	LogAudit(user.ID, "role_created", fmt.Sprintf("role:%s permissions:%s", role.Name, strings.Join(body.Permissions, ",")), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Role created successfully", role)
+}
+
+// UpdateRole mengubah deskripsi dan/atau mengganti seluruh permission sebuah role
+func UpdateRole(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Description *string  `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	role, err := database.UpdateRole(c.Params("name"), body.Description, body.Permissions)
+	if err != nil {
+		return roleError(c, err)
+	}
+
+	resource := "role:" + role.Name
+	if body.Permissions != nil {
+		resource += " permissions:" + strings.Join(body.Permissions, ",")
+	}
+	LogAudit(user.ID, "role_updated", resource, "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Role updated successfully", role)
+}
+
+// DeleteRole menghapus role buatan admin yang tidak dipakai user mana pun
+func DeleteRole(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	name := c.Params("name")
+	if err := database.DeleteRole(name); err != nil {
+		return roleError(c, err)
+	}
+
+	LogAudit(user.ID, "role_deleted", "role:"+name, "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Role deleted successfully", nil)
+}
+
+// roleError maps the role errors from the database package to HTTP responses
+func roleError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, database.ErrRoleNotFound):
+		return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+	case errors.Is(err, database.ErrRoleExists), errors.Is(err, database.ErrRoleInUse):
+		return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+	case errors.Is(err, database.ErrInvalidRoleName), errors.Is(err, database.ErrInvalidPermission),
+		errors.Is(err, database.ErrSystemRole), errors.Is(err, database.ErrAdminRoleFixed):
+		return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+	}
+	return handleError(c, err, "Failed to update roles")
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// readsAllResults reports whether the viewer may read every result of the user: everyone their own,
+// results.read_any everyone's. Reading someone else's results through results.read_any is always audited.
+func readsAllResults(c *fiber.Ctx, viewer *models.Users, userID uint, resource string) bool {
+	if viewer.ID == userID {
+		return true
+	}
+	if !hasPermission(viewer, models.PermResultsReadAny) {
+		return false
+	}
+	LogAudit(viewer.ID, "admin_access", resource, "success", c)
+	return true
+}
+
+// requireResultAccess returns a 403 error unless the viewer may read the user's result for the kuis.
+// results.read only grants the results of kuis in the kelas the viewer teaches, so enrolling a student
+// does not open up their results for kuis of other teachers.
+func requireResultAccess(c *fiber.Ctx, viewer *models.Users, userID uint, kuisID uint, resource string) error {
+	if readsAllResults(c, viewer, userID, resource) {
+		return nil
+	}
+
+	allowed := false
+	if hasPermission(viewer, models.PermResultsRead) {
+		var err error
+		if allowed, err = database.TeachesKuis(viewer.ID, kuisID); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Failed to check permissions")
+		}
+	}
+	if !allowed {
+		LogAudit(viewer.ID, "access_denied", resource, "failure", c)
+		return fiber.NewError(fiber.StatusForbidden, "You don't have access to this user's data")
+	}
+	return nil
+}
+
+// requireEnrollment returns a 403 error unless the user may add someone to the kelas directly,
+// without join code: roles with content.manage_any anyone to every kelas, others only users without
+// results.read or results.read_any (i.e. learners, not staff) to the kelas they teach. The route requires kelas.enroll.
+func requireEnrollment(c *fiber.Ctx, user *models.Users, kelas models.Kelas, target models.Users) error {
+	if hasPermission(user, models.PermContentManageAny) {
+		return nil
+	}
+
+	allowed, err := canManageKelas(user, kelas)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to check permissions")
+	}
+	if allowed && !hasPermission(&target, models.PermResultsRead) && !hasPermission(&target, models.PermResultsReadAny) {
+		return nil
+	}
+
+	LogAudit(user.ID, "access_denied", fmt.Sprintf("kelas:%d user:%d", kelas.ID, target.ID), "failure", c)
+	return fiber.NewError(fiber.StatusForbidden, "You can only enroll students in classes you teach; students join with /kelas/join-by-code")
+}
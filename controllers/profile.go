@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UpdateProfile mengubah nama dan email user yang sedang login. Mengganti email membutuhkan
+// password saat ini dan membuat email harus diverifikasi ulang.
+func UpdateProfile(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	var changed []string
+	emailChanged := false
+	if body.Name != nil {
+		if strings.TrimSpace(*body.Name) == "" {
+			return sendResponse(c, fiber.StatusBadRequest, false, "Name cannot be empty", nil)
+		}
+		if strings.TrimSpace(*body.Name) != user.Name {
+			changed = append(changed, "name")
+		}
+	}
+	if body.Email != nil {
+		if strings.TrimSpace(*body.Email) == "" {
+			return sendResponse(c, fiber.StatusBadRequest, false, "Email cannot be empty", nil)
+		}
+		if !strings.EqualFold(strings.TrimSpace(*body.Email), user.Email) {
+			changed = append(changed, "email")
+			emailChanged = true
+			if err := bcrypt.CompareHashAndPassword(user.Password, []byte(body.CurrentPassword)); err != nil {
+				LogAudit(user.ID, "profile_update", "fields:email", "failure", c)
+				return sendResponse(c, fiber.StatusUnauthorized, false, "Current password is incorrect", nil)
+			}
+		}
+	}
+	if len(changed) == 0 {
+		return sendResponse(c, fiber.StatusOK, true, "Nothing to update", user)
+	}
+
+	updated, err := database.UpdateUserDetails(user.ID, body.Name, body.Email, nil)
+	if err != nil {
+		if errors.Is(err, database.ErrEmailTaken) {
+			return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to update profile")
+	}
+
+	LogAudit(user.ID, "profile_update", "fields:"+strings.Join(changed, ","), "success", c)
+
+	// Email baru harus diverifikasi ulang lewat link yang dikirim ke alamat tersebut; link ini
+	// tidak ikut jeda kirim ulang karena link lama sudah tidak berlaku
+	message := "Profile updated successfully"
+	if emailChanged {
+		if err := sendVerificationEmail(updated, 0); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", updated.ID, err)
+			message = "Profile updated, but the verification email could not be sent"
+		} else {
+			message = "Profile updated, please check your new email to verify it"
+		}
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, message, updated)
+}
+
+// ChangePassword mengganti password user yang sedang login; session lain dicabut
+func ChangePassword(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	if err := bcrypt.CompareHashAndPassword(user.Password, []byte(body.CurrentPassword)); err != nil {
+		LogAudit(user.ID, "password_change", "fields:password", "failure", c)
+		return sendResponse(c, fiber.StatusUnauthorized, false, "Current password is incorrect", nil)
+	}
+	policy, err := database.GetPasswordPolicy()
+	if err != nil {
+		return handleError(c, err, "Failed to load password policy")
+	}
+	if problems := policy.Validate(body.NewPassword, user.Name, user.Email); len(problems) > 0 {
+		return passwordPolicyError(c, problems)
+	}
+
+	password, err := policy.Hash(body.NewPassword)
+	if err != nil {
+		return sendResponse(c, fiber.StatusInternalServerError, false, "Error hashing password", nil)
+	}
+
+	sessionID, _ := c.Locals("session_id").(uint)
+	revoked, err := database.ChangePassword(user.ID, password, sessionID)
+	if err != nil {
+		return handleError(c, err, "Failed to change password")
+	}
+
+	LogAudit(user.ID, "password_change", fmt.Sprintf("fields:password sessions_revoked:%d", revoked), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Password changed successfully, other sessions have been logged out", nil)
+}
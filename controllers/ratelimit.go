@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/ratelimit"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateRule is one limit of a route group, counted separately for every key returned by Key.
+// Requests for which Key returns "" are not counted by the rule.
+type RateRule struct {
+	Name  string
+	Limit ratelimit.Limit
+	Key   func(c *fiber.Ctx) string
+}
+
+// PerIP counts requests per client IP
+func PerIP(limit ratelimit.Limit) RateRule {
+	return RateRule{Name: "ip", Limit: limit, Key: func(c *fiber.Ctx) string {
+		return c.IP()
+	}}
+}
+
+// PerUser counts requests per logged-in user
+func PerUser(limit ratelimit.Limit) RateRule {
+	return RateRule{Name: "user", Limit: limit, Key: func(c *fiber.Ctx) string {
+		user, err := Authenticate(c)
+		if err != nil {
+			return ""
+		}
+		return strconv.FormatUint(uint64(user.ID), 10)
+	}}
+}
+
+// PerAccount counts requests per email in the request body, e.g. login attempts against one account
+func PerAccount(limit ratelimit.Limit) RateRule {
+	return RateRule{Name: "account", Limit: limit, Key: func(c *fiber.Ctx) string {
+		var body struct {
+			Email string `json:"email"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return ""
+		}
+		return strings.ToLower(strings.TrimSpace(body.Email))
+	}}
+}
+
+// Limit yang dicatat ke audit log paling banyak sekali per menit untuk setiap bucket,
+// supaya serangan tidak ikut membanjiri tabel audit
+var (
+	rateAuditMu   sync.Mutex
+	rateAuditedAt = map[string]time.Time{}
+)
+
+// RateLimit membatasi request ke route group dengan token bucket untuk setiap aturan.
+// Response membawa header RateLimit-Limit, RateLimit-Remaining, dan RateLimit-Reset dari
+// aturan yang paling ketat, dan Retry-After saat request ditolak.
+func RateLimit(group string, rules ...RateRule) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		now := time.Now()
+
+		var tightest *ratelimit.Result
+		var denied *ratelimit.Result
+		var deniedKey, deniedRule string
+		for _, rule := range rules {
+			value := rule.Key(c)
+			if value == "" {
+				continue
+			}
+			key := group + ":" + rule.Name + ":" + value
+
+			result, err := ratelimit.Default().Take(key, rule.Limit, now)
+			if err != nil {
+				// Jika store bermasalah request tetap dilayani
+				log.Printf("Rate limit store error: %v", err)
+				continue
+			}
+			if !result.Allowed && (denied == nil || result.RetryAfter > denied.RetryAfter) {
+				denied = &result
+				deniedKey, deniedRule = key, rule.Name
+			}
+			if tightest == nil || result.Remaining < tightest.Remaining {
+				tightest = &result
+			}
+		}
+
+		if denied != nil {
+			tightest = denied
+		}
+		if tightest != nil {
+			c.Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			c.Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			c.Set("RateLimit-Reset", strconv.Itoa(int(tightest.Reset.Seconds())))
+		}
+		if denied == nil {
+			return c.Next()
+		}
+
+		c.Set("Retry-After", strconv.Itoa(int(denied.RetryAfter.Seconds())))
+		auditRateLimit(c, deniedKey, fmt.Sprintf("group:%s rule:%s path:%s", group, deniedRule, c.Path()), now)
+		return sendResponse(c, fiber.StatusTooManyRequests, false, "Too many requests, please try again later",
+			fiber.Map{"retry_after": int(denied.RetryAfter.Seconds())})
+	}
+}
+
+// auditRateLimit records a limit hit, at most once a minute per bucket
+func auditRateLimit(c *fiber.Ctx, key string, resource string, now time.Time) {
+	rateAuditMu.Lock()
+	if last, ok := rateAuditedAt[key]; ok && now.Sub(last) < time.Minute {
+		rateAuditMu.Unlock()
+		return
+	}
+	rateAuditedAt[key] = now
+	for k, at := range rateAuditedAt {
+		if now.Sub(at) >= time.Minute {
+			delete(rateAuditedAt, k)
+		}
+	}
+	rateAuditMu.Unlock()
+
+	var userID uint
+	if user, err := Authenticate(c); err == nil {
+		userID = user.ID
+	}
+	LogAudit(userID, "rate_limited", resource, "failure", c)
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegradeSoal menyimpan koreksi kunci jawaban dari body request untuk soal yang sudah dipublikasikan
+// dan menilai ulang semua attempt yang menjawab soal ini
+func RegradeSoal(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, "Soal not found", nil)
+	}
+
+	if _, err := requireSoalAuthor(c, user, uint(id)); err != nil {
+		return err
+	}
+
+	var correction database.AnswerKeyCorrection
+	if err := c.BodyParser(&correction); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+	correction.Soal_id = uint(id)
+
+	summary, err := database.RegradeSoal(correction, user.ID)
+	if err != nil {
+		return regradeError(c, err)
+	}
+
+	logRegrade(c, user.ID, fmt.Sprintf("soal:%d", id), summary)
+	return sendResponse(c, fiber.StatusOK, true, "Soal regraded successfully", summary)
+}
+
+// RegradeKuis menyimpan koreksi kunci jawaban untuk beberapa soal kuis sekaligus
+// dan menilai ulang semua attempt kuis
+func RegradeKuis(c *fiber.Ctx) error {
+	user, kuis, err := authoredKuis(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Corrections []database.AnswerKeyCorrection `json:"corrections"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	summary, err := database.RegradeKuis(kuis.ID, body.Corrections, user.ID)
+	if err != nil {
+		return regradeError(c, err)
+	}
+
+	logRegrade(c, user.ID, fmt.Sprintf("kuis:%d", kuis.ID), summary)
+	return sendResponse(c, fiber.StatusOK, true, "Kuis regraded successfully", summary)
+}
+
+// offerRegrade answers an update that changed the answer key of a soal. With ?regrade=true the
+// answer key fields sent in the update become a correction of the published soal.
+func offerRegrade(c *fiber.Ctx, id string, soal models.Soal, update models.Soal) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	soalID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusOK, true, "Soal updated successfully", soal)
+	}
+
+	if c.Query("regrade") == "true" {
+		if _, err := requireSoalAuthor(c, user, uint(soalID)); err != nil {
+			return err
+		}
+		correction := database.AnswerKeyCorrection{Soal_id: uint(soalID), AnswerKey: update.AnswerKey}
+		if update.Correct_answer != "" {
+			correction.Correct_answer = &update.Correct_answer
+		}
+		if update.Points != 0 {
+			correction.Points = &update.Points
+		}
+		if update.PartialCredit != "" {
+			correction.PartialCredit = &update.PartialCredit
+		}
+		summary, err := database.RegradeSoal(correction, user.ID)
+		if err != nil {
+			return regradeError(c, err)
+		}
+		logRegrade(c, user.ID, fmt.Sprintf("soal:%d", soalID), summary)
+		return sendResponse(c, fiber.StatusOK, true, "Soal updated and regraded successfully", fiber.Map{
+			"soal":    soal,
+			"regrade": summary,
+		})
+	}
+
+	candidates, err := database.CountRegradeCandidates(uint(soalID))
+	if err != nil {
+		return handleError(c, err, "Failed to count attempts to regrade")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Soal updated successfully; the answer key changed", fiber.Map{
+		"soal": soal,
+		"regrade_available": fiber.Map{
+			"attempts": candidates,
+			"endpoint": fmt.Sprintf("/soal/regrade/%d", soalID),
+		},
+	})
+}
+
+// logRegrade writes one audit entry per changed score and one for the regrade itself
+func logRegrade(c *fiber.Ctx, userID uint, resource string, summary database.RegradeSummary) {
+	for _, change := range summary.Changes {
+		LogAudit(userID, "regrade_score", fmt.Sprintf("kuis_attempt:%d user:%d score:%g->%g",
+			change.Attempt_id, change.Users_id, change.OldPercentage, change.NewPercentage), "success", c)
+	}
+	LogAudit(userID, "regrade", resource, "success", c)
+}
+
+// regradeError maps the regrade errors from the database package to HTTP responses
+func regradeError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, database.ErrSoalNotFound), errors.Is(err, database.ErrKuisNotFound):
+		return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+	case errors.Is(err, database.ErrSoalNotPublished):
+		return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+	case errors.Is(err, database.ErrNoCorrection), errors.Is(err, database.ErrSoalNotInKuis), errors.Is(err, database.ErrInvalidSoal):
+		return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+	}
+	return handleError(c, err, "Failed to regrade")
+}
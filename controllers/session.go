@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/jwtkeys"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+)
+
+// Masa berlaku token, bisa diatur lewat environment
+var (
+	accessTokenTTL  = envDuration("ACCESS_TOKEN_MINUTES", 15, time.Minute)
+	refreshTokenTTL = envDuration("REFRESH_TOKEN_DAYS", 30, 24*time.Hour)
+)
+
+// envDuration reads a positive number from the environment, falling back to def
+func envDuration(name string, def int, unit time.Duration) time.Duration {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value > 0 {
+		return time.Duration(value) * unit
+	}
+	return time.Duration(def) * unit
+}
+
+// issueSession starts a session for the user and sets the access and refresh token cookies
+func issueSession(c *fiber.Ctx, user models.Users) (fiber.Map, error) {
+	session, refreshToken, err := database.CreateSession(user.ID, c.Get("User-Agent"), c.IP(), refreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return sendTokens(c, user, session, refreshToken)
+}
+
+// sendTokens signs an access token for the session and sets both token cookies
+func sendTokens(c *fiber.Ctx, user models.Users, session models.Session, refreshToken string) (fiber.Map, error) {
+	expiresAt := time.Now().Add(accessTokenTTL)
+	claims := jwt.MapClaims{
+		"iss":  strconv.Itoa(int(user.ID)),
+		"sid":  session.ID,
+		"exp":  expiresAt.Unix(),
+		"role": user.Role,
+		"name": user.Name,
+	}
+	keys, err := jwtkeys.Default()
+	if err != nil {
+		return nil, err
+	}
+	tokenString, err := keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     "jwt",
+		Value:    tokenString,
+		Expires:  expiresAt,
+		HTTPOnly: true,
+	})
+	c.Cookie(&fiber.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		Path:     "/user",
+		Expires:  session.ExpiresAt,
+		HTTPOnly: true,
+	})
+
+	return fiber.Map{
+		"token":         tokenString,
+		"expires_in":    int(accessTokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+		"session_id":    session.ID,
+	}, nil
+}
+
+// clearAuthCookies removes the token cookies from the browser
+func clearAuthCookies(c *fiber.Ctx) {
+	for _, cookie := range []fiber.Cookie{{Name: "jwt"}, {Name: "refresh_token", Path: "/user"}} {
+		cookie.Value = ""
+		cookie.Expires = time.Now().Add(-time.Hour * 24)
+		cookie.HTTPOnly = true
+		c.Cookie(&cookie)
+	}
+}
+
+// RefreshToken menukar refresh token dengan access token baru; refresh token lama tidak berlaku lagi
+func RefreshToken(c *fiber.Ctx) error {
+	var requestData struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	c.BodyParser(&requestData)
+	if requestData.RefreshToken == "" {
+		requestData.RefreshToken = c.Cookies("refresh_token")
+	}
+	if requestData.RefreshToken == "" {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Refresh token is required", nil)
+	}
+
+	session, refreshToken, err := database.RotateSession(requestData.RefreshToken, c.Get("User-Agent"), c.IP())
+	if err != nil {
+		if errors.Is(err, database.ErrRefreshTokenReused) {
+			LogAudit(session.Users_id, "refresh_token_reuse", fmt.Sprintf("session:%d", session.ID), "failure", c)
+		}
+		clearAuthCookies(c)
+		return sendResponse(c, fiber.StatusUnauthorized, false, "Invalid or expired refresh token", nil)
+	}
+
+	var user models.Users
+	if err := database.DB.First(&user, session.Users_id).Error; err != nil {
+		return sendResponse(c, fiber.StatusUnauthorized, false, "User not found", nil)
+	}
+
+	tokens, err := sendTokens(c, user, session, refreshToken)
+	if err != nil {
+		return handleError(c, err, "Failed to generate token")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Token refreshed successfully", tokens)
+}
+
+// GetSessions mengembalikan session aktif milik user yang login
+func GetSessions(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := database.GetUserSessions(user.ID)
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve sessions")
+	}
+
+	currentID, _ := c.Locals("session_id").(uint)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Sessions retrieved successfully", sessions)
+}
+
+// RevokeSession mencabut salah satu session milik user yang login
+func RevokeSession(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	sessionID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, "Session not found", nil)
+	}
+
+	if err := database.RevokeSession(user.ID, uint(sessionID), database.RevokedByUser); err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to revoke session")
+	}
+
+	LogAudit(user.ID, "revoke_session", fmt.Sprintf("session:%d", sessionID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Session revoked successfully", nil)
+}
+
+// ForceLogout mencabut semua session milik user lain (admin only)
+func ForceLogout(c *fiber.Ctx) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	userID, err := strconv.ParseUint(c.Params("user_id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, "User not found", nil)
+	}
+
+	revoked, err := database.RevokeUserSessions(uint(userID), database.RevokedByAdmin)
+	if err != nil {
+		return handleError(c, err, "Failed to log out user")
+	}
+
+	LogAudit(admin.ID, "force_logout", fmt.Sprintf("user:%d", userID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "User logged out from all sessions", fiber.Map{"revoked_sessions": revoked})
+}
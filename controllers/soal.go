@@ -1,6 +1,9 @@
 package controllers
 
 import (
+	"errors"
+	"strconv"
+
 	"github.com/Joko206/UAS_PWEB1/database"
 	"github.com/Joko206/UAS_PWEB1/models"
 	"github.com/gofiber/fiber/v2"
@@ -8,22 +11,49 @@ import (
 
 func GetSoal(c *fiber.Ctx) error {
 	// Authenticate the user using the JWT token
-	_, err := Authenticate(c)
+	user, err := Authenticate(c)
 	if err != nil {
 		return err
 	}
 
-	result, err := database.GetSoal()
+	// Role dengan content.manage_any melihat semua soal lengkap dengan kunci jawaban
+	if hasPermission(user, models.PermContentManageAny) {
+		result, err := database.GetSoal()
+		if err != nil {
+			return handleError(c, err, "Failed to retrieve soal")
+		}
+		return sendResponse(c, fiber.StatusOK, true, "All soal retrieved successfully", result)
+	}
+
+	soalList, err := database.GetSoalForUser(user.ID)
 	if err != nil {
 		return handleError(c, err, "Failed to retrieve soal")
 	}
 
+	// Kunci jawaban hanya untuk soal dari kuis yang boleh diubah user
+	authored := make(map[uint]bool)
+	result := make([]interface{}, 0, len(soalList))
+	for _, soal := range soalList {
+		allowed, checked := authored[soal.Kuis_id]
+		if !checked {
+			if allowed, err = canAuthorKuis(user, soal.Kuis); err != nil {
+				return handleError(c, err, "Failed to check permissions")
+			}
+			authored[soal.Kuis_id] = allowed
+		}
+		if allowed {
+			result = append(result, soal)
+		} else {
+			result = append(result, soal.Delivery())
+		}
+	}
+
 	return sendResponse(c, fiber.StatusOK, true, "All soal retrieved successfully", result)
 }
 
 func AddSoal(c *fiber.Ctx) error {
 	// Authenticate the user using the JWT token
-	_, err := Authenticate(c)
+	user, err := Authenticate(c)
 	if err != nil {
 		return err
 	}
@@ -35,9 +65,21 @@ func AddSoal(c *fiber.Ctx) error {
 		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
 	}
 
+	// Soal hanya boleh ditambahkan ke kuis yang boleh diubah user
+	kuis, err := database.GetKuisByID(newSoal.Kuis_id)
+	if err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid Kuis ID", nil)
+	}
+	if err := requireKuisAuthor(c, user, kuis); err != nil {
+		return err
+	}
+
 	// Create Soal
-	result, err := database.CreateSoal(newSoal.Question, newSoal.Options, newSoal.Correct_answer, newSoal.Kuis_id)
+	result, err := database.CreateSoal(newSoal.Question, newSoal.Type, newSoal.Options, newSoal.Correct_answer, newSoal.AnswerKey, newSoal.Points, newSoal.PartialCredit, newSoal.Tingkatan_id, newSoal.Kuis_id)
 	if err != nil {
+		if errors.Is(err, database.ErrInvalidSoal) {
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		}
 		return handleError(c, err, "Failed to add soal")
 	}
 
@@ -46,60 +88,118 @@ func AddSoal(c *fiber.Ctx) error {
 
 func UpdateSoal(c *fiber.Ctx) error {
 	// Authenticate the user using the JWT token
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
 
-	id := c.Params("id")
-	if id == "" {
-		return sendResponse(c, fiber.StatusBadRequest, false, "ID cannot be empty", nil)
+	soalID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, "Soal not found", nil)
+	}
+	existing, err := requireSoalAuthor(c, user, uint(soalID))
+	if err != nil {
+		return err
 	}
+	id := strconv.FormatUint(soalID, 10)
 
 	// Parse body request for updated Soal
 	newSoal := new(models.Soal)
-	err := c.BodyParser(newSoal)
+	err = c.BodyParser(newSoal)
 	if err != nil {
 		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
 	}
 
+	// Soal yang dipindahkan ke kuis lain juga harus boleh diubah di kuis tujuan
+	if newSoal.Kuis_id != 0 && newSoal.Kuis_id != existing.Kuis_id {
+		target, err := database.GetKuisByID(newSoal.Kuis_id)
+		if err != nil {
+			return sendResponse(c, fiber.StatusBadRequest, false, "Invalid Kuis ID", nil)
+		}
+		if err := requireKuisAuthor(c, user, target); err != nil {
+			return err
+		}
+	}
+
 	// Update Soal
-	result, err := database.UpdateSoal(newSoal.Question, newSoal.Options, newSoal.Correct_answer, newSoal.Kuis_id, id)
+	result, keyChanged, err := database.UpdateSoal(newSoal.Question, newSoal.Type, newSoal.Options, newSoal.Correct_answer, newSoal.AnswerKey, newSoal.Points, newSoal.PartialCredit, newSoal.Tingkatan_id, newSoal.Kuis_id, id)
 	if err != nil {
+		if errors.Is(err, database.ErrInvalidSoal) {
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		}
 		return handleError(c, err, "Failed to update soal")
 	}
 
+	// Kunci jawaban berubah: tawarkan penilaian ulang, atau langsung jalankan dengan ?regrade=true
+	if keyChanged {
+		return offerRegrade(c, id, result, *newSoal)
+	}
+
 	return sendResponse(c, fiber.StatusOK, true, "Soal updated successfully", result)
 }
 func DeleteSoal(c *fiber.Ctx) error {
 	// Authenticate the user using the JWT token
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
 
-	id := c.Params("id")
-	if id == "" {
-		return sendResponse(c, fiber.StatusBadRequest, false, "ID cannot be empty", nil)
+	soalID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, "Soal not found", nil)
+	}
+	if _, err := requireSoalAuthor(c, user, uint(soalID)); err != nil {
+		return err
 	}
 
 	// Delete Soal
-	err := database.DeleteSoal(id)
-	if err != nil {
+	if err := database.DeleteSoal(strconv.FormatUint(soalID, 10)); err != nil {
 		return handleError(c, err, "Failed to delete soal")
 	}
 
 	return sendResponse(c, fiber.StatusOK, true, "Soal deleted successfully", nil)
 }
 func GetSoalByKuisID(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
 	// Ambil kuis_id dari parameter request
-	kuisID := c.Params("kuis_id")
+	kuisID, err := strconv.ParseUint(c.Params("kuis_id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, "Kuis not found", nil)
+	}
 
 	// Cek apakah kuis_id valid
-	var kuis models.Kuis
-	err := database.DB.First(&kuis, kuisID).Error
+	kuis, err := database.GetKuisByID(uint(kuisID))
 	if err != nil {
 		return sendResponse(c, fiber.StatusNotFound, false, "Kuis not found", nil)
 	}
 
-	// Ambil soal-soal yang terkait dengan kuis_id
-	var soal []models.Soal
-	err = database.DB.Where("kuis_id = ?", kuisID).Find(&soal).Error
+	// Pembuat kuis, guru kelasnya, dan admin mendapat tampilan authoring lengkap dari draft
+	authored, err := canAuthorKuis(user, kuis)
+	if err != nil {
+		return handleError(c, err, "Failed to check permissions")
+	}
+	if authored {
+		soal, err := database.GetSoalByKuis(kuis.ID)
+		if err != nil {
+			return sendResponse(c, fiber.StatusInternalServerError, false, "Failed to fetch questions", nil)
+		}
+		return sendResponse(c, fiber.StatusOK, true, "Soal retrieved successfully", soal)
+	}
+
+	// Siswa melihat soal versi yang dipublikasikan tanpa kunci jawaban. Kuis dengan batas waktu atau soal acak
+	// tidak membuka bank soalnya; soal yang diundi untuk siswa diambil lewat attempt.
+	delivery, err := database.GetDeliverySoal(user.ID, kuis)
+	if errors.Is(err, database.ErrAttemptRequired) {
+		return sendResponse(c, fiber.StatusForbidden, false, "Start an attempt and use /hasil-kuis/attempt/:attempt_id/soal to get the questions of this kuis",
+			fiber.Map{"code": "attempt_required"})
+	}
 	if err != nil {
-		return sendResponse(c, fiber.StatusInternalServerError, false, "Failed to fetch questions", nil)
+		return attemptError(c, err, "Failed to fetch questions")
 	}
 
-	return sendResponse(c, fiber.StatusOK, true, "Soal retrieved successfully", soal)
+	return sendResponse(c, fiber.StatusOK, true, "Soal retrieved successfully", delivery)
 }
@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/Joko206/UAS_PWEB1/totp"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Waktu untuk menyelesaikan langkah kedua login, termasuk memindai QR saat pendaftaran 2FA
+const loginChallengeTTL = 10 * time.Minute
+
+// startTwoFactorLogin membalas login yang password-nya benar dengan challenge untuk langkah kedua
+func startTwoFactorLogin(c *fiber.Ctx, user models.Users) error {
+	purpose := models.ChallengeVerify
+	if !user.TwoFactorEnabled {
+		purpose = models.ChallengeEnroll
+	}
+
+	token, err := database.CreateLoginChallenge(user.ID, purpose, loginChallengeTTL)
+	if err != nil {
+		return handleError(c, err, "Failed to start two-factor login")
+	}
+
+	message := "Two-factor code required"
+	if purpose == models.ChallengeEnroll {
+		message = "Two-factor authentication must be set up before logging in"
+	}
+	return sendResponse(c, fiber.StatusOK, true, message, fiber.Map{
+		"two_factor_required": true,
+		"enrollment_required": purpose == models.ChallengeEnroll,
+		"challenge_token":     token,
+		"expires_in":          int(loginChallengeTTL.Seconds()),
+	})
+}
+
+// LoginTwoFactor menyelesaikan login dengan kode TOTP atau recovery code
+func LoginTwoFactor(c *fiber.Ctx) error {
+	var data map[string]string
+	if err := c.BodyParser(&data); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	challenge, user, err := database.GetLoginChallenge(data["challenge_token"], models.ChallengeVerify)
+	if err != nil {
+		if errors.Is(err, database.ErrChallengeInvalid) {
+			return sendResponse(c, fiber.StatusUnauthorized, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to check login challenge")
+	}
+
+	if data["recovery_code"] != "" {
+		left, err := database.UseRecoveryCode(user.ID, data["recovery_code"])
+		if err != nil {
+			return twoFactorLoginFailed(c, challenge, user, err)
+		}
+		LogAudit(user.ID, "2fa_recovery_code_used", fmt.Sprintf("recovery_codes_left:%d", left), "success", c)
+	} else if err := database.VerifyTOTP(user, data["code"]); err != nil {
+		return twoFactorLoginFailed(c, challenge, user, err)
+	}
+
+	if err := database.UseLoginChallenge(challenge.ID); err != nil {
+		return sendResponse(c, fiber.StatusUnauthorized, false, err.Error(), nil)
+	}
+	LogAudit(user.ID, "2fa_login", "authentication", "success", c)
+
+	tokens, err := loginTokens(c, user)
+	if err != nil {
+		return handleError(c, err, "Failed to generate token")
+	}
+	return sendResponse(c, fiber.StatusOK, true, "Login successful", tokens)
+}
+
+// twoFactorLoginFailed mencatat kode yang salah pada challenge dan di audit log
+func twoFactorLoginFailed(c *fiber.Ctx, challenge models.LoginChallenge, user models.Users, err error) error {
+	if !errors.Is(err, database.ErrTwoFactorCodeInvalid) && !errors.Is(err, database.ErrRecoveryCodeInvalid) {
+		return handleError(c, err, "Failed to verify two-factor code")
+	}
+	if failErr := database.FailLoginChallenge(challenge.ID); failErr != nil {
+		return handleError(c, failErr, "Failed to verify two-factor code")
+	}
+	LogAudit(user.ID, "2fa_login", "authentication", "failure", c)
+	return sendResponse(c, fiber.StatusUnauthorized, false, err.Error(), nil)
+}
+
+// SetupTwoFactor membuat secret TOTP baru dan URI untuk QR code aplikasi authenticator.
+// Bisa dipanggil oleh user yang sudah login atau dengan challenge_token pendaftaran dari login.
+func SetupTwoFactor(c *fiber.Ctx) error {
+	var data map[string]string
+	if err := c.BodyParser(&data); err != nil {
+		data = map[string]string{}
+	}
+
+	user, _, err := twoFactorUser(c, data["challenge_token"])
+	if err != nil {
+		return err
+	}
+
+	secret, err := database.SetupTOTP(user.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrTwoFactorEnabled) {
+			return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to set up two-factor authentication")
+	}
+
+	LogAudit(user.ID, "2fa_setup", "authentication", "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Scan the QR code and confirm with a code to enable two-factor authentication", fiber.Map{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(secret, totpIssuer(), user.Email),
+	})
+}
+
+// EnableTwoFactor mengaktifkan 2FA setelah kode pertama dari authenticator benar dan
+// mengembalikan recovery code. Saat dipakai dari login, login sekaligus diselesaikan.
+func EnableTwoFactor(c *fiber.Ctx) error {
+	var data map[string]string
+	if err := c.BodyParser(&data); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	user, challenge, err := twoFactorUser(c, data["challenge_token"])
+	if err != nil {
+		return err
+	}
+
+	codes, err := database.EnableTOTP(user.ID, data["code"])
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrTwoFactorCodeInvalid):
+			if challenge != nil {
+				database.FailLoginChallenge(challenge.ID)
+			}
+			LogAudit(user.ID, "2fa_enrolled", "authentication", "failure", c)
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		case errors.Is(err, database.ErrTwoFactorNotSetUp):
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		case errors.Is(err, database.ErrTwoFactorEnabled):
+			return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to enable two-factor authentication")
+	}
+	LogAudit(user.ID, "2fa_enrolled", "authentication", "success", c)
+
+	result := fiber.Map{"recovery_codes": codes}
+	if challenge != nil {
+		if err := database.UseLoginChallenge(challenge.ID); err != nil {
+			return sendResponse(c, fiber.StatusUnauthorized, false, err.Error(), nil)
+		}
+		user.TwoFactorEnabled = true
+		tokens, err := loginTokens(c, user)
+		if err != nil {
+			return handleError(c, err, "Failed to generate token")
+		}
+		for key, value := range tokens {
+			result[key] = value
+		}
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Two-factor authentication enabled. Store the recovery codes in a safe place", result)
+}
+
+// DisableTwoFactor mematikan 2FA milik user yang login; butuh password dan kode yang masih berlaku
+func DisableTwoFactor(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var data map[string]string
+	if err := c.BodyParser(&data); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	required, err := database.TwoFactorRequired(user.Role)
+	if err != nil {
+		return handleError(c, err, "Failed to check two-factor policy")
+	}
+	if required {
+		return sendResponse(c, fiber.StatusForbidden, false, database.ErrTwoFactorRequired.Error(), nil)
+	}
+
+	if err := bcrypt.CompareHashAndPassword(user.Password, []byte(data["password"])); err != nil {
+		LogAudit(user.ID, "2fa_disabled", "authentication", "failure", c)
+		return sendResponse(c, fiber.StatusUnauthorized, false, "Invalid password", nil)
+	}
+	if err := database.VerifyTOTP(*user, data["code"]); err != nil {
+		return twoFactorCodeError(c, user, "2fa_disabled", err)
+	}
+
+	if err := database.DisableTOTP(user.ID); err != nil {
+		return handleError(c, err, "Failed to disable two-factor authentication")
+	}
+
+	LogAudit(user.ID, "2fa_disabled", "authentication", "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Two-factor authentication disabled", nil)
+}
+
+// RegenerateRecoveryCodes mengganti semua recovery code; kode lama tidak berlaku lagi
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var data map[string]string
+	if err := c.BodyParser(&data); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	if err := database.VerifyTOTP(*user, data["code"]); err != nil {
+		return twoFactorCodeError(c, user, "2fa_recovery_codes_regenerated", err)
+	}
+
+	codes, err := database.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		return handleError(c, err, "Failed to generate recovery codes")
+	}
+
+	LogAudit(user.ID, "2fa_recovery_codes_regenerated", "authentication", "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Recovery codes regenerated", fiber.Map{"recovery_codes": codes})
+}
+
+// ResetTwoFactor dipakai admin untuk mematikan 2FA user yang kehilangan perangkat dan recovery code-nya
+func ResetTwoFactor(c *fiber.Ctx) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	userID, err := strconv.ParseUint(c.Params("user_id"), 10, 64)
+	if err != nil {
+		return sendResponse(c, fiber.StatusNotFound, false, database.ErrUserNotFound.Error(), nil)
+	}
+
+	if err := database.DisableTOTP(uint(userID)); err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return sendResponse(c, fiber.StatusNotFound, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to reset two-factor authentication")
+	}
+
+	LogAudit(admin.ID, "2fa_reset", fmt.Sprintf("user:%d", userID), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Two-factor authentication reset", nil)
+}
+
+// GetTwoFactorSettings mengembalikan role yang wajib memakai 2FA
+func GetTwoFactorSettings(c *fiber.Ctx) error {
+	roles, err := database.GetTwoFactorRequiredRoles()
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve two-factor settings")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Two-factor settings retrieved successfully", fiber.Map{"required_roles": roles})
+}
+
+// UpdateTwoFactorSettings mengganti role yang wajib memakai 2FA
+func UpdateTwoFactorSettings(c *fiber.Ctx) error {
+	admin, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		RequiredRoles *[]string `json:"required_roles"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+	if body.RequiredRoles == nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "required_roles is required", nil)
+	}
+
+	roles, err := database.SetTwoFactorRequiredRoles(*body.RequiredRoles, admin.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidRole) {
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to update two-factor settings")
+	}
+
+	LogAudit(admin.ID, "update_two_factor_settings", fmt.Sprintf("required_roles:%v", roles), "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Two-factor settings updated successfully", fiber.Map{"required_roles": roles})
+}
+
+// twoFactorUser returns the user managing 2FA: the user of an enrollment challenge from login,
+// or otherwise the logged-in user
+func twoFactorUser(c *fiber.Ctx, challengeToken string) (models.Users, *models.LoginChallenge, error) {
+	if challengeToken != "" {
+		challenge, user, err := database.GetLoginChallenge(challengeToken, models.ChallengeEnroll)
+		if err != nil {
+			if errors.Is(err, database.ErrChallengeInvalid) {
+				return user, nil, fiber.NewError(fiber.StatusUnauthorized, err.Error())
+			}
+			return user, nil, err
+		}
+		return user, &challenge, nil
+	}
+
+	user, err := Authenticate(c)
+	if err != nil {
+		return models.Users{}, nil, err
+	}
+	return *user, nil, nil
+}
+
+// twoFactorCodeError answers a wrong code for an action that needs a valid TOTP code
+func twoFactorCodeError(c *fiber.Ctx, user *models.Users, action string, err error) error {
+	switch {
+	case errors.Is(err, database.ErrTwoFactorNotEnabled):
+		return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+	case errors.Is(err, database.ErrTwoFactorCodeInvalid):
+		LogAudit(user.ID, action, "authentication", "failure", c)
+		return sendResponse(c, fiber.StatusUnauthorized, false, err.Error(), nil)
+	}
+	return handleError(c, err, "Failed to verify two-factor code")
+}
+
+// totpIssuer is the name shown in authenticator apps
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "BrainQuiz"
+}
@@ -1,22 +1,26 @@
 package controllers
 
 import (
-	"os"
-	"strconv"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/jwtkeys"
 	"github.com/Joko206/UAS_PWEB1/models"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Secret key for JWT
-var SecretKey = os.Getenv("JWT_SECRET")
-
-// Helper function to authenticate using JWT
+// Helper function to authenticate using JWT, or an API key sent as "Authorization: ApiKey <key>"
 func Authenticate(c *fiber.Ctx) (*models.Users, error) {
+	if key, found := strings.CutPrefix(c.Get("Authorization"), "ApiKey "); found {
+		return authenticateAPIKey(c, strings.TrimSpace(key))
+	}
+
 	var tokenString string
 
 	// First try to get token from cookie
@@ -35,16 +39,28 @@ func Authenticate(c *fiber.Ctx) (*models.Users, error) {
 		return nil, fiber.NewError(fiber.StatusUnauthorized, "No JWT token found")
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(SecretKey), nil
-	})
-
+	keys, err := jwtkeys.Default()
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Token signing is not configured")
+	}
+	token, err := keys.Parse(tokenString, jwt.MapClaims{})
 	if err != nil || !token.Valid {
 		return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired token")
 	}
 
 	claims := token.Claims.(jwt.MapClaims)
-	userID := claims["iss"].(string)
+
+	// Setiap access token terikat ke session; session yang dicabut langsung ditolak
+	sessionID, ok := claims["sid"].(float64)
+	if !ok {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired token")
+	}
+	if _, err := database.GetActiveSession(uint(sessionID)); err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Session is no longer valid")
+	}
+	c.Locals("session_id", uint(sessionID))
+
+	userID, _ := claims["iss"].(string)
 	var user models.Users
 	database.DB.Where("id = ?", userID).First(&user)
 	if user.ID == 0 {
@@ -54,34 +70,6 @@ func Authenticate(c *fiber.Ctx) (*models.Users, error) {
 	return &user, nil
 }
 
-func RoleMiddleware(allowedRoles []string) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		user, err := Authenticate(c)
-		if err != nil {
-			return err
-		}
-
-		// Check if the user role is allowed
-		roleAllowed := false
-		for _, role := range allowedRoles {
-			if user.Role == role {
-				roleAllowed = true
-				break
-			}
-		}
-
-		if !roleAllowed {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-				"data":    nil,
-				"success": false,
-				"message": "You don't have permission to access this resource",
-			})
-		}
-
-		return c.Next()
-	}
-}
-
 // LogAudit logs user activities for auditing
 func LogAudit(userID uint, action string, resource string, status string, c *fiber.Ctx) {
 	var username, role string
@@ -120,38 +108,77 @@ func Register(c *fiber.Ctx) error {
 		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
 	}
 
-	// Default role is "student" if not provided
-	role := data["role"]
-	if role == "" {
-		role = "student" // Default role
+	// Pendaftaran publik selalu membuat student; role teacher dan admin hanya lewat undangan
+	invitationToken := data["invitation_token"]
+	if invitationToken == "" && data["role"] != "" && data["role"] != models.RoleStudent {
+		LogAudit(0, "register_role_denied", fmt.Sprintf("role:%s", data["role"]), "failure", c)
+		return sendResponse(c, fiber.StatusForbidden, false, "Only student accounts can be registered without an invitation", nil)
 	}
 
-	// Validate that the role is one of the allowed values
-	if role != "admin" && role != "teacher" && role != "student" {
-		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid role. Allowed roles: admin, teacher, student", nil)
+	policy, err := database.GetPasswordPolicy()
+	if err != nil {
+		return handleError(c, err, "Failed to load password policy")
+	}
+	if problems := policy.Validate(data["password"], data["name"], data["email"]); len(problems) > 0 {
+		return passwordPolicyError(c, problems)
 	}
 
 	// Hash password before saving
-	password, err := bcrypt.GenerateFromPassword([]byte(data["password"]), 14)
+	password, err := policy.Hash(data["password"])
 	if err != nil {
 		return sendResponse(c, fiber.StatusInternalServerError, false, "Error hashing password", nil)
 	}
 
-	// Create user with the role
 	user := models.Users{
 		Name:     data["name"],
 		Email:    data["email"],
 		Password: password,
-		Role:     role, // Set the role here
+		Role:     models.RoleStudent,
 	}
 
-	// Save user to the database
-	if err := database.DB.Create(&user).Error; err != nil {
+	if invitationToken != "" {
+		invitation, err := database.RegisterWithInvitation(&user, invitationToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrInvitationInvalid):
+				return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+			case errors.Is(err, database.ErrInvitationEmail):
+				return sendResponse(c, fiber.StatusForbidden, false, err.Error(), nil)
+			}
+			return handleError(c, err, "Failed to register user")
+		}
+		LogAudit(user.ID, "role_change", fmt.Sprintf("user:%d role:->%s invitation:%d by:%d",
+			user.ID, user.Role, invitation.ID, invitation.CreatedBy), "success", c)
+	} else if err := database.DB.Create(&user).Error; err != nil {
 		return handleError(c, err, "Failed to register user")
 	}
 
+	// Undangan yang terikat ke email sudah membuktikan email tersebut
+	if user.EmailVerified() {
+		return sendResponse(c, fiber.StatusOK, true, "User registered successfully", user)
+	}
+
+	// Akun baru belum terverifikasi sampai link di email dibuka; jika email gagal terkirim
+	// pendaftaran tetap berhasil dan user bisa meminta kirim ulang
+	if err := sendVerificationEmail(user, verificationResendDelay); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		LogAudit(user.ID, "verification_sent", "authentication", "failure", c)
+		return sendResponse(c, fiber.StatusOK, true, "User registered successfully, but the verification email could not be sent", user)
+	}
+	LogAudit(user.ID, "verification_sent", "authentication", "success", c)
+
 	// Return success response
-	return sendResponse(c, fiber.StatusOK, true, "User registered successfully", user)
+	return sendResponse(c, fiber.StatusOK, true, "User registered successfully, please check your email to verify your account", user)
+}
+
+// lockedResponse answers a login to a locked account; a lock by an admin is not a rate limit
+func lockedResponse(c *fiber.Ctx, user models.Users) error {
+	if user.LockedByAdmin {
+		return sendResponse(c, fiber.StatusForbidden, false, "Account is locked by an administrator",
+			fiber.Map{"locked_until": user.LockedUntil})
+	}
+	return sendResponse(c, fiber.StatusTooManyRequests, false, "Account is temporarily locked due to too many failed attempts",
+		fiber.Map{"locked_until": user.LockedUntil})
 }
 
 func Login(c *fiber.Ctx) error {
@@ -162,6 +189,11 @@ func Login(c *fiber.Ctx) error {
 		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
 	}
 
+	policy, err := database.GetPasswordPolicy()
+	if err != nil {
+		return handleError(c, err, "Failed to load password policy")
+	}
+
 	var user models.Users
 	// Find user by email
 	if err := database.DB.Where("email = ?", data["email"]).First(&user).Error; err != nil {
@@ -173,18 +205,20 @@ func Login(c *fiber.Ctx) error {
 	// Check if account is locked
 	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
 		LogAudit(user.ID, "failed_login_locked", "authentication", "failure", c)
-		return sendResponse(c, fiber.StatusTooManyRequests, false, "Account is temporarily locked due to too many failed attempts", nil)
+		return lockedResponse(c, user)
 	}
 
 	// Compare password
 	if err := bcrypt.CompareHashAndPassword(user.Password, []byte(data["password"])); err != nil {
 		// Increment failed attempts
 		user.FailedAttempts++
-		if user.FailedAttempts >= 3 {
-			// Lock account for 15 minutes
-			lockTime := time.Now().Add(15 * time.Minute)
+		if user.FailedAttempts >= policy.LockoutThreshold {
+			// Setiap penguncian berikutnya lebih lama jika backoff aktif
+			lockTime := time.Now().Add(policy.LockoutDuration(user.LockoutCount))
 			user.LockedUntil = &lockTime
+			user.LockedByAdmin = false
 			user.FailedAttempts = 0 // Reset after lock
+			user.LockoutCount++
 		}
 		database.DB.Save(&user)
 		LogAudit(user.ID, "failed_login", "authentication", "failure", c)
@@ -194,40 +228,49 @@ func Login(c *fiber.Ctx) error {
 	// Successful login - reset failed attempts and unlock
 	user.FailedAttempts = 0
 	user.LockedUntil = nil
+	user.LockedByAdmin = false
+	user.LockoutCount = 0
+
+	// Hash dengan cost bcrypt lama diganti saat password-nya diketahui
+	if policy.NeedsRehash(user.Password) {
+		if rehashed, err := policy.Hash(data["password"]); err == nil {
+			user.Password = rehashed
+		}
+	}
 	database.DB.Save(&user)
 
-	// Generate JWT token with custom claims including role
-	claims := jwt.MapClaims{
-		"iss":  strconv.Itoa(int(user.ID)),
-		"exp":  time.Now().Add(time.Hour * 24).Unix(),
-		"role": user.Role,
-		"name": user.Name,
+	// Dengan 2FA, token baru diberikan setelah kode dicek di langkah kedua
+	required, err := database.TwoFactorRequired(user.Role)
+	if err != nil {
+		return handleError(c, err, "Failed to check two-factor policy")
+	}
+	if user.TwoFactorEnabled || required {
+		return startTwoFactorLogin(c, user)
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	tokenString, err := token.SignedString([]byte(SecretKey))
+	tokens, err := loginTokens(c, user)
 	if err != nil {
 		return handleError(c, err, "Failed to generate token")
 	}
+	return sendResponse(c, fiber.StatusOK, true, "Login successful", tokens)
+}
 
-	// Set JWT cookie
-	cookie := fiber.Cookie{
-		Name:     "jwt",
-		Value:    tokenString,
-		Expires:  time.Now().Add(time.Hour * 24),
-		HTTPOnly: true,
+// loginTokens starts a session for a user who passed every login step and records the login
+func loginTokens(c *fiber.Ctx, user models.Users) (fiber.Map, error) {
+	// Access token berumur pendek, diperbarui dengan refresh token milik session ini
+	tokens, err := issueSession(c, user)
+	if err != nil {
+		return nil, err
 	}
-	c.Cookie(&cookie)
 
 	// Log successful login
 	LogAudit(user.ID, "login", "authentication", "success", c)
 
-	return sendResponse(c, fiber.StatusOK, true, "Login successful", fiber.Map{
-		"token":   tokenString,
-		"role":    user.Role,
-		"user_id": user.ID,
-		"name":    user.Name,
-	})
+	tokens["role"] = user.Role
+	tokens["user_id"] = user.ID
+	tokens["name"] = user.Name
+	tokens["email_verified"] = user.EmailVerified()
+	return tokens, nil
 }
 
 func User(c *fiber.Ctx) error {
@@ -244,16 +287,14 @@ func User(c *fiber.Ctx) error {
 func Logout(c *fiber.Ctx) error {
 	// Try to get user for logging (don't fail if token is invalid)
 	if user, err := Authenticate(c); err == nil {
+		// Session dicabut di server sehingga access token dan refresh token tidak bisa dipakai lagi
+		if sessionID, ok := c.Locals("session_id").(uint); ok {
+			database.RevokeSession(user.ID, sessionID, database.RevokedLogout)
+		}
 		LogAudit(user.ID, "logout", "authentication", "success", c)
 	}
 
-	cookie := fiber.Cookie{
-		Name:     "jwt",
-		Value:    "",
-		Expires:  time.Now().Add(-time.Hour * 24),
-		HTTPOnly: true,
-	}
-	c.Cookie(&cookie)
+	clearAuthCookies(c)
 
 	// Return success message
 	return sendResponse(c, fiber.StatusOK, true, "Logout successful", nil)
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/Joko206/UAS_PWEB1/notifier"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Masa berlaku link verifikasi email dan jeda minimum antar pengiriman ulang
+var (
+	verificationTTL         = envDuration("EMAIL_VERIFICATION_HOURS", 24, time.Hour)
+	verificationResendDelay = time.Minute
+)
+
+// sendVerificationEmail membuat token verifikasi baru dan mengirim link-nya ke email user.
+// minInterval adalah jeda minimum sejak link sebelumnya; 0 untuk email yang baru diganti.
+func sendVerificationEmail(user models.Users, minInterval time.Duration) error {
+	token, err := database.CreateEmailVerificationToken(user.ID, verificationTTL, minInterval)
+	if err != nil {
+		return err
+	}
+
+	return notifier.Default().Send(notifier.Message{
+		To:      user.Email,
+		Subject: "Verifikasi email BrainQuiz",
+		Body: fmt.Sprintf("Halo %s,\n\nBuka link berikut untuk memverifikasi email kamu. Link berlaku %d jam:\n%s\n\nAbaikan email ini jika kamu tidak mendaftar di BrainQuiz.",
+			user.Name, int(verificationTTL.Hours()), frontendLink("VERIFY_EMAIL_URL", "http://localhost:5173/verify-email", "token", token)),
+	})
+}
+
+// VerifyEmail menandai email user sebagai terverifikasi memakai token dari link verifikasi
+func VerifyEmail(c *fiber.Ctx) error {
+	var data map[string]string
+	if err := c.BodyParser(&data); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+
+	if data["token"] == "" {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Token is required", nil)
+	}
+
+	user, err := database.VerifyEmail(data["token"])
+	if err != nil {
+		if errors.Is(err, database.ErrVerificationTokenInvalid) {
+			LogAudit(0, "email_verified", "authentication", "failure", c)
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), nil)
+		}
+		return handleError(c, err, "Failed to verify email")
+	}
+
+	LogAudit(user.ID, "email_verified", "authentication", "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Email verified successfully", user)
+}
+
+// ResendVerification mengirim ulang link verifikasi ke email user yang sedang login
+func ResendVerification(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	if err := sendVerificationEmail(*user, verificationResendDelay); err != nil {
+		switch {
+		case errors.Is(err, database.ErrEmailAlreadyVerified):
+			return sendResponse(c, fiber.StatusConflict, false, err.Error(), nil)
+		case errors.Is(err, database.ErrVerificationTooSoon):
+			return sendResponse(c, fiber.StatusTooManyRequests, false, err.Error(), nil)
+		}
+		LogAudit(user.ID, "verification_sent", "authentication", "failure", c)
+		return handleError(c, err, "Failed to send verification email")
+	}
+
+	LogAudit(user.ID, "verification_sent", "authentication", "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Verification email sent", nil)
+}
+
+// RequireVerifiedEmail menolak request jika aksi ini diatur admin hanya untuk user dengan email terverifikasi
+func RequireVerifiedEmail(action string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := Authenticate(c)
+		if err != nil {
+			return err
+		}
+		if user.EmailVerified() {
+			return c.Next()
+		}
+
+		required, err := database.VerificationRequired(action)
+		if err != nil {
+			return handleError(c, err, "Failed to check verification settings")
+		}
+		if required {
+			return sendResponse(c, fiber.StatusForbidden, false, "Please verify your email before doing this",
+				fiber.Map{"code": "email_not_verified", "action": action})
+		}
+
+		return c.Next()
+	}
+}
+
+// GetVerificationSettings mengembalikan aksi yang membutuhkan email terverifikasi
+func GetVerificationSettings(c *fiber.Ctx) error {
+	actions, err := database.GetVerificationRequiredActions()
+	if err != nil {
+		return handleError(c, err, "Failed to retrieve verification settings")
+	}
+
+	return sendResponse(c, fiber.StatusOK, true, "Verification settings retrieved successfully", fiber.Map{
+		"required_actions":  actions,
+		"available_actions": database.VerificationActions,
+	})
+}
+
+// UpdateVerificationSettings mengganti daftar aksi yang membutuhkan email terverifikasi
+func UpdateVerificationSettings(c *fiber.Ctx) error {
+	user, err := Authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		RequiredActions *[]string `json:"required_actions"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "Invalid request body", nil)
+	}
+	if body.RequiredActions == nil {
+		return sendResponse(c, fiber.StatusBadRequest, false, "required_actions is required", nil)
+	}
+
+	actions, err := database.SetVerificationRequiredActions(*body.RequiredActions, user.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrUnknownVerificationAction) {
+			return sendResponse(c, fiber.StatusBadRequest, false, err.Error(), fiber.Map{"available_actions": database.VerificationActions})
+		}
+		return handleError(c, err, "Failed to update verification settings")
+	}
+
+	LogAudit(user.ID, "update_verification_settings", "settings", "success", c)
+	return sendResponse(c, fiber.StatusOK, true, "Verification settings updated successfully", fiber.Map{
+		"required_actions":  actions,
+		"available_actions": database.VerificationActions,
+	})
+}
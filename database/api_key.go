@@ -0,0 +1,182 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the API key functions
+var (
+	ErrAPIKeyInvalid    = errors.New("API key is invalid, revoked or expired")
+	ErrAPIKeyNotFound   = errors.New("API key not found")
+	ErrInvalidScope     = errors.New("invalid scope")
+	ErrAPIKeyUserLocked = errors.New("the account of this API key is locked")
+)
+
+// apiKeyPrefix marks BrainQuiz API keys so they are easy to recognise, e.g. in secret scanners
+const apiKeyPrefix = "bq_"
+
+// scopePermissions lists the permission the role of the user needs to hold each scope; every user may read results
+var scopePermissions = map[string]string{
+	models.ScopeResultsRead: "",
+	models.ScopeSoalManage:  models.PermSoalUpdate,
+	models.ScopeKuisManage:  models.PermKuisUpdate,
+}
+
+// APIScopes lists every scope an API key can have
+var APIScopes = []string{models.ScopeResultsRead, models.ScopeSoalManage, models.ScopeKuisManage}
+
+// CreateAPIKey creates a key for the user and returns it with the key itself, which is not stored
+func CreateAPIKey(user models.Users, name string, scopes []string, expiresAt *time.Time) (models.APIKey, string, error) {
+	var apiKey models.APIKey
+
+	if len(scopes) == 0 {
+		return apiKey, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	requested := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		permission, ok := scopePermissions[scope]
+		if !ok {
+			return apiKey, "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+		if permission != "" {
+			allowed, err := RoleHasPermission(user.Role, permission)
+			if err != nil {
+				return apiKey, "", err
+			}
+			if !allowed {
+				return apiKey, "", fmt.Errorf("%w: %s is not available for role %s", ErrInvalidScope, scope, user.Role)
+			}
+		}
+		requested[scope] = true
+	}
+	normalized := []string{}
+	for _, scope := range APIScopes {
+		if requested[scope] {
+			normalized = append(normalized, scope)
+		}
+	}
+	encoded, err := json.Marshal(normalized)
+	if err != nil {
+		return apiKey, "", fmt.Errorf("failed to encode scopes: %w", err)
+	}
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return apiKey, "", err
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return apiKey, "", err
+	}
+	key := apiKeyPrefix + token
+
+	apiKey = models.APIKey{
+		Users_id:  user.ID,
+		Name:      strings.TrimSpace(name),
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		KeyHash:   hashToken(key),
+		Scopes:    encoded,
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&apiKey).Error; err != nil {
+		return apiKey, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	return apiKey, key, nil
+}
+
+// GetUserAPIKeys lists the keys of a user, newest first
+func GetUserAPIKeys(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return keys, err
+	}
+
+	if err := db.Where("users_id = ?", userID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return keys, fmt.Errorf("failed to retrieve API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes one key of a user
+func RevokeAPIKey(userID uint, keyID uint) (models.APIKey, error) {
+	var apiKey models.APIKey
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return apiKey, err
+	}
+
+	if err := db.Where("id = ? AND users_id = ?", keyID, userID).First(&apiKey).Error; err != nil {
+		return apiKey, ErrAPIKeyNotFound
+	}
+	if apiKey.RevokedAt != nil {
+		return apiKey, nil
+	}
+
+	now := time.Now()
+	apiKey.RevokedAt = &now
+	if err := db.Model(&apiKey).Update("revoked_at", now).Error; err != nil {
+		return apiKey, fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	return apiKey, nil
+}
+
+// AuthenticateAPIKey returns the key and its user if the key can be used, and records that it was used
+func AuthenticateAPIKey(key string) (models.APIKey, models.Users, error) {
+	var apiKey models.APIKey
+	var user models.Users
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return apiKey, user, err
+	}
+
+	if err := db.Where("key_hash = ?", hashToken(key)).First(&apiKey).Error; err != nil {
+		return apiKey, user, ErrAPIKeyInvalid
+	}
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && !now.Before(*apiKey.ExpiresAt)) {
+		return apiKey, user, ErrAPIKeyInvalid
+	}
+	if err := db.First(&user, apiKey.Users_id).Error; err != nil {
+		return apiKey, user, ErrAPIKeyInvalid
+	}
+	// Akun yang dikunci tidak bisa dipakai, juga tidak lewat API key
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return apiKey, user, ErrAPIKeyUserLocked
+	}
+
+	// last_used_at is only written once a minute to keep busy scripts from updating it on every request
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= time.Minute {
+		db.Model(&apiKey).Update("last_used_at", now)
+		apiKey.LastUsedAt = &now
+	}
+
+	return apiKey, user, nil
+}
+
+// revokeAllAPIKeys revokes every active API key of a user, e.g. when the account is locked or deleted
+func revokeAllAPIKeys(tx *gorm.DB, userID uint) error {
+	if err := tx.Model(&models.APIKey{}).Where("users_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to revoke API keys: %w", err)
+	}
+	return nil
+}
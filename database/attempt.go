@@ -0,0 +1,547 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Errors returned by the attempt functions so controllers can map them to status codes
+var (
+	ErrAttemptNotFound   = errors.New("attempt not found")
+	ErrAttemptClosed     = errors.New("attempt is already finished")
+	ErrAttemptExpired    = errors.New("attempt time limit has expired")
+	ErrKuisAccessDenied  = errors.New("you don't have access to this kuis")
+	ErrSoalNotInKuis     = errors.New("soal does not belong to this kuis")
+	ErrKuisHasNoQuestion = errors.New("kuis has no questions")
+	ErrAttemptRequired   = errors.New("this kuis has a time limit or random questions; start an attempt with /hasil-kuis/start-attempt first")
+)
+
+// StartAttempt opens a new attempt for the user, or returns the attempt that is still running
+func StartAttempt(userID uint, kuisID uint) (models.KuisAttempt, error) {
+	return startAttempt(userID, kuisID, false)
+}
+
+// startAttempt implements StartAttempt; allowLate opens an attempt inside the late grace period
+func startAttempt(userID uint, kuisID uint, allowLate bool) (models.KuisAttempt, error) {
+	var attempt models.KuisAttempt
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return attempt, err
+	}
+
+	kuis, err := GetKuisByID(kuisID)
+	if err != nil {
+		return attempt, err
+	}
+
+	// A kuis that has not opened yet is refused by checkNewAttempt with its opening time
+	allowed, err := canUserSeeKuis(userID, kuis)
+	if err != nil {
+		return attempt, err
+	}
+	if !allowed {
+		return attempt, ErrKuisAccessDenied
+	}
+
+	// Students always take the published version and its settings, never the draft
+	version, err := currentVersion(db, kuis)
+	if err != nil {
+		return attempt, err
+	}
+	if kuis, err = withVersion(kuis, version); err != nil {
+		return attempt, err
+	}
+	soalList, err := gradingSoal(db, version)
+	if err != nil {
+		return attempt, err
+	}
+	if len(soalList) == 0 {
+		return attempt, ErrKuisHasNoQuestion
+	}
+
+	// Resume the running attempt instead of opening a second one
+	err = db.Where("users_id = ? AND kuis_id = ? AND status = ?", userID, kuisID, models.AttemptInProgress).First(&attempt).Error
+	if err == nil {
+		if !attemptExpired(attempt, time.Now()) {
+			return attempt, nil
+		}
+		if _, err := FinishAttempt(attempt.ID); err != nil && !errors.Is(err, ErrAttemptClosed) {
+			return attempt, err
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return attempt, fmt.Errorf("failed to look up running attempt: %w", err)
+	}
+
+	// Requests of the same user are serialized on the user row, so two parallel starts
+	// cannot both pass the attempt rules or take the same attempt number
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var user models.Users
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, userID).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		// A parallel request may have opened the attempt while this one was waiting
+		err := tx.Where("users_id = ? AND kuis_id = ? AND status = ?", userID, kuisID, models.AttemptInProgress).First(&attempt).Error
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to look up running attempt: %w", err)
+		}
+
+		now := time.Now()
+		if err := checkNewAttempt(tx, kuis, userID, now, allowLate); err != nil {
+			return err
+		}
+
+		attemptNumber, err := nextAttemptNumber(tx, userID, kuisID)
+		if err != nil {
+			return err
+		}
+
+		// The deadline is the time limit or the closing time of the kuis, whichever comes first
+		attempt = models.KuisAttempt{
+			Users_id:       userID,
+			Kuis_id:        kuisID,
+			AttemptNumber:  attemptNumber,
+			Status:         models.AttemptInProgress,
+			StartedAt:      now,
+			ExpiresAt:      attemptDeadline(kuis.KuisSettings, now),
+			KuisVersion_id: &version.ID,
+		}
+
+		// The questions, their order and option labels are fixed for the whole attempt
+		layout, err := json.Marshal(buildAttemptLayout(kuis.KuisSettings, soalList))
+		if err != nil {
+			return fmt.Errorf("failed to build attempt layout: %w", err)
+		}
+		attempt.Layout = layout
+
+		if err := tx.Create(&attempt).Error; err != nil {
+			return fmt.Errorf("failed to start attempt: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return attempt, err
+	}
+
+	return attempt, nil
+}
+
+// GetAttempt retrieves an attempt by its ID
+func GetAttempt(id uint) (models.KuisAttempt, error) {
+	var attempt models.KuisAttempt
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return attempt, err
+	}
+
+	if err := db.First(&attempt, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return attempt, ErrAttemptNotFound
+		}
+		return attempt, fmt.Errorf("failed to retrieve attempt: %w", err)
+	}
+
+	return attempt, nil
+}
+
+// GetAttemptAnswers retrieves the answers saved in an attempt
+func GetAttemptAnswers(attemptID uint) ([]models.SoalAnswer, error) {
+	var answers []models.SoalAnswer
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return answers, err
+	}
+
+	if err := db.Where("attempt_id = ?", attemptID).Order("soal_id").Find(&answers).Error; err != nil {
+		return answers, fmt.Errorf("failed to retrieve attempt answers: %w", err)
+	}
+
+	return answers, nil
+}
+
+// SaveAttemptAnswer stores (or replaces) the answer of one soal inside a running attempt.
+// If the time limit has passed, the attempt is finished and ErrAttemptExpired is returned.
+func SaveAttemptAnswer(attemptID uint, soalID uint, payload json.RawMessage) (models.SoalAnswer, error) {
+	var soalAnswer models.SoalAnswer
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return soalAnswer, err
+	}
+
+	attempt, err := GetAttempt(attemptID)
+	if err != nil {
+		return soalAnswer, err
+	}
+	if attempt.Status != models.AttemptInProgress {
+		return soalAnswer, ErrAttemptClosed
+	}
+	if attemptExpired(attempt, time.Now()) {
+		if _, err := FinishAttempt(attempt.ID); err != nil && !errors.Is(err, ErrAttemptClosed) {
+			return soalAnswer, err
+		}
+		return soalAnswer, ErrAttemptExpired
+	}
+
+	soalList, err := attemptSoal(db, attempt)
+	if err != nil {
+		return soalAnswer, err
+	}
+	soal, ok := findSoal(soalList, soalID)
+	if !ok {
+		return soalAnswer, ErrSoalNotInKuis
+	}
+
+	layout, err := decodeLayout(attempt)
+	if err != nil {
+		return soalAnswer, err
+	}
+	item, ok := layoutFor(layout, soalID)
+	if !ok {
+		return soalAnswer, ErrSoalNotInKuis
+	}
+
+	// The payload shape depends on the soal type; shuffled labels are stored as the original option keys
+	answer, err := NormalizeAnswer(soal, payload)
+	if err != nil {
+		return soalAnswer, err
+	}
+	return storeAttemptAnswer(db, attempt, soalID, mapAnswerLabels(soal, answer, item.OptionMap))
+}
+
+// storeAttemptAnswer creates or replaces the answer of one soal in an attempt
+func storeAttemptAnswer(tx *gorm.DB, attempt models.KuisAttempt, soalID uint, answer string) (models.SoalAnswer, error) {
+	var soalAnswer models.SoalAnswer
+
+	err := tx.Where("attempt_id = ? AND soal_id = ?", attempt.ID, soalID).First(&soalAnswer).Error
+	if err == nil {
+		soalAnswer.Answer = answer
+		if err := tx.Save(&soalAnswer).Error; err != nil {
+			return soalAnswer, fmt.Errorf("failed to update answer: %w", err)
+		}
+		return soalAnswer, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return soalAnswer, fmt.Errorf("failed to look up answer: %w", err)
+	}
+
+	soalAnswer = models.SoalAnswer{
+		Soal_id:    soalID,
+		Answer:     answer,
+		User_id:    attempt.Users_id,
+		Attempt_id: &attempt.ID,
+	}
+	if err := tx.Create(&soalAnswer).Error; err != nil {
+		return soalAnswer, fmt.Errorf("failed to save answer: %w", err)
+	}
+
+	return soalAnswer, nil
+}
+
+// FinishAttempt grades the answers saved in an attempt and closes it.
+// An attempt finished after its deadline is marked expired and closed at the deadline.
+func FinishAttempt(attemptID uint) (models.KuisAttempt, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return models.KuisAttempt{}, err
+	}
+
+	attempt, err := GetAttempt(attemptID)
+	if err != nil {
+		return attempt, err
+	}
+	if attempt.Status != models.AttemptInProgress {
+		return attempt, ErrAttemptClosed
+	}
+
+	now := time.Now()
+	status := models.AttemptFinished
+	finishedAt := now
+	if attemptExpired(attempt, now) {
+		status = models.AttemptExpired
+		finishedAt = *attempt.ExpiresAt
+	}
+
+	kuis, err := GetKuisByID(attempt.Kuis_id)
+	if err != nil {
+		return attempt, err
+	}
+	settings, err := attemptSettings(db, attempt, kuis)
+	if err != nil {
+		return attempt, err
+	}
+	late := submittedLate(settings, finishedAt)
+
+	// Only the soal drawn for this attempt are graded
+	soalList, err := attemptSoal(db, attempt)
+	if err != nil {
+		return attempt, err
+	}
+
+	// SaveAttemptAnswer rejects answers after the deadline, so every saved answer counts
+	var answers []models.SoalAnswer
+	if err := db.Where("attempt_id = ?", attempt.ID).Find(&answers).Error; err != nil {
+		return attempt, fmt.Errorf("failed to fetch attempt answers: %w", err)
+	}
+
+	graded := gradeAttempt(settings, late, soalList, answers)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// The status condition makes sure an attempt is only graded once
+		res := tx.Model(&models.KuisAttempt{}).
+			Where("id = ? AND status = ?", attempt.ID, models.AttemptInProgress).
+			Updates(map[string]interface{}{
+				"status":         status,
+				"finished_at":    finishedAt,
+				"score":          graded.Score,
+				"correct_answer": graded.Correct,
+				"raw_points":     graded.RawPoints,
+				"max_points":     graded.MaxPoints,
+				"percentage":     graded.Percentage,
+				"review_status":  graded.ReviewStatus(),
+				"late":           late,
+				"passed":         graded.Passed,
+			})
+		if res.Error != nil {
+			return fmt.Errorf("failed to finish attempt: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrAttemptClosed
+		}
+
+		_, err := recomputeHasilKuis(tx, attempt.Users_id, attempt.Kuis_id)
+		return err
+	})
+	if err != nil {
+		return attempt, err
+	}
+
+	attempt.Status = status
+	attempt.FinishedAt = &finishedAt
+	attempt.Late = late
+	applyGrade(&attempt, graded)
+	return attempt, nil
+}
+
+// FinishExpiredAttempts closes every running attempt whose time limit has passed
+func FinishExpiredAttempts() (int, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return 0, err
+	}
+
+	var expired []models.KuisAttempt
+	if err := db.Where("status = ? AND expires_at IS NOT NULL AND expires_at < ?", models.AttemptInProgress, time.Now()).Find(&expired).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch expired attempts: %w", err)
+	}
+
+	finished := 0
+	for _, attempt := range expired {
+		if _, err := FinishAttempt(attempt.ID); err != nil {
+			if errors.Is(err, ErrAttemptClosed) {
+				continue
+			}
+			return finished, err
+		}
+		finished++
+	}
+
+	return finished, nil
+}
+
+// AttemptRemainingSeconds returns the seconds left before the attempt expires, or nil if it has no limit
+func AttemptRemainingSeconds(attempt models.KuisAttempt) *int64 {
+	if attempt.ExpiresAt == nil || attempt.Status != models.AttemptInProgress {
+		return nil
+	}
+	remaining := int64(time.Until(*attempt.ExpiresAt).Seconds())
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &remaining
+}
+
+// gradeColumns lists the KuisAttempt columns written by applyGrade
+var gradeColumns = []string{"score", "correct_answer", "raw_points", "max_points", "percentage", "review_status", "passed"}
+
+// applyGrade copies a grading result onto an attempt
+func applyGrade(attempt *models.KuisAttempt, graded GradeResult) {
+	attempt.Score = graded.Score
+	attempt.Correct_Answer = graded.Correct
+	attempt.RawPoints = graded.RawPoints
+	attempt.MaxPoints = graded.MaxPoints
+	attempt.Percentage = graded.Percentage
+	attempt.ReviewStatus = graded.ReviewStatus()
+	attempt.Passed = graded.Passed
+}
+
+// gradeAttempt grades the answers of an attempt and applies the late penalty and pass mark of the kuis
+func gradeAttempt(settings models.KuisSettings, late bool, soalList []models.Soal, answers []models.SoalAnswer) GradeResult {
+	graded := GradeAnswers(settings, soalList, answers)
+
+	if late && settings.LateSubmission == models.LatePenalize && settings.LatePenalty > 0 {
+		factor := 1 - settings.LatePenalty
+		graded.RawPoints = roundTo(graded.RawPoints*factor, 4)
+		graded.Percentage = roundTo(graded.Percentage*factor, 2)
+		graded.Score = uint(graded.Percentage)
+	}
+
+	graded.Passed = passedFor(settings, graded.Percentage)
+	return graded
+}
+
+// regradeAttempt grades the stored answers of a finished attempt again, with the settings of the
+// version it was taken on, and updates the official result
+func regradeAttempt(tx *gorm.DB, attempt models.KuisAttempt) (models.KuisAttempt, error) {
+	var kuis models.Kuis
+	if err := tx.First(&kuis, attempt.Kuis_id).Error; err != nil {
+		return attempt, ErrKuisNotFound
+	}
+	settings, err := attemptSettings(tx, attempt, kuis)
+	if err != nil {
+		return attempt, err
+	}
+
+	soalList, err := attemptSoal(tx, attempt)
+	if err != nil {
+		return attempt, err
+	}
+
+	var answers []models.SoalAnswer
+	if err := tx.Where("attempt_id = ?", attempt.ID).Find(&answers).Error; err != nil {
+		return attempt, fmt.Errorf("failed to fetch attempt answers: %w", err)
+	}
+
+	applyGrade(&attempt, gradeAttempt(settings, attempt.Late, soalList, answers))
+	if err := tx.Model(&attempt).Select(gradeColumns).Updates(&attempt).Error; err != nil {
+		return attempt, fmt.Errorf("failed to update attempt: %w", err)
+	}
+
+	if _, err := recomputeHasilKuis(tx, attempt.Users_id, attempt.Kuis_id); err != nil {
+		return attempt, err
+	}
+
+	return attempt, nil
+}
+
+// SubmitAttempt records a whole set of answers at once. The answers go through a regular attempt
+// that is started and finished right away, so it is refused for kuis with a time limit, a question
+// pool or shuffling: those need the attempt to be started before the questions are shown.
+func SubmitAttempt(userID uint, kuisID uint, answers []models.SoalAnswer) (models.KuisAttempt, models.Hasil_Kuis, error) {
+	var attempt models.KuisAttempt
+	var result models.Hasil_Kuis
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return attempt, result, err
+	}
+
+	kuis, err := GetKuisByID(kuisID)
+	if err != nil {
+		return attempt, result, err
+	}
+	if kuis, err = publishedKuis(db, kuis); err != nil {
+		return attempt, result, err
+	}
+	if requiresStartedAttempt(kuis.KuisSettings) {
+		return attempt, result, ErrAttemptRequired
+	}
+
+	// The answers are normalized per soal type before the attempt starts, so an invalid payload is
+	// refused like in SaveAnswer instead of being stored as is or leaving an unfinished attempt behind
+	published, err := GetPublishedSoal(kuis)
+	if err != nil {
+		return attempt, result, err
+	}
+	normalized := make(map[uint]string, len(answers))
+	for _, answer := range answers {
+		soal, ok := findSoal(published, answer.Soal_id)
+		if !ok {
+			continue
+		}
+		payload, err := json.Marshal(answer.Answer)
+		if err != nil {
+			return attempt, result, fmt.Errorf("%w: soal %d: %v", ErrInvalidAnswer, soal.ID, err)
+		}
+		value, err := NormalizeAnswer(soal, payload)
+		if err != nil {
+			return attempt, result, fmt.Errorf("soal %d: %w", soal.ID, err)
+		}
+		normalized[soal.ID] = value
+	}
+
+	// A one-shot submission is still accepted inside the late grace period
+	attempt, err = startAttempt(userID, kuisID, true)
+	if err != nil {
+		return attempt, result, err
+	}
+
+	soalList, err := attemptSoal(db, attempt)
+	if err != nil {
+		return attempt, result, err
+	}
+
+	// Answers for soal outside the attempt are dropped
+	for _, answer := range answers {
+		soal, ok := findSoal(soalList, answer.Soal_id)
+		value, valid := normalized[answer.Soal_id]
+		if !ok || !valid {
+			continue
+		}
+		if _, err := storeAttemptAnswer(db, attempt, soal.ID, value); err != nil {
+			return attempt, result, err
+		}
+	}
+
+	attempt, err = FinishAttempt(attempt.ID)
+	if err != nil {
+		return attempt, result, err
+	}
+
+	if err := db.Where("users_id = ? AND kuis_id = ?", userID, kuisID).First(&result).Error; err != nil {
+		return attempt, result, fmt.Errorf("failed to look up result: %w", err)
+	}
+
+	return attempt, result, nil
+}
+
+// requiresStartedAttempt reports whether the kuis can only be taken through StartAttempt:
+// a time limit needs a start time and a drawn or shuffled layout has to be shown before answering
+func requiresStartedAttempt(settings models.KuisSettings) bool {
+	return settings.TimeLimit > 0 || settings.PoolSize > 0 || settings.ShuffleQuestions || settings.ShuffleOptions
+}
+
+// nextAttemptNumber returns the number the next attempt of a user on a kuis should get
+func nextAttemptNumber(tx *gorm.DB, userID uint, kuisID uint) (uint, error) {
+	var last uint
+	if err := tx.Unscoped().Model(&models.KuisAttempt{}).
+		Where("users_id = ? AND kuis_id = ?", userID, kuisID).
+		Select("COALESCE(MAX(attempt_number), 0)").Scan(&last).Error; err != nil {
+		return 0, fmt.Errorf("failed to count attempts: %w", err)
+	}
+	return last + 1, nil
+}
+
+// attemptExpired reports whether the attempt's deadline is before now
+func attemptExpired(attempt models.KuisAttempt, now time.Time) bool {
+	return attempt.ExpiresAt != nil && now.After(*attempt.ExpiresAt)
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned when the schedule or attempt rules of a kuis do not allow a new attempt
+var (
+	ErrKuisNotOpen         = errors.New("kuis is not open yet")
+	ErrKuisClosed          = errors.New("kuis is closed")
+	ErrAttemptLimitReached = errors.New("attempt limit reached")
+	ErrAttemptCooldown     = errors.New("next attempt is not available yet")
+)
+
+// kuisRuleCodes are the machine readable codes of the kuis rule errors
+var kuisRuleCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrKuisNotOpen, "kuis_not_open"},
+	{ErrKuisClosed, "kuis_closed"},
+	{ErrAttemptLimitReached, "attempt_limit_reached"},
+	{ErrAttemptCooldown, "attempt_cooldown"},
+}
+
+// KuisRuleCode returns the code of a kuis rule error, or "" for any other error
+func KuisRuleCode(err error) string {
+	for _, rule := range kuisRuleCodes {
+		if errors.Is(err, rule.err) {
+			return rule.code
+		}
+	}
+	return ""
+}
+
+// lateDeadline returns until when answers are still accepted, or nil if the kuis never closes
+func lateDeadline(settings models.KuisSettings) *time.Time {
+	if settings.ClosesAt == nil {
+		return nil
+	}
+	deadline := *settings.ClosesAt
+	if settings.LateSubmission == models.LateAccept || settings.LateSubmission == models.LatePenalize {
+		deadline = deadline.Add(time.Duration(settings.LateGraceMinutes) * time.Minute)
+	}
+	return &deadline
+}
+
+// submittedLate reports whether an attempt finished at the given time counts as late
+func submittedLate(settings models.KuisSettings, finishedAt time.Time) bool {
+	return settings.ClosesAt != nil && finishedAt.After(*settings.ClosesAt)
+}
+
+// attemptDeadline returns when an attempt started at the given time has to be finished,
+// combining the time limit with the closing time of the kuis
+func attemptDeadline(settings models.KuisSettings, startedAt time.Time) *time.Time {
+	var deadline *time.Time
+	if settings.TimeLimit > 0 {
+		limit := startedAt.Add(time.Duration(settings.TimeLimit) * time.Minute)
+		deadline = &limit
+	}
+	if closing := lateDeadline(settings); closing != nil && (deadline == nil || closing.Before(*deadline)) {
+		deadline = closing
+	}
+	return deadline
+}
+
+// evaluateAvailability applies the schedule and attempt rules of a kuis to the attempts a user already made.
+// allowLate accepts a one-shot submission inside the late grace period.
+func evaluateAvailability(kuis models.Kuis, attempts []models.KuisAttempt, now time.Time, allowLate bool) (models.KuisAvailability, error) {
+	availability := models.KuisAvailability{Status: models.KuisOpen, AttemptsUsed: uint(len(attempts))}
+
+	if kuis.MaxAttempts > 0 {
+		left := uint(0)
+		if kuis.MaxAttempts > availability.AttemptsUsed {
+			left = kuis.MaxAttempts - availability.AttemptsUsed
+		}
+		availability.AttemptsLeft = &left
+	}
+
+	var ruleErr error
+	switch {
+	case kuis.OpensAt != nil && now.Before(*kuis.OpensAt):
+		availability.Status = models.KuisNotOpen
+		availability.NextAttemptAt = kuis.OpensAt
+		ruleErr = fmt.Errorf("%w: opens at %s", ErrKuisNotOpen, kuis.OpensAt.Format(time.RFC3339))
+	case kuis.ClosesAt != nil && !now.Before(*kuis.ClosesAt):
+		availability.Status = models.KuisClosed
+		if !allowLate || !now.Before(*lateDeadline(kuis.KuisSettings)) {
+			ruleErr = fmt.Errorf("%w: closed at %s", ErrKuisClosed, kuis.ClosesAt.Format(time.RFC3339))
+		}
+	}
+
+	if ruleErr == nil && availability.AttemptsLeft != nil && *availability.AttemptsLeft == 0 {
+		ruleErr = fmt.Errorf("%w: %d of %d attempts used", ErrAttemptLimitReached, availability.AttemptsUsed, kuis.MaxAttempts)
+	}
+
+	if ruleErr == nil && kuis.CooldownMinutes > 0 {
+		var lastFinished *time.Time
+		for _, attempt := range attempts {
+			if attempt.FinishedAt != nil && (lastFinished == nil || attempt.FinishedAt.After(*lastFinished)) {
+				lastFinished = attempt.FinishedAt
+			}
+		}
+		if lastFinished != nil {
+			next := lastFinished.Add(time.Duration(kuis.CooldownMinutes) * time.Minute)
+			if now.Before(next) {
+				availability.NextAttemptAt = &next
+				ruleErr = fmt.Errorf("%w: next attempt at %s", ErrAttemptCooldown, next.Format(time.RFC3339))
+			}
+		}
+	}
+
+	availability.CanAttempt = ruleErr == nil
+	availability.Code = KuisRuleCode(ruleErr)
+	return availability, ruleErr
+}
+
+// checkNewAttempt returns an error if the user may not start a new attempt on the kuis now
+func checkNewAttempt(tx *gorm.DB, kuis models.Kuis, userID uint, now time.Time, allowLate bool) error {
+	var attempts []models.KuisAttempt
+	if err := tx.Where("users_id = ? AND kuis_id = ?", userID, kuis.ID).Find(&attempts).Error; err != nil {
+		return fmt.Errorf("failed to fetch attempts: %w", err)
+	}
+
+	_, err := evaluateAvailability(kuis, attempts, now, allowLate)
+	return err
+}
+
+// attachAvailability fills in the availability of every kuis for the user
+func attachAvailability(tx *gorm.DB, userID uint, kuisList []models.Kuis, now time.Time) error {
+	if len(kuisList) == 0 {
+		return nil
+	}
+
+	kuisIDs := make([]uint, 0, len(kuisList))
+	for _, kuis := range kuisList {
+		kuisIDs = append(kuisIDs, kuis.ID)
+	}
+
+	var attempts []models.KuisAttempt
+	if err := tx.Where("users_id = ? AND kuis_id IN ?", userID, kuisIDs).Find(&attempts).Error; err != nil {
+		return fmt.Errorf("failed to fetch attempts: %w", err)
+	}
+
+	attemptsByKuis := make(map[uint][]models.KuisAttempt)
+	for _, attempt := range attempts {
+		attemptsByKuis[attempt.Kuis_id] = append(attemptsByKuis[attempt.Kuis_id], attempt)
+	}
+
+	for i := range kuisList {
+		availability, _ := evaluateAvailability(kuisList[i], attemptsByKuis[kuisList[i].ID], now, false)
+		kuisList[i].Availability = &availability
+	}
+
+	return nil
+}
+
+// passedFor reports whether a percentage reaches the pass mark, or nil if the kuis has none
+func passedFor(settings models.KuisSettings, percentage float64) *bool {
+	if settings.PassMark <= 0 {
+		return nil
+	}
+	passed := percentage >= settings.PassMark
+	return &passed
+}
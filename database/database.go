@@ -107,6 +107,11 @@ func InitDB() (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	// Akun yang sudah ada sebelum verifikasi email diperkenalkan dianggap sudah terverifikasi
+	backfillVerified := db.Migrator().HasTable(&models.Users{}) && !db.Migrator().HasColumn(&models.Users{}, "EmailVerifiedAt")
+	// Sebelum ada locked_by_admin, kunci tanpa batas waktu hanya bisa berasal dari admin
+	backfillAdminLocks := db.Migrator().HasTable(&models.Users{}) && !db.Migrator().HasColumn(&models.Users{}, "LockedByAdmin")
+
 	// Run AutoMigrate to ensure the database schema is up to date
 	if err := db.AutoMigrate(
 		&models.Users{},
@@ -115,15 +120,62 @@ func InitDB() (*gorm.DB, error) {
 		&models.Kelas{},
 		&models.Kuis{},
 		&models.Soal{},
+		&models.KuisVersion{},
+		&models.SoalCorrection{},
 		&models.Pendidikan{},
 		&models.Hasil_Kuis{},
+		&models.KuisAttempt{},
 		&models.SoalAnswer{},
 		&models.Kelas_Pengguna{},
+		&models.KelasTeacher{},
 		&models.AuditLog{},
+		&models.Session{},
+		&models.PasswordResetToken{},
+		&models.EmailVerificationToken{},
+		&models.Invitation{},
+		&models.RecoveryCode{},
+		&models.LoginChallenge{},
+		&models.OIDCLoginState{},
+		&models.UserIdentity{},
+		&models.RateLimitBucket{},
+		&models.APIKey{},
+		&models.Setting{},
+		&models.Role{},
+		&models.Permission{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	// Role dan permission bawaan yang sesuai dengan perilaku role admin, teacher dan student
+	if err := seedRoles(db); err != nil {
+		return nil, err
+	}
+
+	// Kuis yang sudah dipublikasikan sebelum ada versi mendapat versi pertamanya sekali di sini,
+	// bukan saat pertama kali dibaca siswa
+	if err := snapshotPublishedKuis(db); err != nil {
+		return nil, err
+	}
+
+	// Hasil kuis dari sebelum ada attempt menjadi attempt pertama, sebelum nilai resmi dihitung ulang
+	if err := backfillLegacyAttempts(db); err != nil {
+		return nil, err
+	}
+
+	if backfillVerified {
+		if err := db.Model(&models.Users{}).Where("email_verified_at IS NULL").
+			Update("email_verified_at", gorm.Expr("created_at")).Error; err != nil {
+			return nil, fmt.Errorf("failed to mark existing users as verified: %w", err)
+		}
+	}
+
+	if backfillAdminLocks {
+		if err := db.Model(&models.Users{}).Where("locked_until = ?", lockedIndefinitely).
+			Update("locked_by_admin", true).Error; err != nil {
+			return nil, fmt.Errorf("failed to mark admin locks: %w", err)
+		}
+	}
+
 	log.Printf("Database connected successfully with %d max open connections and %d max idle connections",
 		getEnvAsInt("DB_MAX_OPEN_CONNS", 25), getEnvAsInt("DB_MAX_IDLE_CONNS", 10))
 
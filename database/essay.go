@@ -0,0 +1,150 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the manual grading functions
+var (
+	ErrAnswerNotFound     = errors.New("answer not found")
+	ErrNotEssayAnswer     = errors.New("answer does not belong to an essay soal")
+	ErrInvalidPoints      = errors.New("points are out of range")
+	ErrAttemptNotFinished = errors.New("attempt is not finished yet")
+)
+
+// GetGradingQueue retrieves the essay answers that still wait for manual grading.
+// With teacherID set, only answers to kuis that teacher created or that belong to a kelas they teach are returned.
+// Each answer carries the soal of the version the student answered, not the current draft.
+func GetGradingQueue(teacherID uint, kuisID uint) ([]models.SoalAnswer, error) {
+	var answers []models.SoalAnswer
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return answers, err
+	}
+
+	kuisQuery := db.Model(&models.Kuis{}).Select("id")
+	if teacherID != 0 {
+		kuisQuery = kuisQuery.Where("id IN (?)", authoredKuis(db, teacherID))
+	}
+	if kuisID != 0 {
+		kuisQuery = kuisQuery.Where("id = ?", kuisID)
+	}
+	// Only attempts with ungraded essays are still pending review
+	attemptQuery := db.Model(&models.KuisAttempt{}).Select("id").
+		Where("status <> ? AND review_status = ? AND kuis_id IN (?)", models.AttemptInProgress, models.ReviewPending, kuisQuery)
+
+	// Oldest answers first so students wait the shortest time
+	var candidates []models.SoalAnswer
+	if err := db.Preload("User").Preload("Attempt").
+		Where("attempt_id IN (?)", attemptQuery).
+		Where("awarded_points IS NULL AND TRIM(answer) <> ''").
+		Order("created_at").Find(&candidates).Error; err != nil {
+		return answers, fmt.Errorf("failed to retrieve grading queue: %w", err)
+	}
+
+	// The soal type and points come from the version of each attempt
+	soalByAttempt := make(map[uint][]models.Soal)
+	answers = make([]models.SoalAnswer, 0, len(candidates))
+	for _, answer := range candidates {
+		soalList, ok := soalByAttempt[answer.Attempt.ID]
+		if !ok {
+			if soalList, err = attemptSoal(db, answer.Attempt); err != nil {
+				return answers, err
+			}
+			soalByAttempt[answer.Attempt.ID] = soalList
+		}
+
+		soal, ok := findSoal(soalList, answer.Soal_id)
+		if !ok || soal.QuestionType() != models.SoalEssay {
+			continue
+		}
+		answer.Soal = soal
+		answers = append(answers, answer)
+	}
+
+	return answers, nil
+}
+
+// GetSoalAnswer retrieves an answer together with its soal and attempt
+func GetSoalAnswer(id uint) (models.SoalAnswer, error) {
+	var answer models.SoalAnswer
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return answer, err
+	}
+
+	if err := db.Preload("Soal").Preload("Attempt").First(&answer, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return answer, ErrAnswerNotFound
+		}
+		return answer, fmt.Errorf("failed to retrieve answer: %w", err)
+	}
+
+	return answer, nil
+}
+
+// GradeEssayAnswer stores the points and feedback of a teacher, then re-scores the attempt
+func GradeEssayAnswer(answerID uint, points float64, feedback string, graderID uint) (models.SoalAnswer, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return models.SoalAnswer{}, err
+	}
+
+	answer, err := GetSoalAnswer(answerID)
+	if err != nil {
+		return answer, err
+	}
+	if answer.Attempt_id == nil {
+		return answer, ErrNotEssayAnswer
+	}
+
+	attempt, err := GetAttempt(*answer.Attempt_id)
+	if err != nil {
+		return answer, err
+	}
+	if attempt.Status == models.AttemptInProgress {
+		return answer, ErrAttemptNotFinished
+	}
+
+	// The type and points are those of the soal in the version the student answered
+	soalList, err := attemptSoal(db, attempt)
+	if err != nil {
+		return answer, err
+	}
+	soal, ok := findSoal(soalList, answer.Soal_id)
+	if !ok || soal.QuestionType() != models.SoalEssay {
+		return answer, ErrNotEssayAnswer
+	}
+	answer.Soal = soal
+
+	maxPoints := SoalPoints(soal)
+	if points < 0 || points > maxPoints {
+		return answer, fmt.Errorf("%w: points must be between 0 and %g", ErrInvalidPoints, maxPoints)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		answer.AwardedPoints = &points
+		answer.Feedback = feedback
+		answer.GradedBy = &graderID
+		answer.GradedAt = &now
+		if err := tx.Model(&answer).Select("awarded_points", "feedback", "graded_by", "graded_at").Updates(&answer).Error; err != nil {
+			return fmt.Errorf("failed to save grade: %w", err)
+		}
+
+		_, err := regradeAttempt(tx, attempt)
+		return err
+	})
+
+	return answer, err
+}
@@ -0,0 +1,683 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+)
+
+// ErrInvalidSoal is returned when a soal's type or answer key is not valid
+var ErrInvalidSoal = errors.New("invalid soal")
+
+// ErrInvalidAnswer is returned when an answer payload does not fit the soal type
+var ErrInvalidAnswer = errors.New("invalid answer")
+
+// questionGrader validates and grades answers for one soal type.
+// Answers are stored in SoalAnswer.Answer as plain text for scalar types
+// and as JSON text for list or map types.
+type questionGrader interface {
+	// validateKey checks that the soal carries a usable answer key
+	validateKey(soal models.Soal) error
+	// parseAnswer turns an API payload into the stored answer text
+	parseAnswer(raw json.RawMessage) (string, error)
+	// grade returns the credit (0..1) earned by a stored answer
+	grade(soal models.Soal, answer string) float64
+}
+
+var graders = map[string]questionGrader{
+	models.SoalSingleChoice:   singleChoiceGrader{},
+	models.SoalMultipleSelect: multipleSelectGrader{},
+	models.SoalTrueFalse:      trueFalseGrader{},
+	models.SoalShortText:      shortTextGrader{},
+	models.SoalNumeric:        numericGrader{},
+	models.SoalOrdering:       orderingGrader{},
+	models.SoalMatching:       matchingGrader{},
+	models.SoalFillBlank:      fillBlankGrader{},
+	models.SoalEssay:          essayGrader{},
+}
+
+// manualGrader is implemented by soal types that a teacher grades by hand
+type manualGrader interface {
+	// manualCredit returns the credit of a graded answer, or false while it waits for review
+	manualCredit(soal models.Soal, answer models.SoalAnswer) (float64, bool)
+}
+
+// graderFor returns the grader of the soal type
+func graderFor(soal models.Soal) (questionGrader, error) {
+	grader, ok := graders[soal.QuestionType()]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown soal type %q", ErrInvalidSoal, soal.Type)
+	}
+	return grader, nil
+}
+
+// ValidateSoal checks the type, points and answer key of a soal before it is saved
+func ValidateSoal(soal models.Soal) error {
+	if soal.Points < 0 {
+		return fmt.Errorf("%w: points cannot be negative", ErrInvalidSoal)
+	}
+
+	grader, err := graderFor(soal)
+	if err != nil {
+		return err
+	}
+	return grader.validateKey(soal)
+}
+
+// NormalizeAnswer converts an answer payload to the text stored for the soal type
+func NormalizeAnswer(soal models.Soal, raw json.RawMessage) (string, error) {
+	grader, err := graderFor(soal)
+	if err != nil {
+		return "", err
+	}
+	return grader.parseAnswer(raw)
+}
+
+// GradeAnswer returns the credit (0..1) a stored answer earns for a soal
+func GradeAnswer(soal models.Soal, answer string) float64 {
+	grader, err := graderFor(soal)
+	if err != nil {
+		return 0
+	}
+	return grader.grade(soal, answer)
+}
+
+// GradeResult is the outcome of grading the answers of one attempt
+type GradeResult struct {
+	Correct       uint    // fully correct answers
+	Score         uint    // percentage rounded down, kept for older clients
+	RawPoints     float64 // points earned after penalties, never below zero
+	MaxPoints     float64 // sum of the points of every soal
+	Percentage    float64 // RawPoints / MaxPoints * 100, two decimals
+	PendingReview uint    // answers still waiting for manual grading
+	Passed        *bool   // nil when the kuis has no pass mark
+}
+
+// ReviewStatus returns the review status matching the result
+func (r GradeResult) ReviewStatus() string {
+	if r.PendingReview > 0 {
+		return models.ReviewPending
+	}
+	return models.ReviewFinal
+}
+
+// GradeAnswers grades every answer with the grader of its soal type and applies the
+// negative marking of the kuis. Answers that need manual grading count as zero until
+// a teacher grades them.
+func GradeAnswers(settings models.KuisSettings, soalList []models.Soal, answers []models.SoalAnswer) GradeResult {
+	var result GradeResult
+
+	// Only the last answer given for a soal counts
+	answerBySoal := make(map[uint]models.SoalAnswer, len(answers))
+	for _, answer := range answers {
+		answerBySoal[answer.Soal_id] = answer
+	}
+
+	for _, soal := range soalList {
+		points := SoalPoints(soal)
+		result.MaxPoints += points
+
+		answer, ok := answerBySoal[soal.ID]
+		if !ok || isBlankAnswer(answer.Answer) {
+			result.RawPoints -= settings.BlankPenalty * points
+			continue
+		}
+
+		grader, err := graderFor(soal)
+		if err != nil {
+			continue
+		}
+
+		var earned float64
+		if manual, ok := grader.(manualGrader); ok {
+			var graded bool
+			earned, graded = manual.manualCredit(soal, answer)
+			if !graded {
+				result.PendingReview++
+			}
+		} else {
+			earned = grader.grade(soal, answer.Answer)
+			// Negative marking only applies to auto-graded answers that earned nothing
+			if earned == 0 {
+				result.RawPoints -= settings.WrongPenalty * points
+			}
+		}
+
+		result.RawPoints += earned * points
+		if earned >= 1 {
+			result.Correct++
+		}
+	}
+
+	result.RawPoints = math.Max(0, roundTo(result.RawPoints, 4))
+	result.MaxPoints = roundTo(result.MaxPoints, 4)
+	if result.MaxPoints > 0 {
+		result.Percentage = roundTo(result.RawPoints/result.MaxPoints*100, 2)
+	}
+	result.Score = uint(result.Percentage)
+
+	return result
+}
+
+// SoalPoints returns the points a soal is worth; older rows without points are worth 1
+func SoalPoints(soal models.Soal) float64 {
+	if soal.Points <= 0 {
+		return 1
+	}
+	return soal.Points
+}
+
+// singleChoiceGrader: one option key, compared exactly with Correct_answer
+type singleChoiceGrader struct{}
+
+func (singleChoiceGrader) validateKey(soal models.Soal) error {
+	if strings.TrimSpace(soal.Correct_answer) == "" {
+		return fmt.Errorf("%w: correct_answer is required", ErrInvalidSoal)
+	}
+	return nil
+}
+
+func (singleChoiceGrader) parseAnswer(raw json.RawMessage) (string, error) {
+	return parseScalarAnswer(raw)
+}
+
+func (singleChoiceGrader) grade(soal models.Soal, answer string) float64 {
+	return creditIf(answer == soal.Correct_answer)
+}
+
+// multipleSelectGrader: a set of option keys, answer_key ["A","C"]
+type multipleSelectGrader struct{}
+
+func (multipleSelectGrader) validateKey(soal models.Soal) error {
+	var key []string
+	if err := json.Unmarshal(soal.AnswerKey, &key); err != nil || len(key) == 0 {
+		return fmt.Errorf("%w: answer_key must be a non-empty list of options", ErrInvalidSoal)
+	}
+	switch soal.PartialCredit {
+	case "", models.PartialCreditNone, models.PartialCreditPerCorrect, models.PartialCreditRightMinusWrong:
+	default:
+		return fmt.Errorf("%w: partial_credit must be one of none, per_correct, right_minus_wrong", ErrInvalidSoal)
+	}
+	return nil
+}
+
+func (multipleSelectGrader) parseAnswer(raw json.RawMessage) (string, error) {
+	values, err := parseListAnswer(raw)
+	if err != nil {
+		return "", err
+	}
+	return encodeList(uniqueSorted(values))
+}
+
+func (multipleSelectGrader) grade(soal models.Soal, answer string) float64 {
+	var key []string
+	if err := json.Unmarshal(soal.AnswerKey, &key); err != nil {
+		return 0
+	}
+	key = uniqueSorted(key)
+	chosen := uniqueSorted(decodeList(answer))
+
+	correct := make(map[string]bool, len(key))
+	for _, option := range key {
+		correct[option] = true
+	}
+	var right, wrong float64
+	for _, option := range chosen {
+		if correct[option] {
+			right++
+		} else {
+			wrong++
+		}
+	}
+
+	switch soal.PartialCredit {
+	case models.PartialCreditPerCorrect:
+		if wrong > 0 {
+			return 0
+		}
+		return right / float64(len(key))
+	case models.PartialCreditRightMinusWrong:
+		return math.Max(0, (right-wrong)/float64(len(key)))
+	default:
+		return creditIf(equalStrings(chosen, key))
+	}
+}
+
+// trueFalseGrader: Correct_answer "true" or "false"
+type trueFalseGrader struct{}
+
+func (trueFalseGrader) validateKey(soal models.Soal) error {
+	if _, ok := parseBool(soal.Correct_answer); !ok {
+		return fmt.Errorf("%w: correct_answer must be true or false", ErrInvalidSoal)
+	}
+	return nil
+}
+
+func (trueFalseGrader) parseAnswer(raw json.RawMessage) (string, error) {
+	text, err := parseScalarAnswer(raw)
+	if err != nil || text == "" {
+		return "", err
+	}
+	value, ok := parseBool(text)
+	if !ok {
+		return "", fmt.Errorf("%w: answer must be true or false", ErrInvalidAnswer)
+	}
+	return strconv.FormatBool(value), nil
+}
+
+func (trueFalseGrader) grade(soal models.Soal, answer string) float64 {
+	expected, _ := parseBool(soal.Correct_answer)
+	value, ok := parseBool(answer)
+	return creditIf(ok && value == expected)
+}
+
+// shortTextKey is the answer_key of a short_text soal
+type shortTextKey struct {
+	Accepted      []string `json:"accepted"`
+	CaseSensitive bool     `json:"case_sensitive"`
+}
+
+// shortTextGrader: free text matched against accepted variants after normalization
+type shortTextGrader struct{}
+
+func (shortTextGrader) key(soal models.Soal) shortTextKey {
+	var key shortTextKey
+	_ = json.Unmarshal(soal.AnswerKey, &key)
+	if soal.Correct_answer != "" {
+		key.Accepted = append(key.Accepted, soal.Correct_answer)
+	}
+	return key
+}
+
+func (g shortTextGrader) validateKey(soal models.Soal) error {
+	if len(soal.AnswerKey) > 0 {
+		var key shortTextKey
+		if err := json.Unmarshal(soal.AnswerKey, &key); err != nil {
+			return fmt.Errorf("%w: answer_key must be {\"accepted\": [...], \"case_sensitive\": bool}", ErrInvalidSoal)
+		}
+	}
+	if len(g.key(soal).Accepted) == 0 {
+		return fmt.Errorf("%w: at least one accepted answer is required", ErrInvalidSoal)
+	}
+	return nil
+}
+
+func (shortTextGrader) parseAnswer(raw json.RawMessage) (string, error) {
+	return parseScalarAnswer(raw)
+}
+
+func (g shortTextGrader) grade(soal models.Soal, answer string) float64 {
+	key := g.key(soal)
+	return creditIf(matchesAny(answer, key.Accepted, key.CaseSensitive))
+}
+
+// numericKey is the answer_key of a numeric soal
+type numericKey struct {
+	Value     *float64 `json:"value"`
+	Tolerance float64  `json:"tolerance"`
+}
+
+// numericGrader: a number accepted within an absolute tolerance
+type numericGrader struct{}
+
+func (numericGrader) key(soal models.Soal) (numericKey, bool) {
+	var key numericKey
+	if len(soal.AnswerKey) > 0 {
+		if err := json.Unmarshal(soal.AnswerKey, &key); err != nil {
+			return key, false
+		}
+	}
+	if key.Value == nil {
+		value, err := parseNumber(soal.Correct_answer)
+		if err != nil {
+			return key, false
+		}
+		key.Value = &value
+	}
+	return key, key.Tolerance >= 0
+}
+
+func (g numericGrader) validateKey(soal models.Soal) error {
+	if _, ok := g.key(soal); !ok {
+		return fmt.Errorf("%w: answer_key must be {\"value\": number, \"tolerance\": number}", ErrInvalidSoal)
+	}
+	return nil
+}
+
+func (numericGrader) parseAnswer(raw json.RawMessage) (string, error) {
+	text, err := parseScalarAnswer(raw)
+	if err != nil || text == "" {
+		return "", err
+	}
+	value, err := parseNumber(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: answer must be a number", ErrInvalidAnswer)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64), nil
+}
+
+func (g numericGrader) grade(soal models.Soal, answer string) float64 {
+	key, ok := g.key(soal)
+	if !ok {
+		return 0
+	}
+	value, err := parseNumber(answer)
+	if err != nil {
+		return 0
+	}
+	// Small epsilon so that a tolerance of 0.1 accepts 0.1 despite float rounding
+	return creditIf(math.Abs(value-*key.Value) <= key.Tolerance+1e-9)
+}
+
+// orderingGrader: option keys in the correct order, answer_key ["C","A","B"]
+type orderingGrader struct{}
+
+func (orderingGrader) validateKey(soal models.Soal) error {
+	var key []string
+	if err := json.Unmarshal(soal.AnswerKey, &key); err != nil || len(key) < 2 {
+		return fmt.Errorf("%w: answer_key must list at least two items in order", ErrInvalidSoal)
+	}
+	return nil
+}
+
+func (orderingGrader) parseAnswer(raw json.RawMessage) (string, error) {
+	values, err := parseListAnswer(raw)
+	if err != nil {
+		return "", err
+	}
+	return encodeList(values)
+}
+
+func (orderingGrader) grade(soal models.Soal, answer string) float64 {
+	var key []string
+	if err := json.Unmarshal(soal.AnswerKey, &key); err != nil {
+		return 0
+	}
+	return creditIf(equalStrings(decodeList(answer), key))
+}
+
+// matchingGrader: pairs of left and right keys, answer_key {"A":"2","B":"1"}
+type matchingGrader struct{}
+
+func (matchingGrader) validateKey(soal models.Soal) error {
+	var key map[string]string
+	if err := json.Unmarshal(soal.AnswerKey, &key); err != nil || len(key) == 0 {
+		return fmt.Errorf("%w: answer_key must map each left item to its right item", ErrInvalidSoal)
+	}
+	return nil
+}
+
+func (matchingGrader) parseAnswer(raw json.RawMessage) (string, error) {
+	pairs, err := parseMapAnswer(raw)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(pairs)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAnswer, err)
+	}
+	return string(encoded), nil
+}
+
+func (matchingGrader) grade(soal models.Soal, answer string) float64 {
+	var key, pairs map[string]string
+	if err := json.Unmarshal(soal.AnswerKey, &key); err != nil {
+		return 0
+	}
+	if err := json.Unmarshal([]byte(answer), &pairs); err != nil || len(pairs) != len(key) {
+		return 0
+	}
+	for left, right := range key {
+		if pairs[left] != right {
+			return 0
+		}
+	}
+	return 1
+}
+
+// fillBlankGrader: one answer per blank, answer_key [["jakarta"], ["1945", "seribu sembilan ratus empat puluh lima"]]
+type fillBlankGrader struct{}
+
+func (fillBlankGrader) validateKey(soal models.Soal) error {
+	var key [][]string
+	if err := json.Unmarshal(soal.AnswerKey, &key); err != nil || len(key) == 0 {
+		return fmt.Errorf("%w: answer_key must list the accepted answers of every blank", ErrInvalidSoal)
+	}
+	for _, accepted := range key {
+		if len(accepted) == 0 {
+			return fmt.Errorf("%w: every blank needs at least one accepted answer", ErrInvalidSoal)
+		}
+	}
+	return nil
+}
+
+func (fillBlankGrader) parseAnswer(raw json.RawMessage) (string, error) {
+	values, err := parseListAnswer(raw)
+	if err != nil {
+		return "", err
+	}
+	return encodeList(values)
+}
+
+func (fillBlankGrader) grade(soal models.Soal, answer string) float64 {
+	var key [][]string
+	if err := json.Unmarshal(soal.AnswerKey, &key); err != nil {
+		return 0
+	}
+	values := decodeList(answer)
+	if len(values) != len(key) {
+		return 0
+	}
+	for i, accepted := range key {
+		if !matchesAny(values[i], accepted, false) {
+			return 0
+		}
+	}
+	return 1
+}
+
+// essayKey is the optional answer_key of an essay soal
+type essayKey struct {
+	Rubric string `json:"rubric"`
+}
+
+// essayGrader: free text graded manually by the teacher up to the soal's points, answer_key {"rubric": "..."}
+type essayGrader struct{}
+
+func (essayGrader) validateKey(soal models.Soal) error {
+	if len(soal.AnswerKey) > 0 {
+		var key essayKey
+		if err := json.Unmarshal(soal.AnswerKey, &key); err != nil {
+			return fmt.Errorf("%w: answer_key must be {\"rubric\": text}", ErrInvalidSoal)
+		}
+	}
+	return nil
+}
+
+func (essayGrader) parseAnswer(raw json.RawMessage) (string, error) {
+	return parseScalarAnswer(raw)
+}
+
+// grade is only used for blank essays; written essays go through manualCredit
+func (essayGrader) grade(soal models.Soal, answer string) float64 {
+	return 0
+}
+
+func (essayGrader) manualCredit(soal models.Soal, answer models.SoalAnswer) (float64, bool) {
+	if strings.TrimSpace(answer.Answer) == "" {
+		return 0, true
+	}
+	if answer.AwardedPoints == nil {
+		return 0, false
+	}
+	return *answer.AwardedPoints / SoalPoints(soal), true
+}
+
+// parseScalarAnswer accepts a JSON string, number or boolean and returns it as text
+func parseScalarAnswer(raw json.RawMessage) (string, error) {
+	raw = json.RawMessage(strings.TrimSpace(string(raw)))
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAnswer, err)
+	}
+	switch value.(type) {
+	case float64, bool:
+		return string(raw), nil
+	}
+	return "", fmt.Errorf("%w: expected a single value", ErrInvalidAnswer)
+}
+
+// parseListAnswer accepts a JSON list, or a string holding a JSON list or comma separated values
+func parseListAnswer(raw json.RawMessage) ([]string, error) {
+	var values []string
+	if err := json.Unmarshal(raw, &values); err == nil {
+		return values, nil
+	}
+
+	text, err := parseScalarAnswer(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: expected a list", ErrInvalidAnswer)
+	}
+	return decodeList(text), nil
+}
+
+// parseMapAnswer accepts a JSON object, or a string holding one
+func parseMapAnswer(raw json.RawMessage) (map[string]string, error) {
+	var pairs map[string]string
+	if err := json.Unmarshal(raw, &pairs); err == nil {
+		return pairs, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if err := json.Unmarshal([]byte(text), &pairs); err == nil {
+			return pairs, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: expected an object of pairs", ErrInvalidAnswer)
+}
+
+// decodeList reads a stored list answer; plain text is split on commas
+func decodeList(answer string) []string {
+	var values []string
+	if err := json.Unmarshal([]byte(answer), &values); err == nil {
+		return values
+	}
+	if strings.TrimSpace(answer) == "" {
+		return nil
+	}
+	for _, part := range strings.Split(answer, ",") {
+		values = append(values, strings.TrimSpace(part))
+	}
+	return values
+}
+
+func encodeList(values []string) (string, error) {
+	if values == nil {
+		values = []string{}
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAnswer, err)
+	}
+	return string(encoded), nil
+}
+
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeText trims and collapses whitespace, and lowercases unless case sensitive
+func normalizeText(text string, caseSensitive bool) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if !caseSensitive {
+		text = strings.ToLower(text)
+	}
+	return text
+}
+
+func matchesAny(answer string, accepted []string, caseSensitive bool) bool {
+	normalized := normalizeText(answer, caseSensitive)
+	if normalized == "" {
+		return false
+	}
+	for _, variant := range accepted {
+		if normalized == normalizeText(variant, caseSensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBool(text string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "true", "benar":
+		return true, true
+	case "false", "salah":
+		return false, true
+	}
+	return false, false
+}
+
+// parseNumber accepts both "3.5" and the Indonesian decimal comma "3,5"
+func parseNumber(text string) (float64, error) {
+	text = strings.ReplaceAll(strings.TrimSpace(text), ",", ".")
+	return strconv.ParseFloat(text, 64)
+}
+
+// isBlankAnswer reports whether a stored answer is empty, including empty lists and objects
+func isBlankAnswer(answer string) bool {
+	switch strings.TrimSpace(answer) {
+	case "", "[]", "{}", "null":
+		return true
+	}
+	return false
+}
+
+func roundTo(value float64, decimals int) float64 {
+	factor := math.Pow(10, float64(decimals))
+	return math.Round(value*factor) / factor
+}
+
+func creditIf(correct bool) float64 {
+	if correct {
+		return 1
+	}
+	return 0
+}
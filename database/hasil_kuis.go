@@ -0,0 +1,268 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// recomputeHasilKuis recalculates the official Hasil_Kuis of a user for a kuis from all finished attempts
+func recomputeHasilKuis(tx *gorm.DB, userID uint, kuisID uint) (models.Hasil_Kuis, error) {
+	var result models.Hasil_Kuis
+
+	var kuis models.Kuis
+	if err := tx.First(&kuis, kuisID).Error; err != nil {
+		return result, ErrKuisNotFound
+	}
+	// The scoring policy and pass mark are those of the published version, not the draft
+	kuis, err := publishedKuis(tx, kuis)
+	if err != nil {
+		return result, err
+	}
+
+	var attempts []models.KuisAttempt
+	if err := tx.Where("users_id = ? AND kuis_id = ? AND status <> ?", userID, kuisID, models.AttemptInProgress).
+		Order("attempt_number").Find(&attempts).Error; err != nil {
+		return result, fmt.Errorf("failed to fetch attempts: %w", err)
+	}
+
+	err = tx.Where("users_id = ? AND kuis_id = ?", userID, kuisID).First(&result).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return result, fmt.Errorf("failed to look up result: %w", err)
+	}
+	if len(attempts) == 0 {
+		return result, nil
+	}
+
+	policy := kuis.ScoringPolicy
+	if policy == "" {
+		policy = models.ScoringLatest
+	}
+
+	result.Users_id = userID
+	result.Kuis_id = kuisID
+	result.ScoringPolicy = policy
+	result.AttemptCount = uint(len(attempts))
+	result.Attempt_id = nil
+
+	// The official score is not final while any attempt still has ungraded essays
+	result.ReviewStatus = models.ReviewFinal
+	for _, attempt := range attempts {
+		if attempt.ReviewStatus == models.ReviewPending {
+			result.ReviewStatus = models.ReviewPending
+		}
+	}
+
+	if policy == models.ScoringAverage {
+		var totalCorrect, totalRaw, totalMax, totalPercentage float64
+		for _, attempt := range attempts {
+			totalCorrect += float64(attempt.Correct_Answer)
+			totalRaw += attempt.RawPoints
+			totalMax += attempt.MaxPoints
+			totalPercentage += attempt.Percentage
+		}
+		count := float64(len(attempts))
+		result.Correct_Answer = uint(math.Round(totalCorrect / count))
+		result.RawPoints = roundTo(totalRaw/count, 4)
+		result.MaxPoints = roundTo(totalMax/count, 4)
+		result.Percentage = roundTo(totalPercentage/count, 2)
+		result.Score = uint(result.Percentage)
+	} else {
+		official := officialAttempt(attempts, policy)
+		result.Score = official.Score
+		result.Correct_Answer = official.Correct_Answer
+		result.RawPoints = official.RawPoints
+		result.MaxPoints = official.MaxPoints
+		result.Percentage = official.Percentage
+		result.Attempt_id = &official.ID
+	}
+	result.Passed = passedFor(kuis.KuisSettings, result.Percentage)
+
+	if err := tx.Save(&result).Error; err != nil {
+		return result, fmt.Errorf("failed to save result: %w", err)
+	}
+
+	return result, nil
+}
+
+// officialAttempt picks the attempt that counts for the given policy; attempts must be ordered by attempt number
+func officialAttempt(attempts []models.KuisAttempt, policy string) models.KuisAttempt {
+	switch policy {
+	case models.ScoringFirst:
+		return attempts[0]
+	case models.ScoringBest:
+		best := attempts[0]
+		for _, attempt := range attempts[1:] {
+			if attempt.Percentage > best.Percentage {
+				best = attempt
+			}
+		}
+		return best
+	default:
+		return attempts[len(attempts)-1]
+	}
+}
+
+// recomputeHasilKuisForKuis recalculates every official result of a kuis, e.g. after a new version
+// changed its scoring policy
+func recomputeHasilKuisForKuis(tx *gorm.DB, kuisID uint) error {
+	var userIDs []uint
+	if err := tx.Model(&models.KuisAttempt{}).Where("kuis_id = ?", kuisID).Distinct().Pluck("users_id", &userIDs).Error; err != nil {
+		return fmt.Errorf("failed to fetch kuis participants: %w", err)
+	}
+
+	for _, userID := range userIDs {
+		if _, err := recomputeHasilKuis(tx, userID, kuisID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetHasilKuisByUser retrieves all official results of a user, each with its attempt history
+func GetHasilKuisByUser(userID uint) ([]models.Hasil_Kuis, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	return hasilKuisByUser(db, db.Where("users_id = ?", userID))
+}
+
+// GetTaughtHasilKuisByUser retrieves the official results of a user for the kuis in the kelas the teacher
+// created or co-teaches, each with its attempt history
+func GetTaughtHasilKuisByUser(teacherID uint, userID uint) ([]models.Hasil_Kuis, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	return hasilKuisByUser(db, db.Where("users_id = ? AND kuis_id IN (?)", userID, taughtKuis(db, teacherID)))
+}
+
+// hasilKuisByUser loads the results matched by the condition together with their attempts
+func hasilKuisByUser(db *gorm.DB, condition *gorm.DB) ([]models.Hasil_Kuis, error) {
+	var hasilKuisList []models.Hasil_Kuis
+	if err := db.Preload("Kuis").Where(condition).Find(&hasilKuisList).Error; err != nil {
+		return hasilKuisList, fmt.Errorf("failed to fetch quiz results: %w", err)
+	}
+
+	var attempts []models.KuisAttempt
+	if err := db.Where(condition).Order("attempt_number").Find(&attempts).Error; err != nil {
+		return hasilKuisList, fmt.Errorf("failed to fetch attempts: %w", err)
+	}
+
+	attemptsByKuis := make(map[uint][]models.KuisAttempt)
+	for _, attempt := range attempts {
+		attemptsByKuis[attempt.Kuis_id] = append(attemptsByKuis[attempt.Kuis_id], attempt)
+	}
+	for i := range hasilKuisList {
+		hasilKuisList[i].Attempts = attemptsByKuis[hasilKuisList[i].Kuis_id]
+	}
+
+	return hasilKuisList, nil
+}
+
+// GetHasilKuis retrieves the official result of a user for one kuis with its attempt history
+func GetHasilKuis(userID string, kuisID string) (models.Hasil_Kuis, error) {
+	var hasilKuis models.Hasil_Kuis
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return hasilKuis, err
+	}
+
+	if err := db.Where("users_id = ? AND kuis_id = ?", userID, kuisID).First(&hasilKuis).Error; err != nil {
+		return hasilKuis, fmt.Errorf("result not found")
+	}
+
+	if err := db.Where("users_id = ? AND kuis_id = ?", userID, kuisID).Order("attempt_number").Find(&hasilKuis.Attempts).Error; err != nil {
+		return hasilKuis, fmt.Errorf("failed to fetch attempts: %w", err)
+	}
+
+	return hasilKuis, nil
+}
+
+// backfillLegacyAttempts turns every Hasil_Kuis saved before attempts existed into a finished attempt #1
+// with its original score and answers, so a retake adds to the history instead of replacing the old score
+func backfillLegacyAttempts(db *gorm.DB) error {
+	var legacy []models.Hasil_Kuis
+	if err := db.Where("(users_id, kuis_id) NOT IN (?)",
+		db.Unscoped().Model(&models.KuisAttempt{}).Select("users_id, kuis_id")).
+		Find(&legacy).Error; err != nil {
+		return fmt.Errorf("failed to find results without attempts: %w", err)
+	}
+
+	for _, result := range legacy {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var kuis models.Kuis
+			if err := tx.Unscoped().First(&kuis, result.Kuis_id).Error; err != nil {
+				return nil // kuis sudah dihapus permanen, tidak ada yang bisa dipulihkan
+			}
+
+			// Hasil lama hanya menyimpan skor (persentase) dan jumlah jawaban benar, setiap soal bernilai 1
+			if result.MaxPoints == 0 && result.Percentage == 0 {
+				var soalCount int64
+				if err := tx.Model(&models.Soal{}).Where("kuis_id = ?", kuis.ID).Count(&soalCount).Error; err != nil {
+					return fmt.Errorf("failed to count questions: %w", err)
+				}
+				result.RawPoints = float64(result.Correct_Answer)
+				result.MaxPoints = float64(soalCount)
+				result.Percentage = float64(result.Score)
+			}
+			if result.ReviewStatus == "" {
+				result.ReviewStatus = models.ReviewFinal
+			}
+
+			finishedAt := result.UpdatedAt
+			attempt := models.KuisAttempt{
+				Users_id:       result.Users_id,
+				Kuis_id:        result.Kuis_id,
+				AttemptNumber:  1,
+				Status:         models.AttemptFinished,
+				StartedAt:      result.CreatedAt,
+				FinishedAt:     &finishedAt,
+				Score:          result.Score,
+				Correct_Answer: result.Correct_Answer,
+				RawPoints:      result.RawPoints,
+				MaxPoints:      result.MaxPoints,
+				Percentage:     result.Percentage,
+				ReviewStatus:   result.ReviewStatus,
+				Passed:         passedFor(kuis.KuisSettings, result.Percentage),
+			}
+			if err := tx.Create(&attempt).Error; err != nil {
+				return fmt.Errorf("failed to create attempt: %w", err)
+			}
+
+			// Jawaban lama belum terhubung ke attempt mana pun
+			if err := tx.Model(&models.SoalAnswer{}).
+				Where("user_id = ? AND attempt_id IS NULL AND soal_id IN (?)", result.Users_id,
+					tx.Unscoped().Model(&models.Soal{}).Select("id").Where("kuis_id = ?", kuis.ID)).
+				Update("attempt_id", attempt.ID).Error; err != nil {
+				return fmt.Errorf("failed to link answers: %w", err)
+			}
+
+			if result.ScoringPolicy == "" {
+				result.ScoringPolicy = kuis.ScoringPolicy
+			}
+			result.Attempt_id = &attempt.ID
+			result.AttemptCount = 1
+			result.Passed = attempt.Passed
+			if err := tx.Save(&result).Error; err != nil {
+				return fmt.Errorf("failed to update result: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to backfill attempt for result %d: %w", result.ID, err)
+		}
+	}
+
+	return nil
+}
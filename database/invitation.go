@@ -0,0 +1,215 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the invitation and role functions
+var (
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrInvitationInvalid  = errors.New("invitation is invalid, used or has expired")
+	ErrInvitationEmail    = errors.New("invitation was issued for a different email")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrLastAdmin          = errors.New("cannot remove the last admin")
+	ErrRoleUnchanged      = errors.New("user already has this role")
+	ErrRoleNotGrantable   = errors.New("you cannot assign a role with permissions you do not have yourself")
+)
+
+// CreateInvitation issues an invitation for an account with any role other than student and returns its token.
+// An empty email lets anyone with the token register.
+func CreateInvitation(role string, email string, ttl time.Duration, createdBy uint) (models.Invitation, string, error) {
+	var invitation models.Invitation
+
+	if role == models.RoleStudent || !ValidRole(role) {
+		return invitation, "", fmt.Errorf("%w: invitations are for existing roles other than student", ErrInvalidRole)
+	}
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return invitation, "", err
+	}
+
+	// An invitation hands out its role, so the creator must hold every permission of it
+	if err := checkGrantable(db, createdBy, role); err != nil {
+		return invitation, "", err
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return invitation, "", err
+	}
+
+	invitation = models.Invitation{
+		TokenHash: hashToken(token),
+		Role:      role,
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedBy: createdBy,
+	}
+	if err := db.Create(&invitation).Error; err != nil {
+		return invitation, "", fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	return invitation, token, nil
+}
+
+// GetInvitations lists invitations, newest first
+func GetInvitations() ([]models.Invitation, error) {
+	var invitations []models.Invitation
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return invitations, err
+	}
+
+	if err := db.Order("created_at DESC").Find(&invitations).Error; err != nil {
+		return invitations, fmt.Errorf("failed to retrieve invitations: %w", err)
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation stops an unused invitation from being used
+func RevokeInvitation(id uint) (models.Invitation, error) {
+	var invitation models.Invitation
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return invitation, err
+	}
+
+	if err := db.First(&invitation, id).Error; err != nil {
+		return invitation, ErrInvitationNotFound
+	}
+	if invitation.UsedAt != nil || invitation.RevokedAt != nil {
+		return invitation, ErrInvitationInvalid
+	}
+
+	now := time.Now()
+	invitation.RevokedAt = &now
+	if err := db.Model(&invitation).Update("revoked_at", now).Error; err != nil {
+		return invitation, fmt.Errorf("failed to revoke invitation: %w", err)
+	}
+
+	return invitation, nil
+}
+
+// RegisterWithInvitation creates the user with the role of the invitation and uses up the invitation.
+// Users invited to a specific email are created verified, since the token was sent to that address.
+func RegisterWithInvitation(user *models.Users, token string) (models.Invitation, error) {
+	var invitation models.Invitation
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return invitation, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ?", hashToken(token)).First(&invitation).Error; err != nil {
+			return ErrInvitationInvalid
+		}
+		if invitation.Email != "" && !strings.EqualFold(invitation.Email, strings.TrimSpace(user.Email)) {
+			return ErrInvitationEmail
+		}
+
+		user.Role = invitation.Role
+		if invitation.Email != "" {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return fmt.Errorf("failed to register user: %w", err)
+		}
+
+		// The condition makes sure the invitation cannot be used twice
+		now := time.Now()
+		res := tx.Model(&models.Invitation{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?", invitation.ID, now).
+			Updates(map[string]interface{}{"used_at": now, "used_by": user.ID})
+		if res.Error != nil {
+			return fmt.Errorf("failed to use invitation: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrInvitationInvalid
+		}
+		invitation.UsedAt = &now
+		invitation.UsedBy = &user.ID
+		return nil
+	})
+
+	return invitation, err
+}
+
+// ChangeUserRole sets the role of a user and returns the user with the role it had before.
+// The user making the change must hold every permission of both the old and the new role.
+func ChangeUserRole(userID uint, role string, changedBy uint) (models.Users, string, error) {
+	var user models.Users
+	var previous string
+
+	if !ValidRole(role) {
+		return user, previous, ErrInvalidRole
+	}
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return user, previous, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, userID).Error; err != nil {
+			return ErrUserNotFound
+		}
+		if user.Role == role {
+			return ErrRoleUnchanged
+		}
+		if err := checkGrantable(tx, changedBy, user.Role, role); err != nil {
+			return err
+		}
+
+		// There must always be an admin left to manage the application
+		if err := ensureOtherAdmin(tx, user); err != nil {
+			return err
+		}
+
+		previous = user.Role
+		user.Role = role
+		if err := tx.Model(&user).Update("role", role).Error; err != nil {
+			return fmt.Errorf("failed to change role: %w", err)
+		}
+		return nil
+	})
+
+	return user, previous, err
+}
+
+// checkGrantable refuses roles whose permissions are not all held by the role of the granting user,
+// so managing users cannot be used to gain or hand out more access, e.g. the admin role
+func checkGrantable(tx *gorm.DB, granterID uint, roles ...string) error {
+	var granter models.Users
+	if err := tx.First(&granter, granterID).Error; err != nil {
+		return ErrRoleNotGrantable
+	}
+
+	for _, role := range roles {
+		within, err := RoleWithin(role, granter.Role)
+		if err != nil {
+			return err
+		}
+		if !within {
+			return fmt.Errorf("%w: %s", ErrRoleNotGrantable, role)
+		}
+	}
+	return nil
+}
@@ -1,14 +1,25 @@
 package database
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the kuis functions
+var (
+	ErrKuisNotFound        = errors.New("kuis not found")
+	ErrInvalidKuisSettings = errors.New("invalid kuis settings")
 )
 
 // CreateKuis creates a new Kuis in the database
-func CreateKuis(title string, description string, isPrivate bool, kategori uint, tingkatan uint, kelas uint, pendidikan uint, createdBy uint) (models.Kuis, error) {
+func CreateKuis(title string, description string, isPrivate bool, kategori uint, tingkatan uint, kelas uint, pendidikan uint, createdBy uint, settings models.KuisSettings) (models.Kuis, error) {
 	var newKuis = models.Kuis{
+		KuisSettings:  settings,
 		Title:         title,
 		Description:   description,
 		IsPrivate:     isPrivate,
@@ -17,6 +28,7 @@ func CreateKuis(title string, description string, isPrivate bool, kategori uint,
 		Kelas_id:      kelas,
 		Pendidikan_id: pendidikan,
 		CreatedBy:     createdBy,
+		Status:        models.KuisDraft, // siswa baru bisa melihat kuis setelah dipublikasikan
 	}
 
 	// Get DB connection
@@ -25,6 +37,10 @@ func CreateKuis(title string, description string, isPrivate bool, kategori uint,
 		return newKuis, err
 	}
 
+	if err := validateKuisSettings(&newKuis.KuisSettings); err != nil {
+		return newKuis, err
+	}
+
 	// Validate Kategori, Tingkatan, and Kelas
 	var kategoriObj models.Kategori_Soal
 	if err := db.First(&kategoriObj, kategori).Error; err != nil {
@@ -72,8 +88,21 @@ func GetKuis() ([]models.Kuis, error) {
 	return kuisList, nil
 }
 
+// KuisFilter narrows FilterKuisForUser down to one kategori, tingkatan or pendidikan; zero means any
+type KuisFilter struct {
+	Kategori_id   uint
+	Tingkatan_id  uint
+	Pendidikan_id uint
+}
+
 // GetKuisForUser retrieves kuis that user can access (public + private from joined classes)
 func GetKuisForUser(userID uint) ([]models.Kuis, error) {
+	return FilterKuisForUser(userID, KuisFilter{})
+}
+
+// FilterKuisForUser retrieves the kuis matching the filter that the user can access, with the same
+// rules as GetKuisForUser: published only, private kuis for class members, and open already
+func FilterKuisForUser(userID uint, filter KuisFilter) ([]models.Kuis, error) {
 	var kuisList []models.Kuis
 
 	// Get DB connection
@@ -107,10 +136,41 @@ func GetKuisForUser(userID uint) ([]models.Kuis, error) {
 		query = query.Where("is_private = ?", false)
 	}
 
+	if filter.Kategori_id != 0 {
+		query = query.Where("kategori_id = ?", filter.Kategori_id)
+	}
+	if filter.Tingkatan_id != 0 {
+		query = query.Where("tingkatan_id = ?", filter.Tingkatan_id)
+	}
+	if filter.Pendidikan_id != 0 {
+		query = query.Where("pendidikan_id = ?", filter.Pendidikan_id)
+	}
+
+	// Kuis yang belum dipublikasikan belum ditampilkan
+	now := time.Now()
+	query = query.Where("status = ?", models.KuisPublished)
+
 	if err := query.Find(&kuisList).Error; err != nil {
 		return kuisList, fmt.Errorf("failed to retrieve accessible kuis: %w", err)
 	}
 
+	if err := applyPublishedDetails(db, kuisList); err != nil {
+		return kuisList, err
+	}
+
+	// Jadwal yang berlaku adalah jadwal versi yang dipublikasikan, jadi kuis yang belum dibuka disaring di sini
+	open := kuisList[:0]
+	for _, kuis := range kuisList {
+		if kuis.OpensAt == nil || !now.Before(*kuis.OpensAt) {
+			open = append(open, kuis)
+		}
+	}
+	kuisList = open
+
+	if err := attachAvailability(db, userID, kuisList, now); err != nil {
+		return kuisList, err
+	}
+
 	return kuisList, nil
 }
 
@@ -137,9 +197,167 @@ func UpdateKuis(title string, description string, isPrivate bool, kategori uint,
 		return updatedKuis, fmt.Errorf("failed to update kuis: %w", err)
 	}
 
+	// A new title or description only reaches students when the kuis is published again
+	if title != "" || description != "" {
+		kuisID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return updatedKuis, ErrKuisNotFound
+		}
+		if err := markDraftChanged(db, uint(kuisID)); err != nil {
+			return updatedKuis, err
+		}
+	}
+
 	return updatedKuis, nil
 }
 
+// kuisSettingsColumns lists the columns written by UpdateKuisSettings
+var kuisSettingsColumns = []string{
+	"time_limit", "scoring_policy", "wrong_penalty", "blank_penalty",
+	"shuffle_questions", "shuffle_options", "pool_size", "stratify_by_tingkatan",
+	"opens_at", "closes_at", "max_attempts", "cooldown_minutes", "pass_mark",
+	"late_submission", "late_grace_minutes", "late_penalty",
+}
+
+// validateKuisSettings checks the settings and fills in defaults
+func validateKuisSettings(settings *models.KuisSettings) error {
+	switch settings.ScoringPolicy {
+	case "":
+		settings.ScoringPolicy = models.ScoringLatest
+	case models.ScoringBest, models.ScoringLatest, models.ScoringFirst, models.ScoringAverage:
+	default:
+		return fmt.Errorf("%w: scoring_policy must be one of best, latest, first, average", ErrInvalidKuisSettings)
+	}
+
+	if settings.WrongPenalty < 0 || settings.WrongPenalty > 1 || settings.BlankPenalty < 0 || settings.BlankPenalty > 1 {
+		return fmt.Errorf("%w: wrong_penalty and blank_penalty must be between 0 and 1", ErrInvalidKuisSettings)
+	}
+
+	if settings.StratifyByTingkatan && settings.PoolSize == 0 {
+		return fmt.Errorf("%w: stratify_by_tingkatan requires a pool_size", ErrInvalidKuisSettings)
+	}
+
+	if settings.OpensAt != nil && settings.ClosesAt != nil && !settings.ClosesAt.After(*settings.OpensAt) {
+		return fmt.Errorf("%w: closes_at must be after opens_at", ErrInvalidKuisSettings)
+	}
+
+	if settings.PassMark < 0 || settings.PassMark > 100 {
+		return fmt.Errorf("%w: pass_mark must be between 0 and 100", ErrInvalidKuisSettings)
+	}
+
+	switch settings.LateSubmission {
+	case "":
+		settings.LateSubmission = models.LateReject
+	case models.LateReject, models.LateAccept, models.LatePenalize:
+	default:
+		return fmt.Errorf("%w: late_submission must be one of reject, accept, penalize", ErrInvalidKuisSettings)
+	}
+
+	if settings.LatePenalty < 0 || settings.LatePenalty > 1 {
+		return fmt.Errorf("%w: late_penalty must be between 0 and 1", ErrInvalidKuisSettings)
+	}
+
+	return nil
+}
+
+// UpdateKuisSettings replaces the settings of a Kuis, including zero values. Like other edits the
+// settings only change the draft: students and grading keep the settings of the published version
+// until the kuis is published again.
+func UpdateKuisSettings(id string, settings models.KuisSettings) (models.Kuis, error) {
+	var kuis models.Kuis
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return kuis, err
+	}
+
+	if err := db.First(&kuis, "id = ?", id).Error; err != nil {
+		return kuis, ErrKuisNotFound
+	}
+
+	if err := validateKuisSettings(&settings); err != nil {
+		return kuis, err
+	}
+
+	// Select is required so that zero values (e.g. removing a time limit) are saved
+	kuis.KuisSettings = settings
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&kuis).Select(kuisSettingsColumns).Updates(&kuis).Error; err != nil {
+			return fmt.Errorf("failed to update kuis settings: %w", err)
+		}
+		return markDraftChanged(tx, kuis.ID)
+	})
+	if err != nil {
+		return kuis, err
+	}
+
+	if kuis.Status == models.KuisPublished {
+		kuis.DraftChanges = true
+	}
+	return kuis, nil
+}
+
+// GetKuisByID retrieves a single Kuis by its ID
+func GetKuisByID(id uint) (models.Kuis, error) {
+	var kuis models.Kuis
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return kuis, err
+	}
+
+	if err := db.First(&kuis, id).Error; err != nil {
+		return kuis, ErrKuisNotFound
+	}
+
+	return kuis, nil
+}
+
+// CanUserAccessKuis applies the same rules as GetKuisForUser to a single kuis: drafts and kuis
+// that have not opened yet are hidden, public kuis are open to everyone, private kuis only to members of its class
+func CanUserAccessKuis(userID uint, kuis models.Kuis) (bool, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return false, err
+	}
+
+	// The opening time is the one of the published version
+	if kuis, err = publishedKuis(db, kuis); err != nil {
+		return false, err
+	}
+	if kuis.OpensAt != nil && time.Now().Before(*kuis.OpensAt) {
+		return false, nil
+	}
+	return canUserSeeKuis(userID, kuis)
+}
+
+// canUserSeeKuis is CanUserAccessKuis without the opening time, for callers that report
+// a kuis that is not open yet with its own error
+func canUserSeeKuis(userID uint, kuis models.Kuis) (bool, error) {
+	if kuis.Status == models.KuisDraft {
+		return false, nil
+	}
+	if !kuis.IsPrivate {
+		return true, nil
+	}
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	if err := db.Model(&models.Kelas_Pengguna{}).Where("users_id = ? AND kelas_id = ?", userID, kuis.Kelas_id).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check class membership: %w", err)
+	}
+
+	return count > 0, nil
+}
+
 // DeleteKuis deletes a Kuis by its ID
 func DeleteKuis(id string) error {
 	var kuis models.Kuis
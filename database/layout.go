@@ -0,0 +1,299 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// shuffleOptionTypes are the soal types whose options are a flat {"key": "text"} object
+// that can be relabeled without changing the question
+var shuffleOptionTypes = map[string]bool{
+	models.SoalSingleChoice:   true,
+	models.SoalMultipleSelect: true,
+	models.SoalOrdering:       true,
+}
+
+// buildAttemptLayout selects, orders and relabels the soal of a kuis for one attempt
+func buildAttemptLayout(settings models.KuisSettings, soalList []models.Soal) []models.AttemptSoalLayout {
+	// Sorting and shuffling happen on a copy so the caller's soal list keeps its order
+	selected := append([]models.Soal(nil), soalList...)
+	if settings.PoolSize > 0 && int(settings.PoolSize) < len(soalList) {
+		if settings.StratifyByTingkatan {
+			selected = drawStratified(soalList, int(settings.PoolSize))
+		} else {
+			selected = drawRandom(soalList, int(settings.PoolSize))
+		}
+	}
+
+	// Without shuffling the questions keep the order they were written in
+	if settings.ShuffleQuestions {
+		rand.Shuffle(len(selected), func(i, j int) { selected[i], selected[j] = selected[j], selected[i] })
+	} else {
+		sort.Slice(selected, func(i, j int) bool { return selected[i].ID < selected[j].ID })
+	}
+
+	layout := make([]models.AttemptSoalLayout, 0, len(selected))
+	for _, soal := range selected {
+		item := models.AttemptSoalLayout{Soal_id: soal.ID}
+		if settings.ShuffleOptions && shuffleOptionTypes[soal.QuestionType()] {
+			item.OptionMap = shuffledOptionMap(soal)
+		}
+		layout = append(layout, item)
+	}
+
+	return layout
+}
+
+// drawRandom picks n soal at random
+func drawRandom(soalList []models.Soal, n int) []models.Soal {
+	pool := append([]models.Soal(nil), soalList...)
+	rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+	return pool[:n]
+}
+
+// drawStratified picks n soal so that every tingkatan keeps its share of the pool,
+// distributing the remaining places by largest remainder
+func drawStratified(soalList []models.Soal, n int) []models.Soal {
+	groups := make(map[uint][]models.Soal)
+	var keys []uint
+	for _, soal := range soalList {
+		var key uint
+		if soal.Tingkatan_id != nil {
+			key = *soal.Tingkatan_id
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], soal)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	quota := make(map[uint]int, len(keys))
+	remainders := make([]struct {
+		key       uint
+		remainder float64
+	}, 0, len(keys))
+	assigned := 0
+	for _, key := range keys {
+		exact := float64(n) * float64(len(groups[key])) / float64(len(soalList))
+		quota[key] = int(exact)
+		assigned += quota[key]
+		remainders = append(remainders, struct {
+			key       uint
+			remainder float64
+		}{key, exact - float64(quota[key])})
+	}
+	// Ties are broken randomly so no tingkatan is always favoured
+	rand.Shuffle(len(remainders), func(i, j int) { remainders[i], remainders[j] = remainders[j], remainders[i] })
+	sort.SliceStable(remainders, func(i, j int) bool { return remainders[i].remainder > remainders[j].remainder })
+	for i := 0; assigned < n && i < len(remainders); i++ {
+		quota[remainders[i].key]++
+		assigned++
+	}
+
+	selected := make([]models.Soal, 0, n)
+	for _, key := range keys {
+		selected = append(selected, drawRandom(groups[key], quota[key])...)
+	}
+	return selected
+}
+
+// shuffledOptionMap assigns the option labels of a soal to its options in random order
+func shuffledOptionMap(soal models.Soal) map[string]string {
+	var options map[string]json.RawMessage
+	if err := json.Unmarshal(soal.Options, &options); err != nil || len(options) < 2 {
+		return nil
+	}
+
+	labels := make([]string, 0, len(options))
+	for key := range options {
+		labels = append(labels, key)
+	}
+	sort.Strings(labels)
+
+	originals := append([]string(nil), labels...)
+	rand.Shuffle(len(originals), func(i, j int) { originals[i], originals[j] = originals[j], originals[i] })
+
+	optionMap := make(map[string]string, len(labels))
+	for i, label := range labels {
+		optionMap[label] = originals[i]
+	}
+	return optionMap
+}
+
+// decodeLayout reads the layout stored on an attempt; attempts without layout use every soal of the kuis
+func decodeLayout(attempt models.KuisAttempt) ([]models.AttemptSoalLayout, error) {
+	if len(attempt.Layout) == 0 {
+		return nil, nil
+	}
+	var layout []models.AttemptSoalLayout
+	if err := json.Unmarshal(attempt.Layout, &layout); err != nil {
+		return nil, fmt.Errorf("failed to read attempt layout: %w", err)
+	}
+	return layout, nil
+}
+
+// attemptSoal returns the soal that belong to an attempt, in the attempt's order.
+// Attempts taken against a published version use the soal of that version, with answer key corrections.
+func attemptSoal(tx *gorm.DB, attempt models.KuisAttempt) ([]models.Soal, error) {
+	var soalList []models.Soal
+	if attempt.KuisVersion_id != nil {
+		var version models.KuisVersion
+		if err := tx.First(&version, *attempt.KuisVersion_id).Error; err != nil {
+			return soalList, fmt.Errorf("failed to retrieve kuis version: %w", err)
+		}
+		var err error
+		if soalList, err = gradingSoal(tx, version); err != nil {
+			return soalList, err
+		}
+	} else if err := tx.Where("kuis_id = ?", attempt.Kuis_id).Order("id").Find(&soalList).Error; err != nil {
+		return soalList, fmt.Errorf("failed to fetch related questions: %w", err)
+	}
+
+	layout, err := decodeLayout(attempt)
+	if err != nil || layout == nil {
+		return soalList, err
+	}
+
+	soalByID := make(map[uint]models.Soal, len(soalList))
+	for _, soal := range soalList {
+		soalByID[soal.ID] = soal
+	}
+	ordered := make([]models.Soal, 0, len(layout))
+	for _, item := range layout {
+		if soal, ok := soalByID[item.Soal_id]; ok {
+			ordered = append(ordered, soal)
+		}
+	}
+	return ordered, nil
+}
+
+// findSoal returns the soal with the given ID from a list
+func findSoal(soalList []models.Soal, soalID uint) (models.Soal, bool) {
+	for _, soal := range soalList {
+		if soal.ID == soalID {
+			return soal, true
+		}
+	}
+	return models.Soal{}, false
+}
+
+// layoutFor returns the layout entry of a soal, and false if the soal is not part of the attempt
+func layoutFor(layout []models.AttemptSoalLayout, soalID uint) (models.AttemptSoalLayout, bool) {
+	if layout == nil {
+		return models.AttemptSoalLayout{Soal_id: soalID}, true
+	}
+	for _, item := range layout {
+		if item.Soal_id == soalID {
+			return item, true
+		}
+	}
+	return models.AttemptSoalLayout{}, false
+}
+
+// relabelOptions rewrites the options object of a soal with the labels shown in the attempt
+func relabelOptions(soal models.Soal, optionMap map[string]string) json.RawMessage {
+	if len(optionMap) == 0 {
+		return soal.Options
+	}
+	var options map[string]json.RawMessage
+	if err := json.Unmarshal(soal.Options, &options); err != nil {
+		return soal.Options
+	}
+	relabeled := make(map[string]json.RawMessage, len(options))
+	for label, original := range optionMap {
+		relabeled[label] = options[original]
+	}
+	encoded, err := json.Marshal(relabeled)
+	if err != nil {
+		return soal.Options
+	}
+	return encoded
+}
+
+// mapAnswerLabels translates a stored answer between shown labels and original option keys
+func mapAnswerLabels(soal models.Soal, answer string, optionMap map[string]string) string {
+	if len(optionMap) == 0 || isBlankAnswer(answer) {
+		return answer
+	}
+
+	switch soal.QuestionType() {
+	case models.SoalSingleChoice:
+		if mapped, ok := optionMap[answer]; ok {
+			return mapped
+		}
+		return answer
+	case models.SoalMultipleSelect, models.SoalOrdering:
+		values := decodeList(answer)
+		for i, value := range values {
+			if mapped, ok := optionMap[value]; ok {
+				values[i] = mapped
+			}
+		}
+		if soal.QuestionType() == models.SoalMultipleSelect {
+			values = uniqueSorted(values)
+		}
+		encoded, err := encodeList(values)
+		if err != nil {
+			return answer
+		}
+		return encoded
+	}
+	return answer
+}
+
+// invertOptionMap turns a label -> original map into original -> label
+func invertOptionMap(optionMap map[string]string) map[string]string {
+	inverted := make(map[string]string, len(optionMap))
+	for label, original := range optionMap {
+		inverted[original] = label
+	}
+	return inverted
+}
+
+// GetAttemptSoal returns the soal of an attempt as shown to the student: in the attempt's order,
+// with shuffled option labels, without answer key, and with the answers saved so far
+func GetAttemptSoal(attempt models.KuisAttempt) ([]models.SoalDelivery, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	soalList, err := attemptSoal(db, attempt)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := decodeLayout(attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	answers, err := GetAttemptAnswers(attempt.ID)
+	if err != nil {
+		return nil, err
+	}
+	answerBySoal := make(map[uint]string, len(answers))
+	for _, answer := range answers {
+		answerBySoal[answer.Soal_id] = answer.Answer
+	}
+
+	delivery := make([]models.SoalDelivery, 0, len(soalList))
+	for _, soal := range soalList {
+		item, _ := layoutFor(layout, soal.ID)
+		view := soal.Delivery()
+		view.Options = relabelOptions(soal, item.OptionMap)
+		if answer, ok := answerBySoal[soal.ID]; ok {
+			shown := mapAnswerLabels(soal, answer, invertOptionMap(item.OptionMap))
+			view.SavedAnswer = &shown
+		}
+		delivery = append(delivery, view)
+	}
+
+	return delivery, nil
+}
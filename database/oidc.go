@@ -0,0 +1,192 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Errors returned by the OIDC login functions
+var (
+	ErrOIDCStateInvalid       = errors.New("login state is invalid or has expired")
+	ErrOIDCEmailUnverified    = errors.New("the identity provider has not verified this email address")
+	ErrOIDCAccountUnavailable = errors.New("the account linked to this identity is not available")
+	ErrOIDCAccountLocked      = errors.New("the account linked to this identity is locked")
+)
+
+// OIDCIdentity is what the identity provider tells about a user after a verified login
+type OIDCIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Role          string // role from the provider's role mapping, empty when no rule matched
+}
+
+// OIDCLinkResult describes what LinkOIDCIdentity did
+type OIDCLinkResult struct {
+	User         models.Users
+	Created      bool   // a new student account was created
+	Linked       bool   // the identity was linked to an existing account by email
+	PreviousRole string // set when the role mapping changed the role of the user
+}
+
+// CreateOIDCLoginState stores the secrets of a login that is waiting for the provider to redirect back
+// and returns the state value to send to the provider
+func CreateOIDCLoginState(provider string, nonce string, verifier string, ttl time.Duration) (string, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return "", err
+	}
+
+	state, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	login := models.OIDCLoginState{
+		StateHash:    hashToken(state),
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(ttl),
+	}
+	if err := db.Create(&login).Error; err != nil {
+		return "", fmt.Errorf("failed to create login state: %w", err)
+	}
+
+	return state, nil
+}
+
+// UseOIDCLoginState returns the login belonging to a state and marks it as used, so a callback
+// cannot be replayed
+func UseOIDCLoginState(state string) (models.OIDCLoginState, error) {
+	var login models.OIDCLoginState
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return login, err
+	}
+
+	if err := db.Where("state_hash = ?", hashToken(state)).First(&login).Error; err != nil {
+		return login, ErrOIDCStateInvalid
+	}
+	if login.UsedAt != nil || !time.Now().Before(login.ExpiresAt) {
+		return login, ErrOIDCStateInvalid
+	}
+
+	res := db.Model(&models.OIDCLoginState{}).Where("id = ? AND used_at IS NULL", login.ID).Update("used_at", time.Now())
+	if res.Error != nil {
+		return login, fmt.Errorf("failed to use login state: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return login, ErrOIDCStateInvalid
+	}
+
+	return login, nil
+}
+
+// LinkOIDCIdentity finds the user of a verified identity. Unknown identities are linked to the account
+// with the same verified email, or a new student account is created for them. A role from the
+// provider's role mapping replaces the role of the user. Locked accounts are left untouched and
+// return ErrOIDCAccountLocked with the user in the result.
+func LinkOIDCIdentity(identity OIDCIdentity) (OIDCLinkResult, error) {
+	var result OIDCLinkResult
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return result, err
+	}
+
+	if identity.Role != "" && !ValidRole(identity.Role) {
+		return result, ErrInvalidRole
+	}
+	email := strings.ToLower(strings.TrimSpace(identity.Email))
+	now := time.Now()
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var link models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error
+		switch {
+		case err == nil:
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&result.User, link.Users_id).Error; err != nil {
+				return ErrOIDCAccountUnavailable
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// Akun hanya boleh dihubungkan lewat email yang sudah diverifikasi provider
+			if email == "" || !identity.EmailVerified {
+				return ErrOIDCEmailUnverified
+			}
+
+			var user models.Users
+			err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Where("LOWER(email) = ?", email).First(&user).Error
+			switch {
+			case err == nil && user.DeletedAt.Valid:
+				return ErrOIDCAccountUnavailable
+			case err == nil:
+				result.Linked = true
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				// Akun baru tanpa password; user login lewat provider
+				user = models.Users{Name: identity.Name, Email: email, Role: models.RoleStudent}
+				if user.Name == "" {
+					user.Name = email
+				}
+				if err := tx.Create(&user).Error; err != nil {
+					return fmt.Errorf("failed to create user: %w", err)
+				}
+				result.Created = true
+			default:
+				return fmt.Errorf("failed to find user: %w", err)
+			}
+			result.User = user
+
+			link = models.UserIdentity{Users_id: user.ID, Provider: identity.Provider, Subject: identity.Subject}
+		default:
+			return fmt.Errorf("failed to find identity: %w", err)
+		}
+
+		// Akun yang dikunci (juga oleh admin, yang selalu mengisi locked_until) tidak dihubungkan,
+		// dan role serta status emailnya tidak diubah
+		if result.User.LockedUntil != nil && now.Before(*result.User.LockedUntil) {
+			return ErrOIDCAccountLocked
+		}
+
+		link.Email = email
+		link.LastLoginAt = &now
+		if err := tx.Save(&link).Error; err != nil {
+			return fmt.Errorf("failed to save identity: %w", err)
+		}
+
+		// Email yang diverifikasi provider dianggap terverifikasi
+		if result.User.EmailVerifiedAt == nil && identity.EmailVerified && email == strings.ToLower(result.User.Email) {
+			result.User.EmailVerifiedAt = &now
+			if err := tx.Model(&result.User).Update("email_verified_at", now).Error; err != nil {
+				return fmt.Errorf("failed to verify email: %w", err)
+			}
+		}
+
+		if identity.Role != "" && identity.Role != result.User.Role {
+			// There must always be an admin left to manage the application
+			if err := ensureOtherAdmin(tx, result.User); err != nil {
+				return err
+			}
+			result.PreviousRole = result.User.Role
+			result.User.Role = identity.Role
+			if err := tx.Model(&result.User).Update("role", identity.Role).Error; err != nil {
+				return fmt.Errorf("failed to change role: %w", err)
+			}
+		}
+		return nil
+	})
+
+	return result, err
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the ownership functions
+var (
+	ErrKelasNotFound       = errors.New("kelas not found")
+	ErrNotTeacher          = errors.New("only users whose role may update classes can be co-teachers")
+	ErrAlreadyTeacher      = errors.New("user already teaches this kelas")
+	ErrCoTeacherNotFound   = errors.New("user is not a co-teacher of this kelas")
+	ErrCannotRemoveCreator = errors.New("the creator of a kelas cannot be removed from it")
+)
+
+// taughtKelas returns a subquery with the IDs of the kelas a user created or co-teaches
+func taughtKelas(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&models.Kelas{}).Select("id").
+		Where("created_by = ? OR id IN (?)", userID,
+			db.Model(&models.KelasTeacher{}).Select("kelas_id").Where("users_id = ?", userID))
+}
+
+// taughtKuis returns a subquery with the IDs of the kuis in the kelas a user created or co-teaches
+func taughtKuis(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&models.Kuis{}).Select("id").Where("kelas_id IN (?)", taughtKelas(db, userID))
+}
+
+// authoredKuis returns a subquery with the IDs of the kuis a user may modify:
+// the kuis they created and every kuis in a kelas they teach
+func authoredKuis(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&models.Kuis{}).Select("id").
+		Where("created_by = ? OR kelas_id IN (?)", userID, taughtKelas(db, userID))
+}
+
+// GetKelasByID retrieves a single Kelas by its ID
+func GetKelasByID(id uint) (models.Kelas, error) {
+	var kelas models.Kelas
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return kelas, err
+	}
+
+	if err := db.First(&kelas, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return kelas, ErrKelasNotFound
+		}
+		return kelas, fmt.Errorf("failed to retrieve kelas: %w", err)
+	}
+
+	return kelas, nil
+}
+
+// TeachesKelas reports whether the user created or co-teaches the kelas
+func TeachesKelas(userID uint, kelasID uint) (bool, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	if err := taughtKelas(db, userID).Where("id = ?", kelasID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check kelas teachers: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// GetKelasTeachers lists the co-teachers of a kelas
+func GetKelasTeachers(kelasID uint) ([]models.KelasTeacher, error) {
+	var teachers []models.KelasTeacher
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return teachers, err
+	}
+
+	if err := db.Preload("Users").Where("kelas_id = ?", kelasID).Order("id").Find(&teachers).Error; err != nil {
+		return teachers, fmt.Errorf("failed to retrieve kelas teachers: %w", err)
+	}
+
+	return teachers, nil
+}
+
+// AddKelasTeacher makes a teacher a co-teacher of a kelas
+func AddKelasTeacher(kelas models.Kelas, userID uint, addedBy uint) (models.KelasTeacher, error) {
+	teacher := models.KelasTeacher{Kelas_id: kelas.ID, Users_id: userID, AddedBy: addedBy}
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return teacher, err
+	}
+
+	var user models.Users
+	if err := db.First(&user, userID).Error; err != nil {
+		return teacher, ErrUserNotFound
+	}
+	canTeach, err := RoleHasPermission(user.Role, models.PermKelasUpdate)
+	if err != nil {
+		return teacher, err
+	}
+	if !canTeach {
+		return teacher, ErrNotTeacher
+	}
+	if kelas.CreatedBy == userID {
+		return teacher, ErrAlreadyTeacher
+	}
+
+	var count int64
+	if err := db.Model(&models.KelasTeacher{}).Where("kelas_id = ? AND users_id = ?", kelas.ID, userID).Count(&count).Error; err != nil {
+		return teacher, fmt.Errorf("failed to check kelas teachers: %w", err)
+	}
+	if count > 0 {
+		return teacher, ErrAlreadyTeacher
+	}
+
+	if err := db.Create(&teacher).Error; err != nil {
+		return teacher, fmt.Errorf("failed to add kelas teacher: %w", err)
+	}
+	teacher.Users = user
+
+	return teacher, nil
+}
+
+// RemoveKelasTeacher removes a co-teacher from a kelas
+func RemoveKelasTeacher(kelas models.Kelas, userID uint) error {
+	if kelas.CreatedBy == userID {
+		return ErrCannotRemoveCreator
+	}
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return err
+	}
+
+	// Hapus permanen supaya guru yang sama bisa ditambahkan lagi
+	res := db.Unscoped().Where("kelas_id = ? AND users_id = ?", kelas.ID, userID).Delete(&models.KelasTeacher{})
+	if res.Error != nil {
+		return fmt.Errorf("failed to remove kelas teacher: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrCoTeacherNotFound
+	}
+
+	return nil
+}
+
+// TeachesKuis reports whether the kuis belongs to a kelas the user created or co-teaches
+func TeachesKuis(userID uint, kuisID uint) (bool, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	if err := taughtKuis(db, userID).Where("id = ?", kuisID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check kuis teachers: %w", err)
+	}
+
+	return count > 0, nil
+}
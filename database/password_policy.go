@@ -0,0 +1,31 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Joko206/UAS_PWEB1/passwordpolicy"
+)
+
+// ErrInvalidPolicy is returned when a saved password policy has impossible values
+var ErrInvalidPolicy = errors.New("invalid password policy")
+
+// settingPasswordPolicy is the Setting key holding the password policy saved by an admin
+const settingPasswordPolicy = "password_policy"
+
+// GetPasswordPolicy returns the policy from the environment, overridden by the fields an admin saved
+func GetPasswordPolicy() (passwordpolicy.Policy, error) {
+	policy := passwordpolicy.FromEnv()
+	if _, err := getSetting(settingPasswordPolicy, &policy); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+// SetPasswordPolicy saves the policy after checking it is usable
+func SetPasswordPolicy(policy passwordpolicy.Policy, updatedBy uint) error {
+	if err := policy.Check(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	return saveSetting(settingPasswordPolicy, policy, updatedBy)
+}
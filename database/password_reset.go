@@ -0,0 +1,102 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// ErrResetTokenInvalid is returned for unknown, used or expired reset tokens
+var ErrResetTokenInvalid = errors.New("reset token is invalid or has expired")
+
+// CreatePasswordResetToken issues a new reset token for the user; earlier unused tokens stop working
+func CreatePasswordResetToken(userID uint, ip string, ttl time.Duration) (string, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return "", err
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.PasswordResetToken{}).Where("users_id = ? AND used_at IS NULL", userID).
+			Update("used_at", now).Error; err != nil {
+			return fmt.Errorf("failed to invalidate old reset tokens: %w", err)
+		}
+
+		resetToken := models.PasswordResetToken{
+			Users_id:  userID,
+			TokenHash: hashToken(token),
+			ExpiresAt: now.Add(ttl),
+			RequestIP: ip,
+		}
+		if err := tx.Create(&resetToken).Error; err != nil {
+			return fmt.Errorf("failed to save reset token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ResetPassword uses a reset token to set a new password hash. The token can only be used once;
+// the account is unlocked and every session of the user is revoked.
+func ResetPassword(token string, passwordHash []byte) (models.Users, error) {
+	var user models.Users
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return user, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var resetToken models.PasswordResetToken
+		if err := tx.Where("token_hash = ?", hashToken(token)).First(&resetToken).Error; err != nil {
+			return ErrResetTokenInvalid
+		}
+
+		// The condition makes sure two requests cannot use the same token
+		now := time.Now()
+		res := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", resetToken.ID, now).
+			Update("used_at", now)
+		if res.Error != nil {
+			return fmt.Errorf("failed to use reset token: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrResetTokenInvalid
+		}
+
+		if err := tx.First(&user, resetToken.Users_id).Error; err != nil {
+			return ErrResetTokenInvalid
+		}
+
+		// Reset password only lifts a lock from failed logins; a lock by an admin stays
+		user.Password = passwordHash
+		user.FailedAttempts = 0
+		user.LockoutCount = 0
+		if !user.LockedByAdmin {
+			user.LockedUntil = nil
+		}
+		if err := tx.Model(&user).Select("password", "failed_attempts", "lockout_count", "locked_until").Updates(&user).Error; err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+
+		_, err := revokeAllSessions(tx, user.ID, RevokedPasswordReset, 0)
+		return err
+	})
+
+	return user, err
+}
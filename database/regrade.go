@@ -0,0 +1,318 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the regrade functions
+var (
+	ErrSoalNotFound     = errors.New("soal not found")
+	ErrSoalNotPublished = errors.New("soal is not part of the published version of its kuis")
+	ErrNoCorrection     = errors.New("no answer key correction given")
+)
+
+// AnswerKeyCorrection is a new answer key for a soal that is already published.
+// Fields that are left out keep their published value.
+type AnswerKeyCorrection struct {
+	Soal_id        uint            `json:"soal_id"`
+	Correct_answer *string         `json:"correct_answer"`
+	AnswerKey      json.RawMessage `json:"answer_key"`
+	Points         *float64        `json:"points"`
+	PartialCredit  *string         `json:"partial_credit"`
+}
+
+// Empty reports whether the correction changes nothing
+func (c AnswerKeyCorrection) Empty() bool {
+	return c.Correct_answer == nil && len(c.AnswerKey) == 0 && c.Points == nil && c.PartialCredit == nil
+}
+
+// apply returns the soal with the corrected answer key
+func (c AnswerKeyCorrection) apply(soal models.Soal) models.Soal {
+	if c.Correct_answer != nil {
+		soal.Correct_answer = *c.Correct_answer
+	}
+	if len(c.AnswerKey) > 0 {
+		soal.AnswerKey = c.AnswerKey
+	}
+	if c.Points != nil {
+		soal.Points = *c.Points
+	}
+	if c.PartialCredit != nil {
+		soal.PartialCredit = *c.PartialCredit
+	}
+	return soal
+}
+
+// RegradeChange is one attempt whose score changed during a regrade
+type RegradeChange struct {
+	Users_id      uint    `json:"users_id"`
+	Kuis_id       uint    `json:"kuis_id"`
+	Attempt_id    uint    `json:"attempt_id"`
+	OldScore      uint    `json:"old_score"`
+	NewScore      uint    `json:"new_score"`
+	OldPercentage float64 `json:"old_percentage"`
+	NewPercentage float64 `json:"new_percentage"`
+}
+
+// RegradeSummary describes the outcome of a regrade
+type RegradeSummary struct {
+	AttemptsRegraded int             `json:"attempts_regraded"`
+	Changes          []RegradeChange `json:"changes"`
+	AffectedStudents []uint          `json:"affected_students"`
+}
+
+// answerKeyChanged reports whether an update changes how answers to the soal are graded
+func answerKeyChanged(before models.Soal, after models.Soal) bool {
+	return before.Correct_answer != after.Correct_answer ||
+		!sameJSON(before.AnswerKey, after.AnswerKey) ||
+		before.PartialCredit != after.PartialCredit ||
+		SoalPoints(before) != SoalPoints(after)
+}
+
+// sameJSON compares two JSON documents ignoring whitespace
+func sameJSON(a json.RawMessage, b json.RawMessage) bool {
+	var left, right bytes.Buffer
+	if json.Compact(&left, a) != nil || json.Compact(&right, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(left.Bytes(), right.Bytes())
+}
+
+// gradingSoal decodes the soal of a version and applies the answer key corrections made since
+func gradingSoal(tx *gorm.DB, version models.KuisVersion) ([]models.Soal, error) {
+	soalList, err := versionSoal(version)
+	if err != nil || len(soalList) == 0 {
+		return soalList, err
+	}
+
+	soalIDs := make([]uint, 0, len(soalList))
+	for _, soal := range soalList {
+		soalIDs = append(soalIDs, soal.ID)
+	}
+
+	// Corrections made before the version was published are already part of its snapshot
+	var corrections []models.SoalCorrection
+	if err := tx.Where("soal_id IN ? AND created_at > ?", soalIDs, version.PublishedAt).Order("id").Find(&corrections).Error; err != nil {
+		return soalList, fmt.Errorf("failed to fetch answer key corrections: %w", err)
+	}
+
+	// Corrections are ordered oldest first, so the latest one wins
+	latest := make(map[uint]models.SoalCorrection, len(corrections))
+	for _, correction := range corrections {
+		latest[correction.Soal_id] = correction
+	}
+	for i := range soalList {
+		if correction, ok := latest[soalList[i].ID]; ok {
+			soalList[i].Correct_answer = correction.Correct_answer
+			soalList[i].AnswerKey = correction.AnswerKey
+			soalList[i].Points = correction.Points
+			soalList[i].PartialCredit = correction.PartialCredit
+		}
+	}
+
+	return soalList, nil
+}
+
+// publishedSoal returns a soal as students are graded on it: from the published version of
+// its kuis, with the corrections made since
+func publishedSoal(tx *gorm.DB, soalID uint) (models.Soal, error) {
+	var soal models.Soal
+	if err := tx.First(&soal, soalID).Error; err != nil {
+		return soal, ErrSoalNotFound
+	}
+
+	var kuis models.Kuis
+	if err := tx.First(&kuis, soal.Kuis_id).Error; err != nil {
+		return soal, ErrKuisNotFound
+	}
+	version, err := currentVersion(tx, kuis)
+	if errors.Is(err, ErrKuisNotPublished) {
+		return soal, ErrSoalNotPublished
+	}
+	if err != nil {
+		return soal, err
+	}
+
+	soalList, err := gradingSoal(tx, version)
+	if err != nil {
+		return soal, err
+	}
+	published, ok := findSoal(soalList, soalID)
+	if !ok {
+		return soal, ErrSoalNotPublished
+	}
+	return published, nil
+}
+
+// recordCorrection stores a corrected answer key for a published soal. Nothing is stored when
+// the key is the one students are already graded on; the result reports whether it was stored.
+func recordCorrection(tx *gorm.DB, correction AnswerKeyCorrection, correctedBy uint) (bool, error) {
+	published, err := publishedSoal(tx, correction.Soal_id)
+	if err != nil {
+		return false, err
+	}
+
+	corrected := correction.apply(published)
+	if err := ValidateSoal(corrected); err != nil {
+		return false, err
+	}
+	if !answerKeyChanged(published, corrected) {
+		return false, nil
+	}
+
+	row := models.SoalCorrection{
+		Soal_id:        corrected.ID,
+		Correct_answer: corrected.Correct_answer,
+		AnswerKey:      corrected.AnswerKey,
+		Points:         corrected.Points,
+		PartialCredit:  corrected.PartialCredit,
+		CorrectedBy:    correctedBy,
+	}
+	if err := tx.Create(&row).Error; err != nil {
+		return false, fmt.Errorf("failed to save answer key correction: %w", err)
+	}
+	return true, nil
+}
+
+// CountRegradeCandidates returns how many finished attempts answered the soal
+func CountRegradeCandidates(soalID uint) (int64, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := db.Model(&models.KuisAttempt{}).
+		Where("status <> ? AND id IN (?)", models.AttemptInProgress,
+			db.Model(&models.SoalAnswer{}).Select("attempt_id").Where("soal_id = ? AND attempt_id IS NOT NULL", soalID)).
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count attempts: %w", err)
+	}
+
+	return count, nil
+}
+
+// RegradeSoal records a corrected answer key for a published soal and regrades every finished
+// attempt that answered it. A correction equal to the published key changes nothing.
+func RegradeSoal(correction AnswerKeyCorrection, correctedBy uint) (RegradeSummary, error) {
+	summary := RegradeSummary{Changes: []RegradeChange{}, AffectedStudents: []uint{}}
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return summary, err
+	}
+
+	if correction.Empty() {
+		return summary, ErrNoCorrection
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		recorded, err := recordCorrection(tx, correction, correctedBy)
+		if err != nil || !recorded {
+			return err
+		}
+
+		var attempts []models.KuisAttempt
+		if err := tx.Where("status <> ? AND id IN (?)", models.AttemptInProgress,
+			tx.Model(&models.SoalAnswer{}).Select("attempt_id").Where("soal_id = ? AND attempt_id IS NOT NULL", correction.Soal_id)).
+			Find(&attempts).Error; err != nil {
+			return fmt.Errorf("failed to fetch attempts: %w", err)
+		}
+
+		summary, err = regradeWithSummary(tx, attempts)
+		return err
+	})
+
+	return summary, err
+}
+
+// RegradeKuis records corrected answer keys for published soal of a kuis and regrades all its
+// finished attempts. Soal without a correction, or with the key already published, are left alone.
+func RegradeKuis(kuisID uint, corrections []AnswerKeyCorrection, correctedBy uint) (RegradeSummary, error) {
+	summary := RegradeSummary{Changes: []RegradeChange{}, AffectedStudents: []uint{}}
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return summary, err
+	}
+
+	if len(corrections) == 0 {
+		return summary, ErrNoCorrection
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		changed := false
+		for _, correction := range corrections {
+			var soal models.Soal
+			if err := tx.First(&soal, correction.Soal_id).Error; err != nil {
+				return ErrSoalNotFound
+			}
+			if soal.Kuis_id != kuisID {
+				return ErrSoalNotInKuis
+			}
+
+			recorded, err := recordCorrection(tx, correction, correctedBy)
+			if err != nil {
+				return err
+			}
+			changed = changed || recorded
+		}
+		if !changed {
+			return nil
+		}
+
+		var attempts []models.KuisAttempt
+		if err := tx.Where("kuis_id = ? AND status <> ?", kuisID, models.AttemptInProgress).Find(&attempts).Error; err != nil {
+			return fmt.Errorf("failed to fetch attempts: %w", err)
+		}
+
+		summary, err = regradeWithSummary(tx, attempts)
+		return err
+	})
+
+	return summary, err
+}
+
+// regradeWithSummary regrades the attempts and collects the ones whose score changed
+func regradeWithSummary(tx *gorm.DB, attempts []models.KuisAttempt) (RegradeSummary, error) {
+	summary := RegradeSummary{Changes: []RegradeChange{}, AffectedStudents: []uint{}}
+
+	students := make(map[uint]bool)
+	for _, attempt := range attempts {
+		regraded, err := regradeAttempt(tx, attempt)
+		if err != nil {
+			return summary, err
+		}
+		summary.AttemptsRegraded++
+
+		if regraded.Percentage == attempt.Percentage && regraded.RawPoints == attempt.RawPoints {
+			continue
+		}
+		summary.Changes = append(summary.Changes, RegradeChange{
+			Users_id:      attempt.Users_id,
+			Kuis_id:       attempt.Kuis_id,
+			Attempt_id:    attempt.ID,
+			OldScore:      attempt.Score,
+			NewScore:      regraded.Score,
+			OldPercentage: attempt.Percentage,
+			NewPercentage: regraded.Percentage,
+		})
+		if !students[attempt.Users_id] {
+			students[attempt.Users_id] = true
+			summary.AffectedStudents = append(summary.AffectedStudents, attempt.Users_id)
+		}
+	}
+	sort.Slice(summary.AffectedStudents, func(i, j int) bool { return summary.AffectedStudents[i] < summary.AffectedStudents[j] })
+
+	return summary, nil
+}
@@ -0,0 +1,425 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the role functions
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrRoleExists        = errors.New("role already exists")
+	ErrRoleInUse         = errors.New("role is still assigned to users")
+	ErrSystemRole        = errors.New("system roles cannot be deleted")
+	ErrAdminRoleFixed    = errors.New("the admin role always has every permission")
+	ErrInvalidRoleName   = errors.New("role name must be 2-32 lowercase letters, digits, '-' or '_'")
+	ErrInvalidPermission = errors.New("invalid permission")
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,31}$`)
+
+// Mapping role -> permission disimpan di memori supaya setiap request tidak perlu query tambahan
+const permissionCacheTTL = time.Minute
+
+var (
+	permissionCache       map[string]map[string]bool
+	permissionCacheLoaded time.Time
+	permissionCacheMu     sync.RWMutex
+)
+
+// Permissions lists every permission with the description stored in the permissions table
+var Permissions = []models.Permission{
+	{Name: models.PermKategoriManage, Description: "Tambah, ubah dan hapus kategori soal"},
+	{Name: models.PermTingkatanManage, Description: "Tambah, ubah dan hapus tingkatan"},
+	{Name: models.PermPendidikanManage, Description: "Tambah, ubah dan hapus jenjang pendidikan"},
+	{Name: models.PermKelasCreate, Description: "Membuat kelas"},
+	{Name: models.PermKelasUpdate, Description: "Mengubah kelas yang dibuat atau diajar, dan mengelola co-teacher"},
+	{Name: models.PermKelasDelete, Description: "Menghapus kelas yang dibuat atau diajar"},
+	{Name: models.PermKelasEnroll, Description: "Mendaftarkan siswa ke kelas yang diajar tanpa join code"},
+	{Name: models.PermKuisCreate, Description: "Membuat kuis"},
+	{Name: models.PermKuisUpdate, Description: "Mengubah, mempublikasikan dan melihat versi kuis milik sendiri"},
+	{Name: models.PermKuisDelete, Description: "Menghapus kuis milik sendiri"},
+	{Name: models.PermKuisReadAll, Description: "Melihat semua kuis, termasuk draft dan kuis privat"},
+	{Name: models.PermSoalCreate, Description: "Menambah soal ke kuis milik sendiri"},
+	{Name: models.PermSoalUpdate, Description: "Mengubah soal di kuis milik sendiri"},
+	{Name: models.PermSoalDelete, Description: "Menghapus soal di kuis milik sendiri"},
+	{Name: models.PermContentManageAny, Description: "Mengubah kelas, kuis dan soal milik siapa pun (tetap butuh permission aksinya)"},
+	{Name: models.PermResultsRead, Description: "Melihat hasil kuis siswa di kelas yang diajar"},
+	{Name: models.PermResultsReadAny, Description: "Melihat hasil kuis semua user"},
+	{Name: models.PermGradingGrade, Description: "Menilai jawaban essay dan menilai ulang attempt"},
+	{Name: models.PermUsersManage, Description: "Mengelola user, role user, undangan, session dan 2FA user lain"},
+	{Name: models.PermRolesManage, Description: "Membuat dan mengubah role beserta permission-nya"},
+	{Name: models.PermSettingsManage, Description: "Mengubah pengaturan aplikasi"},
+	{Name: models.PermAuditRead, Description: "Membaca audit log"},
+}
+
+// defaultRoles are the system roles with the permissions that match the original behavior of each role.
+// The admin role is given every permission on startup.
+var defaultRoles = []struct {
+	name        string
+	description string
+	permissions []string
+}{
+	{models.RoleAdmin, "Akses penuh ke semua fitur", nil},
+	{models.RoleTeacher, "Mengelola kelas, kuis dan soal miliknya serta menilai siswanya", []string{
+		models.PermKelasCreate, models.PermKelasUpdate, models.PermKelasDelete, models.PermKelasEnroll,
+		models.PermKuisCreate, models.PermKuisUpdate, models.PermKuisDelete,
+		models.PermSoalCreate, models.PermSoalUpdate, models.PermSoalDelete,
+		models.PermResultsRead, models.PermGradingGrade,
+	}},
+	{models.RoleStudent, "Bergabung ke kelas dan mengerjakan kuis", []string{}},
+}
+
+// seedRoles creates missing permissions and system roles, and gives the admin role every permission
+func seedRoles(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		byName := make(map[string]models.Permission, len(Permissions))
+		for _, permission := range Permissions {
+			if err := tx.Where(models.Permission{Name: permission.Name}).
+				Assign(models.Permission{Description: permission.Description}).
+				FirstOrCreate(&permission).Error; err != nil {
+				return fmt.Errorf("failed to seed permission %s: %w", permission.Name, err)
+			}
+			byName[permission.Name] = permission
+		}
+
+		for _, def := range defaultRoles {
+			var role models.Role
+			err := tx.Where("name = ?", def.name).First(&role).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("failed to look up role %s: %w", def.name, err)
+			}
+
+			// Mapping role teacher dan student yang sudah ada tidak ditimpa, karena bisa saja sudah diubah admin
+			if err == nil && def.name != models.RoleAdmin {
+				continue
+			}
+
+			names := def.permissions
+			if def.name == models.RoleAdmin {
+				names = permissionNames(Permissions)
+			}
+			permissions := make([]models.Permission, 0, len(names))
+			for _, name := range names {
+				permissions = append(permissions, byName[name])
+			}
+
+			if role.ID == 0 {
+				role = models.Role{Name: def.name, Description: def.description, System: true}
+				if err := tx.Create(&role).Error; err != nil {
+					return fmt.Errorf("failed to seed role %s: %w", def.name, err)
+				}
+			}
+			if err := tx.Model(&role).Association("Permissions").Replace(permissions); err != nil {
+				return fmt.Errorf("failed to seed permissions of role %s: %w", def.name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// permissionNames returns the names of the permissions
+func permissionNames(permissions []models.Permission) []string {
+	names := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		names = append(names, permission.Name)
+	}
+	return names
+}
+
+// ValidRole reports whether role is defined in the roles table
+func ValidRole(role string) bool {
+	_, err := GetRole(role)
+	return err == nil
+}
+
+// RoleHasPermission reports whether the role grants the permission.
+// Mappings are cached for a minute; changes made through this package clear the cache at once.
+func RoleHasPermission(role string, permission string) (bool, error) {
+	cache, err := cachedPermissions()
+	if err != nil {
+		return false, err
+	}
+	return cache[role][permission], nil
+}
+
+// cachedPermissions returns the role -> permission mapping, reading it again once it is too old
+func cachedPermissions() (map[string]map[string]bool, error) {
+	permissionCacheMu.RLock()
+	cache, loaded := permissionCache, permissionCacheLoaded
+	permissionCacheMu.RUnlock()
+
+	if cache == nil || time.Since(loaded) > permissionCacheTTL {
+		return loadPermissionCache()
+	}
+	return cache, nil
+}
+
+// RoleWithin reports whether every permission of role is also granted by the other role
+func RoleWithin(role string, other string) (bool, error) {
+	cache, err := cachedPermissions()
+	if err != nil {
+		return false, err
+	}
+
+	for permission := range cache[role] {
+		if !cache[other][permission] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// loadPermissionCache reads every role with its permissions into the cache
+func loadPermissionCache() (map[string]map[string]bool, error) {
+	roles, err := GetRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	cache := make(map[string]map[string]bool, len(roles))
+	for _, role := range roles {
+		cache[role.Name] = make(map[string]bool, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			cache[role.Name][permission.Name] = true
+		}
+	}
+
+	permissionCacheMu.Lock()
+	permissionCache, permissionCacheLoaded = cache, time.Now()
+	permissionCacheMu.Unlock()
+
+	return cache, nil
+}
+
+// clearPermissionCache makes the next permission check read the roles again
+func clearPermissionCache() {
+	permissionCacheMu.Lock()
+	permissionCache = nil
+	permissionCacheMu.Unlock()
+}
+
+// GetRoles lists every role with its permissions
+func GetRoles() ([]models.Role, error) {
+	var roles []models.Role
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return roles, err
+	}
+
+	if err := db.Preload("Permissions", func(tx *gorm.DB) *gorm.DB { return tx.Order("name") }).
+		Order("id").Find(&roles).Error; err != nil {
+		return roles, fmt.Errorf("failed to retrieve roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// GetRole retrieves a role with its permissions by name
+func GetRole(name string) (models.Role, error) {
+	var role models.Role
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return role, err
+	}
+
+	if err := db.Preload("Permissions").Where("name = ?", name).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return role, ErrRoleNotFound
+		}
+		return role, fmt.Errorf("failed to retrieve role: %w", err)
+	}
+
+	return role, nil
+}
+
+// GetPermissions lists every permission
+func GetPermissions() ([]models.Permission, error) {
+	var permissions []models.Permission
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return permissions, err
+	}
+
+	if err := db.Order("name").Find(&permissions).Error; err != nil {
+		return permissions, fmt.Errorf("failed to retrieve permissions: %w", err)
+	}
+
+	return permissions, nil
+}
+
+// findPermissions loads the permissions with the given names, rejecting unknown ones
+func findPermissions(tx *gorm.DB, names []string) ([]models.Permission, error) {
+	permissions := []models.Permission{}
+	if len(names) == 0 {
+		return permissions, nil
+	}
+	if err := tx.Where("name IN ?", names).Find(&permissions).Error; err != nil {
+		return permissions, fmt.Errorf("failed to retrieve permissions: %w", err)
+	}
+
+	found := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		found[permission.Name] = true
+	}
+	for _, name := range names {
+		if !found[name] {
+			return permissions, fmt.Errorf("%w: %s", ErrInvalidPermission, name)
+		}
+	}
+	return permissions, nil
+}
+
+// CreateRole defines a custom role with the given permissions
+func CreateRole(name string, description string, permissionNames []string) (models.Role, error) {
+	role := models.Role{Name: strings.ToLower(strings.TrimSpace(name)), Description: description}
+	if !roleNamePattern.MatchString(role.Name) {
+		return role, ErrInvalidRoleName
+	}
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return role, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Unscoped().Model(&models.Role{}).Where("name = ?", role.Name).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check role: %w", err)
+		}
+		if count > 0 {
+			return ErrRoleExists
+		}
+
+		permissions, err := findPermissions(tx, permissionNames)
+		if err != nil {
+			return err
+		}
+		role.Permissions = permissions
+		if err := tx.Create(&role).Error; err != nil {
+			return fmt.Errorf("failed to create role: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return role, err
+	}
+
+	clearPermissionCache()
+	return role, nil
+}
+
+// UpdateRole changes the description and replaces the permissions of a role.
+// A nil permission list keeps the current permissions.
+func UpdateRole(name string, description *string, permissionNames []string) (models.Role, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return models.Role{}, err
+	}
+
+	var role models.Role
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("name = ?", name).First(&role).Error; err != nil {
+			return ErrRoleNotFound
+		}
+
+		if description != nil {
+			if err := tx.Model(&role).Update("description", *description).Error; err != nil {
+				return fmt.Errorf("failed to update role: %w", err)
+			}
+		}
+
+		if permissionNames != nil {
+			if role.Name == models.RoleAdmin {
+				return ErrAdminRoleFixed
+			}
+			permissions, err := findPermissions(tx, permissionNames)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&role).Association("Permissions").Replace(permissions); err != nil {
+				return fmt.Errorf("failed to update role permissions: %w", err)
+			}
+		}
+
+		return tx.Preload("Permissions").First(&role, role.ID).Error
+	})
+	if err != nil {
+		return role, err
+	}
+
+	clearPermissionCache()
+	return role, nil
+}
+
+// DeleteRole removes a custom role that is no longer assigned to anyone
+func DeleteRole(name string) error {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var role models.Role
+		if err := tx.Where("name = ?", name).First(&role).Error; err != nil {
+			return ErrRoleNotFound
+		}
+		if role.System {
+			return ErrSystemRole
+		}
+
+		var users int64
+		if err := tx.Unscoped().Model(&models.Users{}).Where("role = ?", role.Name).Count(&users).Error; err != nil {
+			return fmt.Errorf("failed to count users: %w", err)
+		}
+		if users > 0 {
+			return ErrRoleInUse
+		}
+
+		if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+			return fmt.Errorf("failed to delete role permissions: %w", err)
+		}
+		// Hapus permanen supaya nama role bisa dipakai lagi
+		if err := tx.Unscoped().Delete(&role).Error; err != nil {
+			return fmt.Errorf("failed to delete role: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	clearPermissionCache()
+	return nil
+}
+
+// sortedRoles returns the roles in alphabetical order without duplicates
+func sortedRoles(roles []string) []string {
+	seen := make(map[string]bool, len(roles))
+	sorted := []string{}
+	for _, role := range roles {
+		if !seen[role] {
+			seen[role] = true
+			sorted = append(sorted, role)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/Joko206/UAS_PWEB1/models"
 	"golang.org/x/crypto/bcrypt"
@@ -115,7 +116,10 @@ func seedUsers(db *gorm.DB) error {
 		{Name: "Olivia Hernandez", Email: "olivia.hernandez@example.com", Password: hashedPassword, Role: "student"},
 	}
 
+	// Akun contoh langsung terverifikasi supaya bisa dipakai tanpa email
+	verifiedAt := time.Now()
 	for _, user := range users {
+		user.EmailVerifiedAt = &verifiedAt
 		if err := db.Create(&user).Error; err != nil {
 			return err
 		}
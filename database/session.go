@@ -0,0 +1,235 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the session functions
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrSessionRevoked     = errors.New("session has been revoked")
+	ErrSessionExpired     = errors.New("session has expired")
+	ErrRefreshTokenReused = errors.New("refresh token was already used, session revoked")
+)
+
+// Reasons stored in Session.RevokedReason
+const (
+	RevokedLogout          = "logout"
+	RevokedByUser          = "revoked_by_user"
+	RevokedByAdmin         = "revoked_by_admin"
+	RevokedTokenReuse      = "refresh_token_reuse"
+	RevokedPasswordReset   = "password_reset"
+	RevokedAccountDisabled = "account_disabled"
+	RevokedPasswordChange  = "password_change"
+)
+
+// newRandomToken returns a random URL-safe token to hand out to the client
+func newRandomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the value stored for a token; the token itself is never stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a session for a login and returns it with its refresh token
+func CreateSession(userID uint, device string, ip string, ttl time.Duration) (models.Session, string, error) {
+	var session models.Session
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return session, "", err
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return session, "", err
+	}
+
+	now := time.Now()
+	session = models.Session{
+		Users_id:         userID,
+		RefreshTokenHash: hashToken(token),
+		Device:           device,
+		IPAddress:        ip,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(ttl),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return session, "", fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return session, token, nil
+}
+
+// RotateSession exchanges a refresh token for a new one. Presenting a token that was
+// already rotated revokes the whole session, since it means the token was copied.
+func RotateSession(refreshToken string, device string, ip string) (models.Session, string, error) {
+	var session models.Session
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return session, "", err
+	}
+
+	hash := hashToken(refreshToken)
+	err = db.Where("refresh_token_hash = ?", hash).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if db.Where("previous_refresh_hash = ?", hash).First(&session).Error == nil {
+			if err := revokeSession(db, session.ID, RevokedTokenReuse); err != nil {
+				return session, "", err
+			}
+			return session, "", ErrRefreshTokenReused
+		}
+		return session, "", ErrSessionNotFound
+	}
+	if err != nil {
+		return session, "", fmt.Errorf("failed to look up session: %w", err)
+	}
+
+	if err := checkSession(session, time.Now()); err != nil {
+		return session, "", err
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return session, "", err
+	}
+
+	// The condition on the old hash makes a concurrent refresh with the same token fail
+	res := db.Model(&models.Session{}).Where("id = ? AND refresh_token_hash = ?", session.ID, hash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":    hashToken(token),
+			"previous_refresh_hash": hash,
+			"device":                device,
+			"ip_address":            ip,
+			"last_used_at":          time.Now(),
+		})
+	if res.Error != nil {
+		return session, "", fmt.Errorf("failed to rotate session: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		if err := revokeSession(db, session.ID, RevokedTokenReuse); err != nil {
+			return session, "", err
+		}
+		return session, "", ErrRefreshTokenReused
+	}
+
+	session.Device = device
+	session.IPAddress = ip
+	session.LastUsedAt = time.Now()
+	return session, token, nil
+}
+
+// GetActiveSession returns a session if it is neither revoked nor expired
+func GetActiveSession(id uint) (models.Session, error) {
+	var session models.Session
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return session, err
+	}
+
+	if err := db.First(&session, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return session, ErrSessionNotFound
+		}
+		return session, fmt.Errorf("failed to retrieve session: %w", err)
+	}
+
+	return session, checkSession(session, time.Now())
+}
+
+// GetUserSessions lists the active sessions of a user, most recently used first
+func GetUserSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return sessions, err
+	}
+
+	if err := db.Where("users_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		return sessions, fmt.Errorf("failed to retrieve sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes one session of a user
+func RevokeSession(userID uint, sessionID uint, reason string) error {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return err
+	}
+
+	var session models.Session
+	if err := db.Where("id = ? AND users_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		return ErrSessionNotFound
+	}
+
+	return revokeSession(db, session.ID, reason)
+}
+
+// RevokeUserSessions revokes every active session of a user, returning how many were revoked
+func RevokeUserSessions(userID uint, reason string) (int64, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return 0, err
+	}
+
+	return revokeAllSessions(db, userID, reason, 0)
+}
+
+// revokeSession marks a session as revoked if it is not already
+func revokeSession(tx *gorm.DB, sessionID uint, reason string) error {
+	if err := tx.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error; err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// checkSession returns why a session can no longer be used, or nil
+func checkSession(session models.Session, now time.Time) error {
+	if session.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+	if !now.Before(session.ExpiresAt) {
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// revokeAllSessions revokes every active session of a user except keepSessionID (0 keeps none),
+// returning how many were revoked
+func revokeAllSessions(tx *gorm.DB, userID uint, reason string, keepSessionID uint) (int64, error) {
+	res := tx.Model(&models.Session{}).Where("users_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepSessionID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason})
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// getSetting decodes the value of a setting into dest and reports whether the setting exists
+func getSetting(key string, dest interface{}) (bool, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return false, err
+	}
+
+	var setting models.Setting
+	err = db.Where("key = ?", key).First(&setting).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to retrieve setting: %w", err)
+	}
+
+	if err := json.Unmarshal(setting.Value, dest); err != nil {
+		return false, fmt.Errorf("failed to read setting: %w", err)
+	}
+	return true, nil
+}
+
+// saveSetting stores value as the setting with the given key
+func saveSetting(key string, value interface{}, updatedBy uint) error {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode setting: %w", err)
+	}
+
+	var setting models.Setting
+	err = db.Where("key = ?", key).First(&setting).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to retrieve setting: %w", err)
+	}
+	setting.Key = key
+	setting.Value = encoded
+	setting.UpdatedBy = updatedBy
+	if err := db.Save(&setting).Error; err != nil {
+		return fmt.Errorf("failed to save setting: %w", err)
+	}
+
+	return nil
+}
@@ -2,17 +2,24 @@ package database
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Joko206/UAS_PWEB1/models"
 )
 
-func CreateSoal(question string, option json.RawMessage, correct_answer string, kuis_id uint) (models.Soal, error) {
+func CreateSoal(question string, soalType string, option json.RawMessage, correct_answer string, answerKey json.RawMessage, points float64, partialCredit string, tingkatan_id *uint, kuis_id uint) (models.Soal, error) {
 	var newSoal = models.Soal{
 		Question:       question,
+		Type:           soalType,
 		Options:        option,
 		Correct_answer: correct_answer,
+		AnswerKey:      answerKey,
+		Points:         points,
+		PartialCredit:  partialCredit,
+		Tingkatan_id:   tingkatan_id,
 		Kuis_id:        kuis_id,
 	}
+	newSoal.Type = newSoal.QuestionType()
 
 	// Get DB connection
 	db, err := GetDBConnection()
@@ -20,11 +27,20 @@ func CreateSoal(question string, option json.RawMessage, correct_answer string,
 		return newSoal, err
 	}
 
+	// Validate the answer key against the soal type
+	if err := ValidateSoal(newSoal); err != nil {
+		return newSoal, err
+	}
+
 	// Insert the new Soal into the database
 	if err := db.Create(&newSoal).Error; err != nil {
 		return newSoal, fmt.Errorf("failed to insert data into soal: %w", err)
 	}
 
+	if err := markDraftChanged(db, newSoal.Kuis_id); err != nil {
+		return newSoal, err
+	}
+
 	return newSoal, nil
 }
 
@@ -46,6 +62,114 @@ func GetSoal() ([]models.Soal, error) {
 	return soalList, nil
 }
 
+// GetSoalByKuis retrieves the Soal of one Kuis
+func GetSoalByKuis(kuisID uint) ([]models.Soal, error) {
+	var soalList []models.Soal
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return soalList, err
+	}
+
+	if err := db.Where("kuis_id = ?", kuisID).Find(&soalList).Error; err != nil {
+		return soalList, fmt.Errorf("failed to retrieve soal: %w", err)
+	}
+
+	return soalList, nil
+}
+
+// GetSoalForUser retrieves the Soal of every Kuis the user can access or may modify.
+// Kuis of other users are served from their published version, kuis the user authors from the draft.
+// Kuis with a time limit, a question pool or shuffling are left out for non-authors: their
+// questions are only shown through an attempt.
+func GetSoalForUser(userID uint) ([]models.Soal, error) {
+	var soalList []models.Soal
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return soalList, err
+	}
+
+	// Kuis buatan user dan kuis di kelas yang diajarnya selalu ikut, termasuk yang privat dan yang masih draft
+	var own []models.Soal
+	if err := db.Where("kuis_id IN (?)", authoredKuis(db, userID)).
+		Preload("Kuis").Find(&own).Error; err != nil {
+		return soalList, fmt.Errorf("failed to retrieve soal: %w", err)
+	}
+	authored := make(map[uint]bool)
+	var authoredIDs []uint
+	if err := authoredKuis(db, userID).Pluck("id", &authoredIDs).Error; err != nil {
+		return soalList, fmt.Errorf("failed to retrieve kuis: %w", err)
+	}
+	for _, id := range authoredIDs {
+		authored[id] = true
+	}
+
+	accessible, err := GetKuisForUser(userID)
+	if err != nil {
+		return soalList, err
+	}
+
+	for _, kuis := range accessible {
+		if authored[kuis.ID] || requiresStartedAttempt(kuis.KuisSettings) {
+			continue
+		}
+		published, err := GetPublishedSoal(kuis)
+		if errors.Is(err, ErrKuisNotPublished) {
+			continue
+		}
+		if err != nil {
+			return soalList, err
+		}
+		for i := range published {
+			published[i].Kuis = kuis
+		}
+		soalList = append(soalList, published...)
+	}
+
+	return append(soalList, own...), nil
+}
+
+// GetDeliverySoal returns the published soal of a kuis without answer keys, for a user who may access it.
+// Kuis that hand out their questions only through an attempt (time limit, question pool or shuffling)
+// return ErrAttemptRequired, like GetSoalForUser leaves them out.
+func GetDeliverySoal(userID uint, kuis models.Kuis) ([]models.SoalDelivery, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, err := CanUserAccessKuis(userID, kuis)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrKuisAccessDenied
+	}
+
+	published, err := publishedKuis(db, kuis)
+	if err != nil {
+		return nil, err
+	}
+	if requiresStartedAttempt(published.KuisSettings) {
+		return nil, ErrAttemptRequired
+	}
+
+	soalList, err := GetPublishedSoal(kuis)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := make([]models.SoalDelivery, 0, len(soalList))
+	for _, soal := range soalList {
+		delivery = append(delivery, soal.Delivery())
+	}
+	return delivery, nil
+}
+
 // DeleteSoal deletes a Soal by its ID
 func DeleteSoal(id string) error {
 	var soal models.Soal
@@ -56,33 +180,98 @@ func DeleteSoal(id string) error {
 		return err
 	}
 
+	if err := db.Where("ID = ?", id).First(&soal).Error; err != nil {
+		return fmt.Errorf("soal not found")
+	}
+
 	// Delete the Soal by ID
 	if err := db.Where("ID = ?", id).Delete(&soal).Error; err != nil {
 		return fmt.Errorf("failed to delete soal: %w", err)
 	}
 
+	if err := markDraftChanged(db, soal.Kuis_id); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// UpdateSoal updates an existing Soal in the database
-func UpdateSoal(question string, option json.RawMessage, correct_answer string, kuis_id uint, id string) (models.Soal, error) {
+// UpdateSoal updates an existing Soal in the database and reports whether its answer key changed
+func UpdateSoal(question string, soalType string, option json.RawMessage, correct_answer string, answerKey json.RawMessage, points float64, partialCredit string, tingkatan_id *uint, kuis_id uint, id string) (models.Soal, bool, error) {
 	var updatedSoal = models.Soal{
 		Question:       question,
+		Type:           soalType,
 		Options:        option,
 		Correct_answer: correct_answer,
+		AnswerKey:      answerKey,
+		Points:         points,
+		PartialCredit:  partialCredit,
+		Tingkatan_id:   tingkatan_id,
 		Kuis_id:        kuis_id,
 	}
 
 	// Get DB connection
 	db, err := GetDBConnection()
 	if err != nil {
-		return updatedSoal, err
+		return updatedSoal, false, err
+	}
+
+	// Validate the soal as it will look after the update
+	var existing models.Soal
+	if err := db.Where("ID = ?", id).First(&existing).Error; err != nil {
+		return updatedSoal, false, fmt.Errorf("soal not found")
+	}
+	merged := mergeSoal(existing, updatedSoal)
+	if err := ValidateSoal(merged); err != nil {
+		return updatedSoal, false, err
 	}
 
 	// Update the Soal details
 	if err := db.Where("ID = ?", id).Updates(&updatedSoal).Error; err != nil {
-		return updatedSoal, fmt.Errorf("failed to update soal: %w", err)
+		return updatedSoal, false, fmt.Errorf("failed to update soal: %w", err)
+	}
+
+	// Students keep seeing the published version until the kuis is published again
+	if err := markDraftChanged(db, existing.Kuis_id); err != nil {
+		return updatedSoal, false, err
 	}
+	if updatedSoal.Kuis_id != 0 && updatedSoal.Kuis_id != existing.Kuis_id {
+		if err := markDraftChanged(db, updatedSoal.Kuis_id); err != nil {
+			return updatedSoal, false, err
+		}
+	}
+
+	return updatedSoal, answerKeyChanged(existing, merged), nil
+}
 
-	return updatedSoal, nil
+// mergeSoal applies the non-empty fields of an update to an existing Soal, like Updates does
+func mergeSoal(existing models.Soal, update models.Soal) models.Soal {
+	if update.Question != "" {
+		existing.Question = update.Question
+	}
+	if update.Type != "" {
+		existing.Type = update.Type
+	}
+	if len(update.Options) > 0 {
+		existing.Options = update.Options
+	}
+	if update.Correct_answer != "" {
+		existing.Correct_answer = update.Correct_answer
+	}
+	if len(update.AnswerKey) > 0 {
+		existing.AnswerKey = update.AnswerKey
+	}
+	if update.Points != 0 {
+		existing.Points = update.Points
+	}
+	if update.PartialCredit != "" {
+		existing.PartialCredit = update.PartialCredit
+	}
+	if update.Tingkatan_id != nil {
+		existing.Tingkatan_id = update.Tingkatan_id
+	}
+	if update.Kuis_id != 0 {
+		existing.Kuis_id = update.Kuis_id
+	}
+	return existing
 }
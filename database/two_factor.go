@@ -0,0 +1,350 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/Joko206/UAS_PWEB1/totp"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the two-factor functions
+var (
+	ErrChallengeInvalid     = errors.New("login challenge is invalid or has expired, please log in again")
+	ErrTwoFactorCodeInvalid = errors.New("invalid two-factor code")
+	ErrTwoFactorNotSetUp    = errors.New("two-factor authentication has not been set up")
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorRequired    = errors.New("two-factor authentication is required for your role")
+	ErrRecoveryCodeInvalid  = errors.New("invalid or already used recovery code")
+)
+
+// Batas percobaan kode per challenge, jumlah recovery code, dan toleransi selisih jam (dalam time step)
+const (
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+	totpSkew             = 1
+)
+
+// settingTwoFactorRoles is the Setting key holding the roles that must use 2FA
+const settingTwoFactorRoles = "two_factor_required_roles"
+
+// GetTwoFactorRequiredRoles returns the roles that must use two-factor authentication
+func GetTwoFactorRequiredRoles() ([]string, error) {
+	roles := []string{}
+	if _, err := getSetting(settingTwoFactorRoles, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// SetTwoFactorRequiredRoles replaces the roles that must use two-factor authentication
+func SetTwoFactorRequiredRoles(roles []string, updatedBy uint) ([]string, error) {
+	for _, role := range roles {
+		if !ValidRole(role) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRole, role)
+		}
+	}
+	normalized := sortedRoles(roles)
+
+	if err := saveSetting(settingTwoFactorRoles, normalized, updatedBy); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// TwoFactorRequired reports whether the role must use two-factor authentication
+func TwoFactorRequired(role string) (bool, error) {
+	roles, err := GetTwoFactorRequiredRoles()
+	if err != nil {
+		return false, err
+	}
+	for _, required := range roles {
+		if required == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CreateLoginChallenge starts the second login step for a user whose password was correct
+func CreateLoginChallenge(userID uint, purpose string, ttl time.Duration) (string, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return "", err
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := models.LoginChallenge{
+		Users_id:  userID,
+		TokenHash: hashToken(token),
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.Create(&challenge).Error; err != nil {
+		return "", fmt.Errorf("failed to create login challenge: %w", err)
+	}
+
+	return token, nil
+}
+
+// GetLoginChallenge returns an unused, unexpired challenge with the given purpose and its user
+func GetLoginChallenge(token string, purpose string) (models.LoginChallenge, models.Users, error) {
+	var challenge models.LoginChallenge
+	var user models.Users
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return challenge, user, err
+	}
+
+	if err := db.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&challenge).Error; err != nil {
+		return challenge, user, ErrChallengeInvalid
+	}
+	if challenge.UsedAt != nil || !time.Now().Before(challenge.ExpiresAt) || challenge.FailedAttempts >= maxChallengeAttempts {
+		return challenge, user, ErrChallengeInvalid
+	}
+	if err := db.First(&user, challenge.Users_id).Error; err != nil {
+		return challenge, user, ErrChallengeInvalid
+	}
+
+	return challenge, user, nil
+}
+
+// FailLoginChallenge counts a wrong code; the challenge stops working after too many
+func FailLoginChallenge(challengeID uint) error {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return err
+	}
+
+	if err := db.Model(&models.LoginChallenge{}).Where("id = ?", challengeID).
+		Update("failed_attempts", gorm.Expr("failed_attempts + 1")).Error; err != nil {
+		return fmt.Errorf("failed to update login challenge: %w", err)
+	}
+	return nil
+}
+
+// UseLoginChallenge marks a challenge as used so the same challenge cannot log in twice
+func UseLoginChallenge(challengeID uint) error {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return err
+	}
+
+	res := db.Model(&models.LoginChallenge{}).Where("id = ? AND used_at IS NULL", challengeID).Update("used_at", time.Now())
+	if res.Error != nil {
+		return fmt.Errorf("failed to use login challenge: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrChallengeInvalid
+	}
+	return nil
+}
+
+// SetupTOTP stores a new secret for the user that becomes active once a code from it is confirmed
+func SetupTOTP(userID uint) (string, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return "", err
+	}
+
+	var user models.Users
+	if err := db.First(&user, userID).Error; err != nil {
+		return "", ErrUserNotFound
+	}
+	if user.TwoFactorEnabled {
+		return "", ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	if err := db.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		return "", fmt.Errorf("failed to save two-factor secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+// EnableTOTP confirms the pending secret with a code and returns a fresh set of recovery codes
+func EnableTOTP(userID uint, code string) ([]string, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var user models.Users
+		if err := tx.First(&user, userID).Error; err != nil {
+			return ErrUserNotFound
+		}
+		if user.TwoFactorEnabled {
+			return ErrTwoFactorEnabled
+		}
+		if user.TOTPSecret == "" {
+			return ErrTwoFactorNotSetUp
+		}
+
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew)
+		if !ok {
+			return ErrTwoFactorCodeInvalid
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{"two_factor_enabled": true, "totp_last_step": step}).Error; err != nil {
+			return fmt.Errorf("failed to enable two-factor authentication: %w", err)
+		}
+
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+
+	return codes, err
+}
+
+// VerifyTOTP checks a code of a user with 2FA enabled. A code is accepted only once.
+func VerifyTOTP(user models.Users, code string) error {
+	if !user.TwoFactorEnabled || user.TOTPSecret == "" {
+		return ErrTwoFactorNotEnabled
+	}
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew)
+	if !ok {
+		return ErrTwoFactorCodeInvalid
+	}
+
+	// The condition on the last step rejects a code that was already used, even concurrently
+	res := db.Model(&models.Users{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+	if res.Error != nil {
+		return fmt.Errorf("failed to verify two-factor code: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrTwoFactorCodeInvalid
+	}
+	return nil
+}
+
+// UseRecoveryCode spends one recovery code of the user and returns how many are left
+func UseRecoveryCode(userID uint, code string) (int64, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return 0, err
+	}
+
+	res := db.Model(&models.RecoveryCode{}).
+		Where("users_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to use recovery code: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return 0, ErrRecoveryCodeInvalid
+	}
+
+	var left int64
+	if err := db.Model(&models.RecoveryCode{}).Where("users_id = ? AND used_at IS NULL", userID).Count(&left).Error; err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return left, nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user
+func RegenerateRecoveryCodes(userID uint) ([]string, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// DisableTOTP turns off two-factor authentication and removes the secret and recovery codes
+func DisableTOTP(userID uint) error {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Users{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"two_factor_enabled": false, "totp_secret": "", "totp_last_step": 0})
+		if res.Error != nil {
+			return fmt.Errorf("failed to disable two-factor authentication: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrUserNotFound
+		}
+		if err := tx.Where("users_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to remove recovery codes: %w", err)
+		}
+		return nil
+	})
+}
+
+// replaceRecoveryCodes deletes the old recovery codes of a user and stores new ones
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("users_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to remove recovery codes: %w", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		rows = append(rows, models.RecoveryCode{Users_id: userID, CodeHash: hashToken(normalizeRecoveryCode(code))})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to save recovery codes: %w", err)
+	}
+
+	return codes, nil
+}
+
+// newRecoveryCode returns a code like "k3xa9-wq2mf" that is easy to type
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 7)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in a typed recovery code
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
@@ -0,0 +1,358 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the user management functions
+var (
+	ErrEmailTaken       = errors.New("email is already used by another account")
+	ErrUserNotDeleted   = errors.New("user is not deleted")
+	ErrCannotTargetSelf = errors.New("admins cannot do this to their own account")
+)
+
+// lockedIndefinitely is the LockedUntil of accounts locked by an admin without an end time
+var lockedIndefinitely = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// UserFilter holds the filters of the admin user listing; zero values are ignored
+type UserFilter struct {
+	Role        string
+	Name        string
+	Email       string
+	Locked      *bool
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Deleted     bool // list soft-deleted users instead of active ones
+	Page        int
+	Limit       int
+}
+
+// UserStats counts what a user has done in the application
+type UserStats struct {
+	KelasJoined  int64 `json:"kelas_joined"`
+	KelasCreated int64 `json:"kelas_created"`
+	KuisCreated  int64 `json:"kuis_created"`
+	Attempts     int64 `json:"attempts"`
+	KuisTaken    int64 `json:"kuis_taken"`
+}
+
+// ListUsers returns one page of users matching the filter and the total number of matches
+func ListUsers(filter UserFilter) ([]models.Users, int64, error) {
+	var users []models.Users
+	var total int64
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return users, total, err
+	}
+
+	query := db.Model(&models.Users{})
+	if filter.Deleted {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.Name != "" {
+		query = query.Where("name ILIKE ?", "%"+filter.Name+"%")
+	}
+	if filter.Email != "" {
+		query = query.Where("email ILIKE ?", "%"+filter.Email+"%")
+	}
+	if filter.Locked != nil {
+		if *filter.Locked {
+			query = query.Where("locked_until > ?", time.Now())
+		} else {
+			query = query.Where("locked_until IS NULL OR locked_until <= ?", time.Now())
+		}
+	}
+	if filter.CreatedFrom != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		query = query.Where("created_at <= ?", *filter.CreatedTo)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return users, total, fmt.Errorf("failed to count users: %w", err)
+	}
+	if err := query.Order("created_at DESC").Limit(filter.Limit).Offset((filter.Page - 1) * filter.Limit).
+		Find(&users).Error; err != nil {
+		return users, total, fmt.Errorf("failed to retrieve users: %w", err)
+	}
+
+	return users, total, nil
+}
+
+// GetUserByID returns a user, including soft-deleted ones
+func GetUserByID(id uint) (models.Users, error) {
+	var user models.Users
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return user, err
+	}
+
+	if err := db.Unscoped().First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, ErrUserNotFound
+		}
+		return user, fmt.Errorf("failed to retrieve user: %w", err)
+	}
+
+	return user, nil
+}
+
+// GetUserStats counts the classes, quizzes and attempts of a user
+func GetUserStats(userID uint) (UserStats, error) {
+	var stats UserStats
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return stats, err
+	}
+
+	counts := []struct {
+		model interface{}
+		where string
+		dest  *int64
+	}{
+		{&models.Kelas_Pengguna{}, "users_id = ?", &stats.KelasJoined},
+		{&models.Kelas{}, "created_by = ?", &stats.KelasCreated},
+		{&models.Kuis{}, "created_by = ?", &stats.KuisCreated},
+		{&models.KuisAttempt{}, "users_id = ?", &stats.Attempts},
+		{&models.Hasil_Kuis{}, "users_id = ?", &stats.KuisTaken},
+	}
+	for _, count := range counts {
+		if err := db.Model(count.model).Where(count.where, userID).Count(count.dest).Error; err != nil {
+			return stats, fmt.Errorf("failed to count user activity: %w", err)
+		}
+	}
+
+	return stats, nil
+}
+
+// UpdateUserDetails changes the name and email of a user. A new email is unverified
+// unless emailVerified says otherwise.
+func UpdateUserDetails(userID uint, name *string, email *string, emailVerified *bool) (models.Users, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return models.Users{}, err
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return user, err
+	}
+
+	updates := map[string]interface{}{}
+	if name != nil {
+		updates["name"] = strings.TrimSpace(*name)
+	}
+	if email != nil && !strings.EqualFold(strings.TrimSpace(*email), user.Email) {
+		newEmail := strings.TrimSpace(*email)
+		var count int64
+		if err := db.Unscoped().Model(&models.Users{}).Where("LOWER(email) = LOWER(?) AND id <> ?", newEmail, userID).
+			Count(&count).Error; err != nil {
+			return user, fmt.Errorf("failed to check email: %w", err)
+		}
+		if count > 0 {
+			return user, ErrEmailTaken
+		}
+		updates["email"] = newEmail
+		updates["email_verified_at"] = nil
+	}
+	if emailVerified != nil {
+		if *emailVerified {
+			if user.EmailVerifiedAt == nil || updates["email"] != nil {
+				updates["email_verified_at"] = time.Now()
+			}
+		} else {
+			updates["email_verified_at"] = nil
+		}
+	}
+	if len(updates) == 0 {
+		return user, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&user).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		if updates["email"] == nil {
+			return nil
+		}
+		// Link verifikasi yang sudah terkirim ditujukan ke alamat lama dan tidak boleh memverifikasi alamat baru
+		if err := tx.Model(&models.EmailVerificationToken{}).Where("users_id = ? AND used_at IS NULL", userID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return fmt.Errorf("failed to invalidate verification tokens: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return user, err
+	}
+
+	return GetUserByID(userID)
+}
+
+// LockUser locks an account until the given time, or indefinitely when until is nil,
+// and ends all of its sessions
+func LockUser(userID uint, until *time.Time) (models.Users, error) {
+	lockedUntil := lockedIndefinitely
+	if until != nil {
+		lockedUntil = *until
+	}
+	return updateAccountState(userID, map[string]interface{}{"locked_until": lockedUntil, "locked_by_admin": true}, true)
+}
+
+// UnlockUser lifts a lock and clears the failed login attempts
+func UnlockUser(userID uint) (models.Users, error) {
+	return updateAccountState(userID, map[string]interface{}{"locked_until": nil, "locked_by_admin": false, "failed_attempts": 0, "lockout_count": 0}, false)
+}
+
+// ResetFailedAttempts clears the failed login attempts of a user
+func ResetFailedAttempts(userID uint) (models.Users, error) {
+	return updateAccountState(userID, map[string]interface{}{"failed_attempts": 0}, false)
+}
+
+// DeleteUser soft-deletes a user and ends all of its sessions
+func DeleteUser(userID uint) error {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user models.Users
+		if err := tx.First(&user, userID).Error; err != nil {
+			return ErrUserNotFound
+		}
+		if err := ensureOtherAdmin(tx, user); err != nil {
+			return err
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		if err := revokeAllAPIKeys(tx, userID); err != nil {
+			return err
+		}
+		_, err := revokeAllSessions(tx, userID, RevokedAccountDisabled, 0)
+		return err
+	})
+}
+
+// RestoreUser brings back a soft-deleted user
+func RestoreUser(userID uint) (models.Users, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return models.Users{}, err
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return user, err
+	}
+	if !user.DeletedAt.Valid {
+		return user, ErrUserNotDeleted
+	}
+
+	if err := db.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
+		return user, fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	return GetUserByID(userID)
+}
+
+// updateAccountState applies account flag changes to an active user. Changes that disable the
+// account end its sessions and are refused for the last admin.
+func updateAccountState(userID uint, updates map[string]interface{}, disable bool) (models.Users, error) {
+	var user models.Users
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return user, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, userID).Error; err != nil {
+			return ErrUserNotFound
+		}
+		if disable {
+			if err := ensureOtherAdmin(tx, user); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		if disable {
+			if err := revokeAllAPIKeys(tx, userID); err != nil {
+				return err
+			}
+			_, err := revokeAllSessions(tx, userID, RevokedAccountDisabled, 0)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return user, err
+	}
+
+	return GetUserByID(userID)
+}
+
+// ensureOtherAdmin refuses to disable the last remaining admin
+func ensureOtherAdmin(tx *gorm.DB, user models.Users) error {
+	if user.Role != models.RoleAdmin {
+		return nil
+	}
+	var admins int64
+	if err := tx.Model(&models.Users{}).Where("role = ?", models.RoleAdmin).Count(&admins).Error; err != nil {
+		return fmt.Errorf("failed to count admins: %w", err)
+	}
+	if admins <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// ChangePassword stores a new password hash and revokes every session of the user except keepSessionID,
+// returning how many sessions were revoked
+func ChangePassword(userID uint, passwordHash []byte, keepSessionID uint) (int64, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return 0, err
+	}
+
+	var revoked int64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Users{}).Where("id = ?", userID).Update("password", passwordHash)
+		if res.Error != nil {
+			return fmt.Errorf("failed to change password: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrUserNotFound
+		}
+
+		var err error
+		revoked, err = revokeAllSessions(tx, userID, RevokedPasswordChange, keepSessionID)
+		return err
+	})
+
+	return revoked, err
+}
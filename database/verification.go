@@ -0,0 +1,183 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+)
+
+// Errors returned by the email verification functions
+var (
+	ErrVerificationTokenInvalid  = errors.New("verification token is invalid or has expired")
+	ErrEmailAlreadyVerified      = errors.New("email is already verified")
+	ErrVerificationTooSoon       = errors.New("a verification email was sent recently, please wait before requesting another")
+	ErrUnknownVerificationAction = errors.New("unknown action")
+)
+
+// Actions that can be blocked until the user's email is verified
+const (
+	ActionJoinKelas   = "join_kelas"
+	ActionAttemptKuis = "attempt_kuis"
+	ActionCreateKelas = "create_kelas"
+	ActionCreateKuis  = "create_kuis"
+)
+
+// VerificationActions lists every action that can require a verified email
+var VerificationActions = []string{ActionJoinKelas, ActionAttemptKuis, ActionCreateKelas, ActionCreateKuis}
+
+// defaultVerificationRequired is used until an admin saves the setting
+var defaultVerificationRequired = []string{ActionJoinKelas, ActionAttemptKuis}
+
+// settingVerificationRequired is the Setting key holding the blocked actions
+const settingVerificationRequired = "verification_required_actions"
+
+// CreateEmailVerificationToken issues a new verification token for the user; earlier unused tokens
+// stop working. A new token is refused if the previous one was sent less than minInterval ago.
+func CreateEmailVerificationToken(userID uint, ttl time.Duration, minInterval time.Duration) (string, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return "", err
+	}
+
+	var user models.Users
+	if err := db.First(&user, userID).Error; err != nil {
+		return "", fmt.Errorf("failed to retrieve user: %w", err)
+	}
+	if user.EmailVerified() {
+		return "", ErrEmailAlreadyVerified
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var last models.EmailVerificationToken
+		if err := tx.Where("users_id = ?", userID).Order("created_at DESC").First(&last).Error; err == nil &&
+			now.Sub(last.CreatedAt) < minInterval {
+			return ErrVerificationTooSoon
+		}
+
+		if err := tx.Model(&models.EmailVerificationToken{}).Where("users_id = ? AND used_at IS NULL", userID).
+			Update("used_at", now).Error; err != nil {
+			return fmt.Errorf("failed to invalidate old verification tokens: %w", err)
+		}
+
+		verification := models.EmailVerificationToken{
+			Users_id:  userID,
+			TokenHash: hashToken(token),
+			ExpiresAt: now.Add(ttl),
+		}
+		if err := tx.Create(&verification).Error; err != nil {
+			return fmt.Errorf("failed to save verification token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// VerifyEmail marks the email of the token's user as verified; the token can only be used once
+func VerifyEmail(token string) (models.Users, error) {
+	var user models.Users
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return user, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var verification models.EmailVerificationToken
+		if err := tx.Where("token_hash = ?", hashToken(token)).First(&verification).Error; err != nil {
+			return ErrVerificationTokenInvalid
+		}
+
+		now := time.Now()
+		res := tx.Model(&models.EmailVerificationToken{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", verification.ID, now).
+			Update("used_at", now)
+		if res.Error != nil {
+			return fmt.Errorf("failed to use verification token: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrVerificationTokenInvalid
+		}
+
+		if err := tx.First(&user, verification.Users_id).Error; err != nil {
+			return ErrVerificationTokenInvalid
+		}
+		if user.EmailVerified() {
+			return nil
+		}
+
+		user.EmailVerifiedAt = &now
+		if err := tx.Model(&user).Update("email_verified_at", now).Error; err != nil {
+			return fmt.Errorf("failed to verify email: %w", err)
+		}
+		return nil
+	})
+
+	return user, err
+}
+
+// GetVerificationRequiredActions returns the actions that need a verified email
+func GetVerificationRequiredActions() ([]string, error) {
+	actions := []string{}
+	found, err := getSetting(settingVerificationRequired, &actions)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return append([]string(nil), defaultVerificationRequired...), nil
+	}
+	return actions, nil
+}
+
+// SetVerificationRequiredActions replaces the actions that need a verified email
+func SetVerificationRequiredActions(actions []string, updatedBy uint) ([]string, error) {
+	// Keep the order of VerificationActions and drop duplicates
+	requested := make(map[string]bool, len(actions))
+	for _, action := range actions {
+		if !slices.Contains(VerificationActions, action) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownVerificationAction, action)
+		}
+		requested[action] = true
+	}
+	normalized := []string{}
+	for _, action := range VerificationActions {
+		if requested[action] {
+			normalized = append(normalized, action)
+		}
+	}
+
+	if err := saveSetting(settingVerificationRequired, normalized, updatedBy); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// VerificationRequired reports whether the action needs a verified email
+func VerificationRequired(action string) (bool, error) {
+	actions, err := GetVerificationRequiredActions()
+	if err != nil {
+		return false, err
+	}
+	for _, required := range actions {
+		if required == action {
+			return true, nil
+		}
+	}
+	return false, nil
+}
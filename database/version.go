@@ -0,0 +1,319 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrKuisNotPublished is returned when students try to use a kuis that was never published
+var ErrKuisNotPublished = errors.New("kuis is not published")
+
+// PublishKuis snapshots the current kuis, its settings and its soal into a new immutable version
+// and makes that version the one students see
+func PublishKuis(kuisID uint, publishedBy uint) (models.KuisVersion, error) {
+	var version models.KuisVersion
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return version, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Publishes of the same kuis are serialized so they cannot take the same version number
+		var kuis models.Kuis
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&kuis, kuisID).Error; err != nil {
+			return ErrKuisNotFound
+		}
+
+		var soalCount int64
+		if err := tx.Model(&models.Soal{}).Where("kuis_id = ?", kuis.ID).Count(&soalCount).Error; err != nil {
+			return fmt.Errorf("failed to count questions: %w", err)
+		}
+		if soalCount == 0 {
+			return ErrKuisHasNoQuestion
+		}
+
+		var previous *models.KuisSettings
+		if kuis.CurrentVersion_id != nil {
+			published, err := publishedKuis(tx, kuis)
+			if err != nil {
+				return err
+			}
+			previous = &published.KuisSettings
+		}
+
+		if version, err = snapshotKuis(tx, kuis, publishedBy); err != nil {
+			return err
+		}
+
+		// Official results follow the scoring policy and pass mark of the published version,
+		// while each attempt keeps the penalties of the version it was taken on
+		if previous != nil && (previous.ScoringPolicy != kuis.ScoringPolicy || previous.PassMark != kuis.PassMark) {
+			return recomputeHasilKuisForKuis(tx, kuis.ID)
+		}
+		return nil
+	})
+
+	return version, err
+}
+
+// snapshotKuis stores the kuis, its settings and its soal as the next version and points the kuis at it
+func snapshotKuis(tx *gorm.DB, kuis models.Kuis, publishedBy uint) (models.KuisVersion, error) {
+	var version models.KuisVersion
+
+	var soalList []models.Soal
+	if err := tx.Where("kuis_id = ?", kuis.ID).Order("id").Find(&soalList).Error; err != nil {
+		return version, fmt.Errorf("failed to fetch related questions: %w", err)
+	}
+
+	// The snapshot keeps the soal IDs so answers keep pointing at the same soal
+	for i := range soalList {
+		soalList[i].Kuis = models.Kuis{}
+		soalList[i].Tingkatan = nil
+	}
+	snapshot, err := json.Marshal(soalList)
+	if err != nil {
+		return version, fmt.Errorf("failed to snapshot questions: %w", err)
+	}
+	settings, err := json.Marshal(kuis.KuisSettings)
+	if err != nil {
+		return version, fmt.Errorf("failed to snapshot settings: %w", err)
+	}
+
+	var last uint
+	if err := tx.Unscoped().Model(&models.KuisVersion{}).Where("kuis_id = ?", kuis.ID).
+		Select("COALESCE(MAX(version_number), 0)").Scan(&last).Error; err != nil {
+		return version, fmt.Errorf("failed to count versions: %w", err)
+	}
+
+	version = models.KuisVersion{
+		Kuis_id:       kuis.ID,
+		VersionNumber: last + 1,
+		Title:         kuis.Title,
+		Description:   kuis.Description,
+		Soal:          snapshot,
+		Settings:      settings,
+		PublishedBy:   publishedBy,
+		PublishedAt:   time.Now(),
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return version, fmt.Errorf("failed to save kuis version: %w", err)
+	}
+
+	if err := tx.Model(&models.Kuis{}).Where("id = ?", kuis.ID).Updates(map[string]interface{}{
+		"status":             models.KuisPublished,
+		"current_version_id": version.ID,
+		"draft_changes":      false,
+	}).Error; err != nil {
+		return version, fmt.Errorf("failed to publish kuis: %w", err)
+	}
+
+	return version, nil
+}
+
+// currentVersion returns the published version of a kuis
+func currentVersion(tx *gorm.DB, kuis models.Kuis) (models.KuisVersion, error) {
+	var version models.KuisVersion
+
+	if kuis.CurrentVersion_id == nil {
+		return version, ErrKuisNotPublished
+	}
+	if err := tx.First(&version, *kuis.CurrentVersion_id).Error; err != nil {
+		return version, fmt.Errorf("failed to retrieve kuis version: %w", err)
+	}
+	return version, nil
+}
+
+// snapshotPublishedKuis gives kuis that were published before versions existed their first version
+// and stores the settings of versions made before settings were part of a version
+func snapshotPublishedKuis(db *gorm.DB) error {
+	var kuisIDs []uint
+	if err := db.Model(&models.Kuis{}).Where("status = ? AND current_version_id IS NULL", models.KuisPublished).
+		Pluck("id", &kuisIDs).Error; err != nil {
+		return fmt.Errorf("failed to find unversioned kuis: %w", err)
+	}
+
+	for _, kuisID := range kuisIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Another instance starting at the same time may have snapshotted the kuis already
+			var kuis models.Kuis
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&kuis, kuisID).Error; err != nil {
+				return fmt.Errorf("failed to lock kuis: %w", err)
+			}
+			if kuis.CurrentVersion_id != nil {
+				return nil
+			}
+			_, err := snapshotKuis(tx, kuis, kuis.CreatedBy)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var versions []models.KuisVersion
+	if err := db.Select("id", "kuis_id").Where("settings IS NULL").Find(&versions).Error; err != nil {
+		return fmt.Errorf("failed to find versions without settings: %w", err)
+	}
+	for _, version := range versions {
+		var kuis models.Kuis
+		if err := db.Unscoped().First(&kuis, version.Kuis_id).Error; err != nil {
+			continue // kuis sudah dihapus permanen
+		}
+		settings, err := json.Marshal(kuis.KuisSettings)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot settings: %w", err)
+		}
+		if err := db.Model(&models.KuisVersion{}).Where("id = ?", version.ID).Update("settings", settings).Error; err != nil {
+			return fmt.Errorf("failed to save version settings: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// versionSettings decodes the settings stored in a version, or returns fallback if it has none
+func versionSettings(version models.KuisVersion, fallback models.KuisSettings) (models.KuisSettings, error) {
+	if len(version.Settings) == 0 || string(version.Settings) == "null" {
+		return fallback, nil
+	}
+	var settings models.KuisSettings
+	if err := json.Unmarshal(version.Settings, &settings); err != nil {
+		return fallback, fmt.Errorf("failed to read kuis version settings: %w", err)
+	}
+	return settings, nil
+}
+
+// withVersion returns the kuis as it was published in the version
+func withVersion(kuis models.Kuis, version models.KuisVersion) (models.Kuis, error) {
+	settings, err := versionSettings(version, kuis.KuisSettings)
+	if err != nil {
+		return kuis, err
+	}
+	kuis.Title = version.Title
+	kuis.Description = version.Description
+	kuis.KuisSettings = settings
+	return kuis, nil
+}
+
+// publishedKuis returns the kuis as students see it: title, description and settings come from
+// the published version. A kuis that was never published is returned unchanged.
+func publishedKuis(tx *gorm.DB, kuis models.Kuis) (models.Kuis, error) {
+	if kuis.CurrentVersion_id == nil {
+		return kuis, nil
+	}
+	version, err := currentVersion(tx, kuis)
+	if err != nil {
+		return kuis, err
+	}
+	return withVersion(kuis, version)
+}
+
+// attemptSettings returns the settings an attempt is graded with: those of the version it was
+// taken on, or the published settings for attempts from before versions existed
+func attemptSettings(tx *gorm.DB, attempt models.KuisAttempt, kuis models.Kuis) (models.KuisSettings, error) {
+	if attempt.KuisVersion_id == nil {
+		published, err := publishedKuis(tx, kuis)
+		return published.KuisSettings, err
+	}
+
+	var version models.KuisVersion
+	if err := tx.First(&version, *attempt.KuisVersion_id).Error; err != nil {
+		return kuis.KuisSettings, fmt.Errorf("failed to retrieve kuis version: %w", err)
+	}
+	return versionSettings(version, kuis.KuisSettings)
+}
+
+// versionSoal decodes the soal stored in a version
+func versionSoal(version models.KuisVersion) ([]models.Soal, error) {
+	var soalList []models.Soal
+	if err := json.Unmarshal(version.Soal, &soalList); err != nil {
+		return soalList, fmt.Errorf("failed to read kuis version: %w", err)
+	}
+	return soalList, nil
+}
+
+// GetPublishedSoal returns the soal of the published version of a kuis
+func GetPublishedSoal(kuis models.Kuis) ([]models.Soal, error) {
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := currentVersion(db, kuis)
+	if err != nil {
+		return nil, err
+	}
+	return gradingSoal(db, version)
+}
+
+// GetKuisVersions lists the published versions of a kuis, newest first
+func GetKuisVersions(kuisID uint) ([]models.KuisVersion, error) {
+	var versions []models.KuisVersion
+
+	// Get DB connection
+	db, err := GetDBConnection()
+	if err != nil {
+		return versions, err
+	}
+
+	if err := db.Where("kuis_id = ?", kuisID).Order("version_number DESC").Find(&versions).Error; err != nil {
+		return versions, fmt.Errorf("failed to retrieve kuis versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// markDraftChanged flags a published kuis as having edits that are not live yet
+func markDraftChanged(tx *gorm.DB, kuisID uint) error {
+	if err := tx.Model(&models.Kuis{}).Where("id = ? AND status = ?", kuisID, models.KuisPublished).
+		Update("draft_changes", true).Error; err != nil {
+		return fmt.Errorf("failed to update kuis: %w", err)
+	}
+	return nil
+}
+
+// applyPublishedDetails shows students the title, description and settings of the published version
+func applyPublishedDetails(tx *gorm.DB, kuisList []models.Kuis) error {
+	versionIDs := []uint{}
+	for _, kuis := range kuisList {
+		if kuis.CurrentVersion_id != nil {
+			versionIDs = append(versionIDs, *kuis.CurrentVersion_id)
+		}
+	}
+	if len(versionIDs) == 0 {
+		return nil
+	}
+
+	var versions []models.KuisVersion
+	if err := tx.Select("id", "title", "description", "settings").Where("id IN ?", versionIDs).Find(&versions).Error; err != nil {
+		return fmt.Errorf("failed to retrieve kuis versions: %w", err)
+	}
+
+	versionByID := make(map[uint]models.KuisVersion, len(versions))
+	for _, version := range versions {
+		versionByID[version.ID] = version
+	}
+	for i := range kuisList {
+		if kuisList[i].CurrentVersion_id == nil {
+			continue
+		}
+		if version, ok := versionByID[*kuisList[i].CurrentVersion_id]; ok {
+			published, err := withVersion(kuisList[i], version)
+			if err != nil {
+				return err
+			}
+			kuisList[i] = published
+		}
+	}
+
+	return nil
+}
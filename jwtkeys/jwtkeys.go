@@ -0,0 +1,291 @@
+// Package jwtkeys menyimpan key untuk menandatangani dan memverifikasi access token.
+// Dengan JWT_KEYS token ditandatangani RS256 atau EdDSA dan membawa header kid, sehingga
+// beberapa key bisa aktif bersamaan saat rotasi dan public key-nya dipublikasikan sebagai JWKS.
+// Tanpa JWT_KEYS dipakai HS256 dengan JWT_SECRET, yang harus cukup panjang.
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Errors returned while loading keys
+var (
+	ErrNoKeys     = errors.New("no JWT signing key configured: set JWT_KEYS or JWT_SECRET")
+	ErrWeakSecret = errors.New("JWT_SECRET is too weak")
+)
+
+const (
+	// MinSecretLength is the minimum length in bytes of JWT_SECRET
+	MinSecretLength = 32
+	// MinRSABits is the minimum size of RSA keys
+	MinRSABits = 2048
+)
+
+// Nilai contoh yang sering ikut ter-deploy tanpa diganti
+var placeholderSecrets = []string{"your_jwt_secret", "secret", "changeme", "change-me", "jwt_secret"}
+
+// Key is one signing key. Keys without a private key can only verify tokens.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{} // *rsa.PrivateKey or ed25519.PrivateKey
+	Public  interface{} // *rsa.PublicKey or ed25519.PublicKey
+}
+
+// KeySet holds the keys that verify access tokens and the key that signs new ones
+type KeySet struct {
+	keys    map[string]Key
+	order   []string
+	signing string
+	secret  []byte // HS256, only when no asymmetric keys are configured
+}
+
+// FromEnv loads the keys listed in JWT_KEYS as comma separated kid=path pairs pointing to PEM files.
+// JWT_SIGNING_KEY selects the kid that signs new tokens (default the first key with a private key);
+// the other keys keep verifying tokens during a rotation. Without JWT_KEYS, JWT_SECRET is used for HS256.
+func FromEnv() (*KeySet, error) {
+	entries := strings.TrimSpace(os.Getenv("JWT_KEYS"))
+	if entries == "" {
+		secret := os.Getenv("JWT_SECRET")
+		if err := CheckSecret(secret); err != nil {
+			return nil, err
+		}
+		return &KeySet{secret: []byte(secret)}, nil
+	}
+
+	set := &KeySet{keys: make(map[string]Key)}
+	for _, entry := range strings.Split(entries, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path, found := strings.Cut(entry, "=")
+		kid, path = strings.TrimSpace(kid), strings.TrimSpace(path)
+		if !found || kid == "" || path == "" {
+			return nil, fmt.Errorf("invalid JWT_KEYS entry %q, expected kid=path", entry)
+		}
+		if _, exists := set.keys[kid]; exists {
+			return nil, fmt.Errorf("JWT key %s is listed twice", kid)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT key %s: %w", kid, err)
+		}
+		key, err := ParseKey(kid, data)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[kid] = key
+		set.order = append(set.order, kid)
+	}
+
+	set.signing = strings.TrimSpace(os.Getenv("JWT_SIGNING_KEY"))
+	if set.signing == "" {
+		for _, kid := range set.order {
+			if set.keys[kid].Private != nil {
+				set.signing = kid
+				break
+			}
+		}
+	}
+	key, ok := set.keys[set.signing]
+	switch {
+	case set.signing == "":
+		return nil, errors.New("JWT_KEYS has no private key to sign tokens with")
+	case !ok:
+		return nil, fmt.Errorf("JWT_SIGNING_KEY %s is not listed in JWT_KEYS", set.signing)
+	case key.Private == nil:
+		return nil, fmt.Errorf("JWT signing key %s has no private key", set.signing)
+	}
+
+	return set, nil
+}
+
+// CheckSecret refuses an HS256 secret that is missing, short or a known placeholder
+func CheckSecret(secret string) error {
+	if secret == "" {
+		return ErrNoKeys
+	}
+	if len(secret) < MinSecretLength {
+		return fmt.Errorf("%w: it must be at least %d bytes", ErrWeakSecret, MinSecretLength)
+	}
+	lower := strings.ToLower(secret)
+	for _, placeholder := range placeholderSecrets {
+		if strings.Contains(lower, placeholder) {
+			return fmt.Errorf("%w: it still contains the example value %q", ErrWeakSecret, placeholder)
+		}
+	}
+	if strings.Count(secret, secret[:1]) == len(secret) {
+		return fmt.Errorf("%w: it repeats a single character", ErrWeakSecret)
+	}
+	return nil
+}
+
+// ParseKey reads an RSA or Ed25519 key from PEM: a private key (PKCS#1 or PKCS#8) can sign
+// and verify, a public key (PKIX) can only verify
+func ParseKey(kid string, data []byte) (Key, error) {
+	key := Key{ID: kid}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return key, fmt.Errorf("JWT key %s is not PEM encoded", kid)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return key, fmt.Errorf("JWT key %s has unsupported PEM type %q", kid, block.Type)
+	}
+	if err != nil {
+		return key, fmt.Errorf("failed to parse JWT key %s: %w", kid, err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Private, key.Public = k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Public = k
+	case ed25519.PrivateKey:
+		key.Private, key.Public = k, k.Public()
+	case ed25519.PublicKey:
+		key.Public = k
+	default:
+		return key, fmt.Errorf("JWT key %s must be an RSA or Ed25519 key", kid)
+	}
+
+	switch public := key.Public.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < MinRSABits {
+			return key, fmt.Errorf("JWT key %s is too short: RSA keys need at least %d bits", kid, MinRSABits)
+		}
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	}
+	return key, nil
+}
+
+// Sign signs the claims with the signing key, adding its kid to the header
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if s.secret != nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+
+	key := s.keys[s.signing]
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Parse verifies a token with the key named by its kid; the algorithm must belong to that key
+func (s *KeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if s.secret != nil {
+			if token.Method != jwt.SigningMethodHS256 {
+				return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+			}
+			return s.secret, nil
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+		}
+		return key.Public, nil
+	})
+}
+
+// JSONWebKey is a public key in JWK format (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS returns the public keys that verify tokens; it is empty for HS256 since that secret cannot be shared
+func (s *KeySet) JWKS() []JSONWebKey {
+	keys := make([]JSONWebKey, 0, len(s.order))
+	for _, kid := range s.order {
+		key := s.keys[kid]
+		jwk := JSONWebKey{Kid: kid, Use: "sig", Alg: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		keys = append(keys, jwk)
+	}
+	return keys
+}
+
+// GenerateKey returns a new private key in PEM (PKCS#8); kind is "ed25519" or "rsa"
+func GenerateKey(kind string) ([]byte, error) {
+	var private interface{}
+	var err error
+	switch strings.ToLower(kind) {
+	case "", "ed25519", "eddsa":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa", "rs256":
+		private, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, fmt.Errorf("unknown key type %q, use ed25519 or rsa", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+var (
+	defaultSet *KeySet
+	defaultErr error
+	once       sync.Once
+)
+
+// Default returns the key set configured in the environment, loaded once per process
+func Default() (*KeySet, error) {
+	once.Do(func() {
+		defaultSet, defaultErr = FromEnv()
+	})
+	return defaultSet, defaultErr
+}
@@ -4,8 +4,13 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/jwtkeys"
+	"github.com/Joko206/UAS_PWEB1/notifier"
+	"github.com/Joko206/UAS_PWEB1/oidc"
+	"github.com/Joko206/UAS_PWEB1/ratelimit"
 	"github.com/Joko206/UAS_PWEB1/routes"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -18,6 +23,20 @@ func main() {
 		log.Println("Warning: .env file not found, using default values")
 	}
 
+	// Print a new private key for JWT_KEYS: ./main generate-jwt-key [ed25519|rsa]
+	if len(os.Args) > 1 && os.Args[1] == "generate-jwt-key" {
+		kind := ""
+		if len(os.Args) > 2 {
+			kind = os.Args[2]
+		}
+		key, err := jwtkeys.GenerateKey(kind)
+		if err != nil {
+			log.Fatalf("Failed to generate key: %v", err)
+		}
+		os.Stdout.Write(key)
+		return
+	}
+
 	// Check if seed argument is provided
 	if len(os.Args) > 1 && os.Args[1] == "seed" {
 		log.Println("Running database seeding...")
@@ -36,6 +55,11 @@ func main() {
 		return
 	}
 
+	// Refuse to start without a usable key instead of signing tokens with an empty or weak secret
+	if _, err := jwtkeys.Default(); err != nil {
+		log.Fatalf("Invalid JWT configuration: %v", err)
+	}
+
 	// Initialize database connection for the main application
 	if err := database.InitializeDatabase(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
@@ -48,7 +72,44 @@ func main() {
 		}
 	}()
 
-	app := fiber.New()
+	// Reset and verification links must reach the user; the log notifier is for development only
+	if err := notifier.Check(); err != nil {
+		log.Fatalf("Invalid notifier configuration: %v", err)
+	}
+	if _, ok := notifier.Default().(notifier.LogNotifier); ok {
+		log.Printf("NOTIFIER=log: emails are written to the log instead of being sent, do not use this in production")
+	}
+
+	// Fail fast on a broken identity provider configuration instead of on the first login
+	if _, err := oidc.Default(); err != nil {
+		log.Fatalf("Invalid OIDC configuration: %v", err)
+	}
+
+	// Close attempts whose time limit has passed even if the student never comes back
+	go finishExpiredAttempts(time.Minute)
+
+	// Forget rate limit buckets that have been idle long enough to be full again
+	go pruneRateLimits(10 * time.Minute)
+
+	// Behind a reverse proxy, PROXY_HEADER (e.g. X-Forwarded-For) gives the client IP used for rate limits and audit logs.
+	// The header is only read from TRUSTED_PROXIES, otherwise any client could pick its own IP.
+	config := fiber.Config{}
+	if header := strings.TrimSpace(os.Getenv("PROXY_HEADER")); header != "" {
+		var proxies []string
+		for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				proxies = append(proxies, proxy)
+			}
+		}
+		if len(proxies) == 0 {
+			log.Fatalf("PROXY_HEADER is set but TRUSTED_PROXIES is empty: list the IPs or CIDR ranges of your reverse proxies")
+		}
+		config.ProxyHeader = header
+		config.EnableTrustedProxyCheck = true
+		config.TrustedProxies = proxies
+		config.EnableIPValidation = true
+	}
+	app := fiber.New(config)
 
 	// Get allowed origins from environment variable or use default
 	allowedOrigins := os.Getenv("ALLOWED_ORIGINS")
@@ -82,3 +143,32 @@ func main() {
 	app.Listen("0.0.0.0:" + port)
 
 }
+
+// finishExpiredAttempts periodically grades attempts that ran out of time
+func finishExpiredAttempts(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		finished, err := database.FinishExpiredAttempts()
+		if err != nil {
+			log.Printf("Error finishing expired attempts: %v", err)
+			continue
+		}
+		if finished > 0 {
+			log.Printf("Finished %d expired attempts", finished)
+		}
+	}
+}
+
+// pruneRateLimits periodically removes rate limit buckets that were not used for an hour
+func pruneRateLimits(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := ratelimit.Default().Prune(time.Now().Add(-time.Hour)); err != nil {
+			log.Printf("Error pruning rate limit buckets: %v", err)
+		}
+	}
+}
@@ -15,7 +15,71 @@ type Users struct {
 	Role           string     `json:"role"`
 	FailedAttempts int        `json:"failed_attempts" gorm:"default:0"`
 	LockedUntil    *time.Time `json:"locked_until"`
+	LockoutCount   int        `json:"lockout_count" gorm:"default:0"` // penguncian sejak login terakhir yang berhasil, untuk backoff
+	LockedByAdmin  bool       `json:"locked_by_admin"`                // dikunci admin, bukan karena gagal login; tidak dibuka oleh reset password
+	// Kosong sampai user membuka link verifikasi yang dikirim ke email
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// Two-factor authentication (TOTP); secret sudah terisi selama pendaftaran belum dikonfirmasi
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+	TOTPSecret       string `json:"-"`
+	TOTPLastStep     int64  `json:"-"` // time step kode terakhir yang dipakai, agar kode yang sama tidak bisa dipakai ulang
 }
+
+// Role bawaan; admin dapat membuat role lain lewat tabel Role
+const (
+	RoleAdmin   = "admin"
+	RoleTeacher = "teacher"
+	RoleStudent = "student"
+)
+
+// Role adalah kumpulan permission yang diberikan ke user lewat Users.Role.
+// Role sistem (admin, teacher, student) tidak bisa dihapus, dan admin selalu memiliki semua permission.
+type Role struct {
+	gorm.Model
+	Name        string       `json:"name" gorm:"uniqueIndex"`
+	Description string       `json:"description"`
+	System      bool         `json:"system"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions;"`
+}
+
+// Permission adalah satu aksi yang bisa diizinkan untuk sebuah role
+type Permission struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Name        string `json:"name" gorm:"uniqueIndex"`
+	Description string `json:"description"`
+}
+
+// Permission yang diperiksa oleh RequirePermission
+const (
+	PermKategoriManage   = "kategori.manage"
+	PermTingkatanManage  = "tingkatan.manage"
+	PermPendidikanManage = "pendidikan.manage"
+	PermKelasCreate      = "kelas.create"
+	PermKelasUpdate      = "kelas.update"
+	PermKelasDelete      = "kelas.delete"
+	PermKelasEnroll      = "kelas.enroll"
+	PermKuisCreate       = "kuis.create"
+	PermKuisUpdate       = "kuis.update"
+	PermKuisDelete       = "kuis.delete"
+	PermKuisReadAll      = "kuis.read_all"
+	PermSoalCreate       = "soal.create"
+	PermSoalUpdate       = "soal.update"
+	PermSoalDelete       = "soal.delete"
+	PermContentManageAny = "content.manage_any" // ubah kelas, kuis dan soal milik siapa pun
+	PermResultsRead      = "results.read"       // hasil kuis siswa di kelas yang diajar
+	PermResultsReadAny   = "results.read_any"   // hasil kuis semua user
+	PermGradingGrade     = "grading.grade"
+	PermUsersManage      = "users.manage"
+	PermRolesManage      = "roles.manage"
+	PermSettingsManage   = "settings.manage"
+	PermAuditRead        = "audit.read"
+)
+
+// EmailVerified reports whether the user has confirmed their email address
+func (u Users) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
 type Kategori_Soal struct {
 	gorm.Model
 	Name        string `json:"name"`
@@ -34,8 +98,48 @@ type Kelas struct {
 	CreatedBy   uint   `json:"created_by"`
 	Creator     Users  `gorm:"foreignKey:CreatedBy;constraint:OnDelete:CASCADE;"`
 }
+
+// KuisSettings berisi aturan pengerjaan kuis yang bisa diatur oleh pembuat kuis
+type KuisSettings struct {
+	TimeLimit     uint   `json:"time_limit"`                           // batas waktu pengerjaan dalam menit, 0 berarti tanpa batas
+	ScoringPolicy string `json:"scoring_policy" gorm:"default:latest"` // attempt yang dipakai sebagai nilai resmi: best, latest, first, average
+	// Pengurangan nilai sebagai pecahan dari poin soal (0-1), misalnya 0.25
+	WrongPenalty float64 `json:"wrong_penalty"` // untuk jawaban salah
+	BlankPenalty float64 `json:"blank_penalty"` // untuk soal yang tidak dijawab
+	// Pengacakan soal, ditetapkan sekali per attempt
+	ShuffleQuestions    bool `json:"shuffle_questions"`
+	ShuffleOptions      bool `json:"shuffle_options"`
+	PoolSize            uint `json:"pool_size"`             // jumlah soal yang diambil acak dari bank soal, 0 berarti semua
+	StratifyByTingkatan bool `json:"stratify_by_tingkatan"` // ambil soal secara proporsional per tingkatan
+	// Jadwal dan aturan pengerjaan
+	OpensAt          *time.Time `json:"opens_at"`                              // kosong berarti langsung dibuka
+	ClosesAt         *time.Time `json:"closes_at"`                             // kosong berarti tidak pernah ditutup
+	MaxAttempts      uint       `json:"max_attempts"`                          // 0 berarti tanpa batas
+	CooldownMinutes  uint       `json:"cooldown_minutes"`                      // jeda minimal antar attempt
+	PassMark         float64    `json:"pass_mark"`                             // persentase minimal untuk lulus, 0 berarti tanpa batas lulus
+	LateSubmission   string     `json:"late_submission" gorm:"default:reject"` // reject, accept, penalize
+	LateGraceMinutes uint       `json:"late_grace_minutes"`                    // berapa lama setelah closes_at jawaban masih diterima
+	LatePenalty      float64    `json:"late_penalty"`                          // pecahan (0-1) dari nilai untuk attempt yang terlambat
+}
+
+// Pilihan KuisSettings.LateSubmission
+const (
+	LateReject   = "reject"
+	LateAccept   = "accept"
+	LatePenalize = "penalize"
+)
+
+// Pilihan KuisSettings.ScoringPolicy
+const (
+	ScoringBest    = "best"
+	ScoringLatest  = "latest"
+	ScoringFirst   = "first"
+	ScoringAverage = "average"
+)
+
 type Kuis struct {
 	gorm.Model
+	KuisSettings  `gorm:"embedded"`
 	Title         string        `json:"title"`
 	Description   string        `json:"description"`
 	IsPrivate     bool          `json:"is_private" gorm:"default:false"`
@@ -49,17 +153,129 @@ type Kuis struct {
 	Pendidikan    Pendidikan    `gorm:"foreignKey:Pendidikan_id;constraint:OnDelete:CASCADE;"`
 	CreatedBy     uint          `json:"created_by"`
 	Creator       Users         `gorm:"foreignKey:CreatedBy;constraint:OnDelete:CASCADE;"`
+	// Kuis dan soalnya adalah draft; siswa hanya melihat versi yang terakhir dipublikasikan
+	Status            string            `json:"status" gorm:"default:published"` // draft, published
+	CurrentVersion_id *uint             `json:"current_version_id"`
+	DraftChanges      bool              `json:"draft_changes"` // ada perubahan yang belum dipublikasikan
+	Availability      *KuisAvailability `json:"availability,omitempty" gorm:"-"`
+}
+
+// SoalCorrection adalah perbaikan kunci jawaban sebuah soal setelah dipublikasikan.
+// Versi kuis tidak diubah; koreksi terakhir dipakai saat attempt dinilai ulang.
+type SoalCorrection struct {
+	gorm.Model
+	Soal_id        uint            `json:"soal_id" gorm:"index"`
+	Correct_answer string          `json:"correct_answer"`
+	AnswerKey      json.RawMessage `json:"answer_key"`
+	Points         float64         `json:"points"`
+	PartialCredit  string          `json:"partial_credit"`
+	CorrectedBy    uint            `json:"corrected_by"`
 }
 
+// Status Kuis
+const (
+	KuisDraft     = "draft"
+	KuisPublished = "published"
+)
+
+// KuisVersion adalah salinan kuis dan soalnya saat dipublikasikan, tidak pernah diubah lagi
+type KuisVersion struct {
+	gorm.Model
+	Kuis_id       uint            `json:"kuis_id" gorm:"index;uniqueIndex:idx_kuis_version_number"`
+	Kuis          Kuis            `json:"-" gorm:"foreignKey:Kuis_id;constraint:OnDelete:CASCADE;"`
+	VersionNumber uint            `json:"version_number" gorm:"uniqueIndex:idx_kuis_version_number"`
+	Title         string          `json:"title"`
+	Description   string          `json:"description"`
+	Soal          json.RawMessage `json:"soal"`     // []Soal lengkap dengan kunci jawaban
+	Settings      json.RawMessage `json:"settings"` // KuisSettings yang berlaku untuk versi ini
+	PublishedBy   uint            `json:"published_by"`
+	PublishedAt   time.Time       `json:"published_at"`
+}
+
+// KuisAvailability menjelaskan apakah user yang login bisa memulai attempt baru
+type KuisAvailability struct {
+	Status        string     `json:"status"` // open, not_open, closed
+	CanAttempt    bool       `json:"can_attempt"`
+	Code          string     `json:"code,omitempty"` // alasan tidak bisa memulai attempt, sama dengan kode error
+	AttemptsUsed  uint       `json:"attempts_used"`
+	AttemptsLeft  *uint      `json:"attempts_left"` // kosong berarti tanpa batas
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// Status KuisAvailability
+const (
+	KuisOpen    = "open"
+	KuisNotOpen = "not_open"
+	KuisClosed  = "closed"
+)
+
+// Tipe soal yang didukung
+const (
+	SoalSingleChoice   = "single_choice"
+	SoalMultipleSelect = "multiple_select"
+	SoalTrueFalse      = "true_false"
+	SoalShortText      = "short_text"
+	SoalNumeric        = "numeric"
+	SoalOrdering       = "ordering"
+	SoalMatching       = "matching"
+	SoalFillBlank      = "fill_blank"
+	SoalEssay          = "essay" // dinilai manual oleh guru
+)
+
+// Aturan nilai sebagian untuk soal multiple_select
+const (
+	PartialCreditNone            = "none"              // semua pilihan harus tepat
+	PartialCreditPerCorrect      = "per_correct"       // nilai per pilihan benar, nol jika ada pilihan salah
+	PartialCreditRightMinusWrong = "right_minus_wrong" // pilihan benar dikurangi pilihan salah, minimal nol
+)
+
 type Soal struct {
 	gorm.Model
 	Question       string          `json:"question"`
+	Type           string          `json:"type" gorm:"default:single_choice"`
 	Options        json.RawMessage `json:"options_json"`
 	Correct_answer string          `json:"correct_answer"`
+	AnswerKey      json.RawMessage `json:"answer_key"` // kunci jawaban terstruktur untuk tipe selain pilihan ganda
+	Points         float64         `json:"points" gorm:"default:1"`
+	PartialCredit  string          `json:"partial_credit"` // aturan nilai sebagian untuk multiple_select
+	Tingkatan_id   *uint           `json:"tingkatan_id"`   // opsional, untuk pengambilan soal per tingkatan
+	Tingkatan      *Tingkatan      `json:"-" gorm:"foreignKey:Tingkatan_id;constraint:OnDelete:SET NULL;"`
 	Kuis_id        uint            `json:"kuis_id"`
 	Kuis           Kuis            `gorm:"foreignKey:Kuis_id;constraint:OnDelete:CASCADE;"`
 }
 
+// QuestionType mengembalikan tipe soal; baris lama tanpa tipe dibaca sebagai pilihan ganda
+func (s Soal) QuestionType() string {
+	if s.Type == "" {
+		return SoalSingleChoice
+	}
+	return s.Type
+}
+
+// SoalDelivery adalah tampilan soal untuk siswa: tanpa kunci jawaban dan field internal
+type SoalDelivery struct {
+	ID       uint            `json:"id"`
+	Question string          `json:"question"`
+	Type     string          `json:"type"`
+	Options  json.RawMessage `json:"options_json"`
+	Points   float64         `json:"points"`
+	Kuis_id  uint            `json:"kuis_id"`
+	// Jawaban yang sudah disimpan dalam attempt, memakai label pilihan yang ditampilkan
+	SavedAnswer *string `json:"saved_answer,omitempty"`
+}
+
+// Delivery mengubah Soal menjadi tampilan untuk siswa
+func (s Soal) Delivery() SoalDelivery {
+	return SoalDelivery{
+		ID:       s.ID,
+		Question: s.Question,
+		Type:     s.QuestionType(),
+		Options:  s.Options,
+		Points:   s.Points,
+		Kuis_id:  s.Kuis_id,
+	}
+}
+
 type Pendidikan struct {
 	gorm.Model
 	Name        string `json:"name"`
@@ -67,21 +283,96 @@ type Pendidikan struct {
 }
 type Hasil_Kuis struct {
 	gorm.Model
-	Users_id       uint  `json:"users_id"`
-	Users          Users `gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
-	Kuis_id        uint  `json:"kuis_id"`
-	Kuis           Kuis  `gorm:"foreignKey:Kuis_id;constraint:OnDelete:CASCADE;"`
-	Score          uint  `json:"score"`
-	Correct_Answer uint  `json:"correct_answer"`
+	Users_id       uint    `json:"users_id"`
+	Users          Users   `gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
+	Kuis_id        uint    `json:"kuis_id"`
+	Kuis           Kuis    `gorm:"foreignKey:Kuis_id;constraint:OnDelete:CASCADE;"`
+	Score          uint    `json:"score"`
+	Correct_Answer uint    `json:"correct_answer"`
+	RawPoints      float64 `json:"raw_points"`
+	MaxPoints      float64 `json:"max_points"`
+	Percentage     float64 `json:"percentage"`
+	// Nilai resmi dihitung ulang dari semua attempt sesuai ScoringPolicy kuis
+	ScoringPolicy string        `json:"scoring_policy"`
+	Attempt_id    *uint         `json:"attempt_id"` // attempt yang menjadi nilai resmi, kosong untuk average
+	AttemptCount  uint          `json:"attempt_count"`
+	ReviewStatus  string        `json:"review_status" gorm:"default:final"` // pending_review selama masih ada essay yang belum dinilai
+	Passed        *bool         `json:"passed"`                             // kosong jika kuis tidak punya pass_mark
+	Attempts      []KuisAttempt `json:"attempts,omitempty" gorm:"-"`
 }
+
+// Status penilaian manual pada KuisAttempt dan Hasil_Kuis
+const (
+	ReviewFinal   = "final"
+	ReviewPending = "pending_review"
+)
+
 type SoalAnswer struct {
 	gorm.Model
-	Soal_id uint   `json:"soal_id"`
-	Soal    Soal   `gorm:"foreignKey:Soal_id;constraint:OnDelete:CASCADE;"`
-	Answer  string `json:"answer"`
-	User_id uint   `json:"user_id"`
-	User    Users  `gorm:"foreignKey:User_id;constraint:OnDelete:CASCADE;"`
+	Soal_id    uint        `json:"soal_id"`
+	Soal       Soal        `gorm:"foreignKey:Soal_id;constraint:OnDelete:CASCADE;"`
+	Answer     string      `json:"answer"`
+	User_id    uint        `json:"user_id"`
+	User       Users       `gorm:"foreignKey:User_id;constraint:OnDelete:CASCADE;"`
+	Attempt_id *uint       `json:"attempt_id" gorm:"index"`
+	Attempt    KuisAttempt `gorm:"foreignKey:Attempt_id;constraint:OnDelete:CASCADE;"`
+	// Diisi guru saat menilai soal essay secara manual
+	AwardedPoints *float64   `json:"awarded_points"`
+	Feedback      string     `json:"feedback"`
+	GradedBy      *uint      `json:"graded_by"`
+	GradedAt      *time.Time `json:"graded_at"`
 }
+
+// Status pengerjaan KuisAttempt
+const (
+	AttemptInProgress = "in_progress"
+	AttemptFinished   = "finished"
+	AttemptExpired    = "expired"
+)
+
+// KuisAttempt mencatat satu sesi pengerjaan kuis oleh seorang user
+type KuisAttempt struct {
+	gorm.Model
+	Users_id         uint            `json:"users_id" gorm:"index;uniqueIndex:idx_kuis_attempt_number"`
+	Users            Users           `gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
+	Kuis_id          uint            `json:"kuis_id" gorm:"index;uniqueIndex:idx_kuis_attempt_number"`
+	Kuis             Kuis            `gorm:"foreignKey:Kuis_id;constraint:OnDelete:CASCADE;"`
+	AttemptNumber    uint            `json:"attempt_number" gorm:"uniqueIndex:idx_kuis_attempt_number"`
+	Status           string          `json:"status" gorm:"default:in_progress;index"`
+	StartedAt        time.Time       `json:"started_at"`
+	ExpiresAt        *time.Time      `json:"expires_at"`
+	FinishedAt       *time.Time      `json:"finished_at"`
+	Score            uint            `json:"score"`
+	Correct_Answer   uint            `json:"correct_answer"`
+	RawPoints        float64         `json:"raw_points"`
+	MaxPoints        float64         `json:"max_points"`
+	Percentage       float64         `json:"percentage"`
+	ReviewStatus     string          `json:"review_status" gorm:"default:final"`
+	KuisVersion_id   *uint           `json:"kuis_version_id" gorm:"index"` // versi kuis yang dikerjakan
+	Late             bool            `json:"late"`                         // diselesaikan setelah closes_at kuis
+	Passed           *bool           `json:"passed"`
+	Layout           json.RawMessage `json:"-"` // []AttemptSoalLayout, urutan soal dan pilihan untuk attempt ini
+	RemainingSeconds *int64          `json:"remaining_seconds,omitempty" gorm:"-"`
+	Answers          []SoalAnswer    `json:"answers,omitempty" gorm:"-"`
+}
+
+// AttemptSoalLayout adalah posisi satu soal dalam attempt beserta pengacakan pilihannya
+type AttemptSoalLayout struct {
+	Soal_id   uint              `json:"soal_id"`
+	OptionMap map[string]string `json:"option_map,omitempty"` // label yang ditampilkan -> kunci pilihan asli
+}
+
+// KelasTeacher adalah guru pendamping (co-teacher) sebuah kelas. Bersama pembuat kelas,
+// co-teacher boleh mengubah kelas serta kuis dan soal di dalamnya.
+type KelasTeacher struct {
+	gorm.Model
+	Kelas_id uint  `json:"kelas_id" gorm:"uniqueIndex:idx_kelas_teacher"`
+	Kelas    Kelas `json:"-" gorm:"foreignKey:Kelas_id;constraint:OnDelete:CASCADE;"`
+	Users_id uint  `json:"users_id" gorm:"uniqueIndex:idx_kelas_teacher"`
+	Users    Users `json:"user" gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
+	AddedBy  uint  `json:"added_by"`
+}
+
 type Kelas_Pengguna struct {
 	gorm.Model
 	Users_id uint  `json:"users_id"`
@@ -90,6 +381,161 @@ type Kelas_Pengguna struct {
 	Kelas    Kelas `gorm:"foreignKey:Kelas_id;constraint:OnDelete:CASCADE;"`
 }
 
+// Session adalah satu login di satu perangkat; refresh token disimpan sebagai hash
+type Session struct {
+	gorm.Model
+	Users_id            uint       `json:"users_id" gorm:"index"`
+	Users               Users      `json:"-" gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
+	RefreshTokenHash    string     `json:"-" gorm:"uniqueIndex"`
+	PreviousRefreshHash string     `json:"-" gorm:"index"` // token sebelum rotasi, untuk mendeteksi token yang dipakai ulang
+	Device              string     `json:"device"`
+	IPAddress           string     `json:"ip_address"`
+	LastUsedAt          time.Time  `json:"last_used_at"`
+	ExpiresAt           time.Time  `json:"expires_at"`
+	RevokedAt           *time.Time `json:"revoked_at"`
+	RevokedReason       string     `json:"revoked_reason,omitempty"`
+	Current             bool       `json:"current" gorm:"-"`
+}
+
+// PasswordResetToken adalah token reset password sekali pakai; yang disimpan hanya hash-nya
+type PasswordResetToken struct {
+	gorm.Model
+	Users_id  uint       `json:"users_id" gorm:"index"`
+	Users     Users      `json:"-" gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RequestIP string     `json:"request_ip"`
+}
+
+// EmailVerificationToken adalah token verifikasi email sekali pakai; yang disimpan hanya hash-nya
+type EmailVerificationToken struct {
+	gorm.Model
+	Users_id  uint       `json:"users_id" gorm:"index"`
+	Users     Users      `json:"-" gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// RecoveryCode adalah kode cadangan 2FA sekali pakai; yang disimpan hanya hash-nya
+type RecoveryCode struct {
+	gorm.Model
+	Users_id uint       `json:"users_id" gorm:"index"`
+	Users    Users      `json:"-" gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
+	CodeHash string     `json:"-" gorm:"index"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+// Tujuan LoginChallenge
+const (
+	ChallengeVerify = "verify" // user sudah punya 2FA dan harus memasukkan kode
+	ChallengeEnroll = "enroll" // role user mewajibkan 2FA yang belum didaftarkan
+)
+
+// LoginChallenge adalah langkah kedua login setelah password benar; JWT baru diberikan setelah kode dicek
+type LoginChallenge struct {
+	gorm.Model
+	Users_id       uint       `json:"users_id" gorm:"index"`
+	Users          Users      `json:"-" gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
+	TokenHash      string     `json:"-" gorm:"uniqueIndex"`
+	Purpose        string     `json:"purpose"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	FailedAttempts int        `json:"failed_attempts"`
+	UsedAt         *time.Time `json:"used_at"`
+}
+
+// OIDCLoginState menyimpan state, nonce dan PKCE verifier sebuah login OIDC sampai provider
+// mengarahkan user kembali; hanya bisa dipakai sekali
+type OIDCLoginState struct {
+	gorm.Model
+	StateHash    string     `json:"-" gorm:"uniqueIndex"`
+	Provider     string     `json:"provider"`
+	Nonce        string     `json:"-"`
+	CodeVerifier string     `json:"-"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	UsedAt       *time.Time `json:"used_at"`
+}
+
+// UserIdentity menghubungkan akun di identity provider (provider + subject) dengan user
+type UserIdentity struct {
+	gorm.Model
+	Users_id    uint       `json:"users_id" gorm:"index"`
+	Users       Users      `json:"-" gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
+	Provider    string     `json:"provider" gorm:"uniqueIndex:idx_identity_subject"`
+	Subject     string     `json:"-" gorm:"uniqueIndex:idx_identity_subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// Scope APIKey
+const (
+	ScopeResultsRead = "results:read"
+	ScopeSoalManage  = "soal:manage"
+	ScopeKuisManage  = "kuis:manage"
+)
+
+// APIKey adalah kredensial pribadi untuk akses lewat script; yang disimpan hanya hash-nya
+type APIKey struct {
+	gorm.Model
+	Users_id   uint            `json:"users_id" gorm:"index"`
+	Users      Users           `json:"-" gorm:"foreignKey:Users_id;constraint:OnDelete:CASCADE;"`
+	Name       string          `json:"name"`
+	Prefix     string          `json:"prefix"` // awal key, untuk mengenali key tanpa menyimpan key-nya
+	KeyHash    string          `json:"-" gorm:"uniqueIndex"`
+	Scopes     json.RawMessage `json:"scopes"` // []string
+	ExpiresAt  *time.Time      `json:"expires_at"`
+	LastUsedAt *time.Time      `json:"last_used_at"`
+	RevokedAt  *time.Time      `json:"revoked_at"`
+}
+
+// ScopeList returns the scopes granted to the key
+func (k APIKey) ScopeList() []string {
+	var scopes []string
+	json.Unmarshal(k.Scopes, &scopes)
+	return scopes
+}
+
+// HasScope reports whether the key grants scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, granted := range k.ScopeList() {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Invitation memberi role teacher atau admin kepada orang yang mendaftar memakai token-nya
+type Invitation struct {
+	gorm.Model
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	Role      string     `json:"role"`
+	Email     string     `json:"email"` // kosong berarti bisa dipakai email apa saja
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedBy uint       `json:"created_by"`
+	Creator   Users      `json:"-" gorm:"foreignKey:CreatedBy;constraint:OnDelete:CASCADE;"`
+	UsedAt    *time.Time `json:"used_at"`
+	UsedBy    *uint      `json:"used_by"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// Setting menyimpan konfigurasi aplikasi yang bisa diubah admin tanpa deploy ulang
+type Setting struct {
+	gorm.Model
+	Key       string          `json:"key" gorm:"uniqueIndex"`
+	Value     json.RawMessage `json:"value"`
+	UpdatedBy uint            `json:"updated_by"`
+}
+
+// RateLimitBucket adalah token bucket rate limit yang dipakai bersama oleh semua instance
+// (RATE_LIMIT_STORE=database)
+type RateLimitBucket struct {
+	Key      string    `gorm:"primaryKey"`
+	Tokens   float64   `gorm:"not null;default:0"`
+	LastSeen time.Time `gorm:"index"`
+}
+
 type AuditLog struct {
 	gorm.Model
 	UserID    uint   `json:"user_id"`
@@ -0,0 +1,132 @@
+// Package notifier mengirim pesan ke pengguna (misalnya link reset password)
+// lewat SMTP atau, untuk development, ke log/file lokal.
+package notifier
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is one notification to a user
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users
+type Notifier interface {
+	Send(msg Message) error
+}
+
+var (
+	defaultNotifier Notifier
+	once            sync.Once
+)
+
+// Default returns the notifier configured by the NOTIFIER environment variable:
+// "smtp" (the default) uses SMTPNotifier, "log" writes to NOTIFY_LOG_FILE or the application log
+func Default() Notifier {
+	once.Do(func() {
+		if strings.EqualFold(os.Getenv("NOTIFIER"), "log") {
+			defaultNotifier = LogNotifier{Path: os.Getenv("NOTIFY_LOG_FILE")}
+		} else {
+			defaultNotifier = SMTPFromEnv()
+		}
+	})
+	return defaultNotifier
+}
+
+// Check returns an error if NOTIFIER cannot deliver messages, so the app refuses to start
+// instead of losing reset and verification links
+func Check() error {
+	switch strings.ToLower(os.Getenv("NOTIFIER")) {
+	case "", "smtp":
+		if n := SMTPFromEnv(); n.Host == "" || n.From == "" {
+			return fmt.Errorf("the smtp notifier needs SMTP_HOST and SMTP_FROM; set NOTIFIER=log only for local development")
+		}
+	case "log":
+	default:
+		return fmt.Errorf("unknown NOTIFIER %q, expected smtp or log", os.Getenv("NOTIFIER"))
+	}
+	return nil
+}
+
+// SMTPNotifier sends messages as plain text email
+type SMTPNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPFromEnv builds an SMTPNotifier from SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+func SMTPFromEnv() SMTPNotifier {
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return SMTPNotifier{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+// Send delivers the message through the SMTP server
+func (n SMTPNotifier) Send(msg Message) error {
+	if n.Host == "" || n.From == "" {
+		return fmt.Errorf("smtp notifier is not configured")
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	body := "From: " + n.From + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + msg.Body + "\r\n"
+
+	if err := smtp.SendMail(n.Host+":"+n.Port, auth, n.From, []string{msg.To}, []byte(body)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// LogNotifier appends messages to a file, or to the application log when Path is empty
+type LogNotifier struct {
+	Path string
+}
+
+// Send writes the message to the file or log
+func (n LogNotifier) Send(msg Message) error {
+	entry := fmt.Sprintf("[%s] to=%s subject=%q\n%s\n\n", time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+
+	if n.Path == "" {
+		log.Print("notification " + entry)
+		return nil
+	}
+
+	file, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open notification log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(entry); err != nil {
+		return fmt.Errorf("failed to write notification log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,461 @@
+// Package oidc implements login with OpenID Connect identity providers using the
+// authorization code flow with PKCE (RFC 7636). Provider endpoints and signing keys are
+// read from the issuer's discovery document, so any compliant issuer works, including a
+// local mock issuer over plain http during development.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Errors returned while verifying a login
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrUnknownKey     = errors.New("ID token is signed with an unknown key")
+)
+
+// How long discovery documents and signing keys are reused before they are fetched again
+const (
+	discoveryTTL   = time.Hour
+	keysTTL        = time.Hour
+	keysMinRefresh = time.Minute // unknown kid triggers at most one refresh per minute
+	clockSkew      = time.Minute
+)
+
+// RoleRule maps a value of the role claim to a role of the application
+type RoleRule struct {
+	Value string
+	Role  string
+}
+
+// Provider is one configured identity provider
+type Provider struct {
+	Name           string
+	Issuer         string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string
+	Scopes         []string
+	RoleClaim      string     // dotted path in the ID token, e.g. "groups" or "realm_access.roles"
+	RoleMap        []RoleRule // checked in order, the first matching rule wins
+	AllowedDomains []string   // if set, only emails in these domains may log in
+	Client         *http.Client
+
+	mu           sync.Mutex
+	metadata     *metadata
+	metadataAt   time.Time
+	keys         map[string]interface{}
+	keysAt       time.Time
+	keysAttempts time.Time
+}
+
+// metadata is the part of the discovery document the login flow needs
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the verified claims of an ID token
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Raw           jwt.MapClaims
+}
+
+// FromEnv reads the providers listed in OIDC_PROVIDERS (comma separated names). Each provider
+// NAME is configured with OIDC_NAME_ISSUER, OIDC_NAME_CLIENT_ID, OIDC_NAME_CLIENT_SECRET,
+// OIDC_NAME_REDIRECT_URL and optionally OIDC_NAME_SCOPES, OIDC_NAME_ROLE_CLAIM,
+// OIDC_NAME_ROLE_MAP ("value=role,...") and OIDC_NAME_ALLOWED_DOMAINS.
+func FromEnv() (map[string]*Provider, error) {
+	providers := make(map[string]*Provider)
+	for _, name := range splitList(os.Getenv("OIDC_PROVIDERS")) {
+		name = strings.ToLower(name)
+		env := func(key string) string {
+			return strings.TrimSpace(os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key))
+		}
+
+		provider := &Provider{
+			Name:           name,
+			Issuer:         strings.TrimSuffix(env("ISSUER"), "/"),
+			ClientID:       env("CLIENT_ID"),
+			ClientSecret:   env("CLIENT_SECRET"),
+			RedirectURL:    env("REDIRECT_URL"),
+			Scopes:         strings.Fields(env("SCOPES")),
+			RoleClaim:      env("ROLE_CLAIM"),
+			AllowedDomains: splitList(strings.ToLower(env("ALLOWED_DOMAINS"))),
+			Client:         &http.Client{Timeout: 10 * time.Second},
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+		if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			return nil, fmt.Errorf("oidc provider %s needs ISSUER, CLIENT_ID and REDIRECT_URL", name)
+		}
+		for _, rule := range splitList(env("ROLE_MAP")) {
+			value, role, found := strings.Cut(rule, "=")
+			if !found || value == "" || role == "" {
+				return nil, fmt.Errorf("oidc provider %s has an invalid role mapping %q, expected value=role", name, rule)
+			}
+			provider.RoleMap = append(provider.RoleMap, RoleRule{Value: strings.TrimSpace(value), Role: strings.TrimSpace(role)})
+		}
+		providers[name] = provider
+	}
+	return providers, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Names returns the provider names in alphabetical order
+func Names(providers map[string]*Provider) []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewVerifier returns a random PKCE code verifier; it is also used for state and nonce values
+func NewVerifier() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Challenge returns the S256 PKCE code challenge of a verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL of the provider's login page for a new login
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return meta.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for tokens and returns the verified claims of the ID token
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.doJSON(req, &token); err != nil {
+		if token.Error != "" {
+			return Claims{}, fmt.Errorf("token request rejected: %s %s", token.Error, token.ErrorDescription)
+		}
+		return Claims{}, err
+	}
+	if token.IDToken == "" {
+		return Claims{}, fmt.Errorf("%w: token response has no id_token", ErrInvalidIDToken)
+	}
+
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, lifetime and nonce of an ID token
+func (p *Provider) Verify(ctx context.Context, rawIDToken string, nonce string) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	parser := jwt.Parser{ValidMethods: []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}}
+	token, err := parser.ParseWithClaims(rawIDToken, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, meta, kid)
+	})
+	if err != nil {
+		// Sedikit toleransi jam antar server untuk token yang baru saja dibuat
+		var validation *jwt.ValidationError
+		if !errors.As(err, &validation) || validation.Errors != jwt.ValidationErrorIssuedAt || token == nil {
+			return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+		}
+	}
+	claims := token.Claims.(jwt.MapClaims)
+
+	now := time.Now()
+	if !claims.VerifyIssuer(meta.Issuer, true) {
+		return Claims{}, fmt.Errorf("%w: unexpected issuer", ErrInvalidIDToken)
+	}
+	if !audienceContains(claims["aud"], p.ClientID) {
+		return Claims{}, fmt.Errorf("%w: token was issued for another client", ErrInvalidIDToken)
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.ClientID {
+		return Claims{}, fmt.Errorf("%w: token was issued for another client", ErrInvalidIDToken)
+	}
+	if !claims.VerifyExpiresAt(now.Add(-clockSkew).Unix(), true) {
+		return Claims{}, fmt.Errorf("%w: token has expired", ErrInvalidIDToken)
+	}
+	if !claims.VerifyIssuedAt(now.Add(clockSkew).Unix(), false) {
+		return Claims{}, fmt.Errorf("%w: token is issued in the future", ErrInvalidIDToken)
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+
+	result := Claims{Raw: claims}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.Email = strings.ToLower(strings.TrimSpace(result.Email))
+	result.Name, _ = claims["name"].(string)
+	// Beberapa provider mengirim email_verified sebagai string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+	if result.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: token has no subject", ErrInvalidIDToken)
+	}
+	return result, nil
+}
+
+// audienceContains reports whether the aud claim, a string or a list, contains the client ID
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, value := range aud {
+			if value == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EmailAllowed reports whether the email belongs to one of the allowed domains, if any are configured
+func (p *Provider) EmailAllowed(email string) bool {
+	if len(p.AllowedDomains) == 0 {
+		return true
+	}
+	_, domain, found := strings.Cut(email, "@")
+	if !found {
+		return false
+	}
+	for _, allowed := range p.AllowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// MappedRole returns the application role for the claims, or "" when no rule matches
+func (p *Provider) MappedRole(claims Claims) string {
+	if p.RoleClaim == "" || len(p.RoleMap) == 0 {
+		return ""
+	}
+
+	var value interface{} = map[string]interface{}(claims.Raw)
+	for _, part := range strings.Split(p.RoleClaim, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[part]
+	}
+
+	values := make(map[string]bool)
+	switch value := value.(type) {
+	case string:
+		values[value] = true
+	case []interface{}:
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				values[text] = true
+			}
+		}
+	}
+
+	for _, rule := range p.RoleMap {
+		if values[rule.Value] {
+			return rule.Role
+		}
+	}
+	return ""
+}
+
+// discover returns the discovery document of the issuer, fetching it at most once per hour
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil && time.Since(p.metadataAt) < discoveryTTL {
+		return p.metadata, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build discovery request: %w", err)
+	}
+	var meta metadata
+	if err := p.doJSON(req, &meta); err != nil {
+		return nil, fmt.Errorf("failed to discover %s: %w", p.Name, err)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery document of %s is for issuer %q", p.Name, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s is missing endpoints", p.Name)
+	}
+
+	p.metadata, p.metadataAt = &meta, time.Now()
+	return p.metadata, nil
+}
+
+// key returns the signing key with the given kid, fetching the key set when it is unknown or stale
+func (p *Provider) key(ctx context.Context, meta *metadata, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok && time.Since(p.keysAt) < keysTTL {
+		return key, nil
+	}
+	if time.Since(p.keysAttempts) < keysMinRefresh && time.Since(p.keysAt) < keysTTL {
+		return nil, ErrUnknownKey
+	}
+	p.keysAttempts = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build key set request: %w", err)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.doJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys of %s: %w", p.Name, err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	p.keys, p.keysAt = keys, time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// doJSON sends a request and decodes a JSON response; error responses are decoded too
+func (p *Provider) doJSON(req *http.Request, dest interface{}) error {
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, dest); err != nil && resp.StatusCode == http.StatusOK {
+		return fmt.Errorf("invalid response from %s: %w", req.URL.Host, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %d", req.URL.Host, resp.StatusCode)
+	}
+	return nil
+}
+
+var (
+	defaultProviders map[string]*Provider
+	defaultErr       error
+	once             sync.Once
+)
+
+// Default returns the providers configured in the environment, read once per process
+func Default() (map[string]*Provider, error) {
+	once.Do(func() {
+		defaultProviders, defaultErr = FromEnv()
+	})
+	return defaultProviders, defaultErr
+}
@@ -0,0 +1,280 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/oidc/oidctest"
+	"github.com/golang-jwt/jwt"
+)
+
+const testClientID = "brainquiz"
+
+// newTestProvider starts a mock issuer and a provider configured for it
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Issuer) {
+	t.Helper()
+
+	issuer, err := oidctest.NewIssuer(testClientID)
+	if err != nil {
+		t.Fatalf("start mock issuer: %v", err)
+	}
+	t.Cleanup(issuer.Close)
+
+	provider := &Provider{
+		Name:        "mock",
+		Issuer:      issuer.URL(),
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost:5173/oidc/callback",
+		Scopes:      []string{"openid", "email", "profile"},
+		Client:      issuer.Server.Client(),
+	}
+	return provider, issuer
+}
+
+// testLogin runs the whole authorization code flow; edit changes the ID token the issuer returns
+func testLogin(t *testing.T, provider *Provider, issuer *oidctest.Issuer, edit func(claims jwt.MapClaims)) (Claims, error) {
+	t.Helper()
+
+	nonce, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, state, err := issuer.Authorize(authURL, edit)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	if state != "state" {
+		t.Fatalf("state %q was not passed through", state)
+	}
+
+	return provider.Exchange(context.Background(), code, verifier, nonce)
+}
+
+func TestExchange(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	claims, err := testLogin(t, provider, issuer, func(claims jwt.MapClaims) {
+		claims["email"] = " Guru@Sekolah.Test "
+		claims["name"] = "Bu Guru"
+	})
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject == "" || claims.Name != "Bu Guru" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if claims.Email != "guru@sekolah.test" {
+		t.Errorf("email %q is not normalized", claims.Email)
+	}
+	if !claims.EmailVerified {
+		t.Error("email_verified was not read")
+	}
+}
+
+func TestExchangeRejectsInvalidIDTokens(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	cases := []struct {
+		name string
+		edit func(claims jwt.MapClaims)
+	}{
+		{"other nonce", func(claims jwt.MapClaims) { claims["nonce"] = "replayed" }},
+		{"missing nonce", func(claims jwt.MapClaims) { delete(claims, "nonce") }},
+		{"other issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.test" }},
+		{"other audience", func(claims jwt.MapClaims) { claims["aud"] = "another-client" }},
+		{"audience list without client", func(claims jwt.MapClaims) { claims["aud"] = []string{"a", "b"} }},
+		{"other authorized party", func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testClientID, "another-client"}
+			claims["azp"] = "another-client"
+		}},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"missing expiry", func(claims jwt.MapClaims) { delete(claims, "exp") }},
+		{"issued in the future", func(claims jwt.MapClaims) { claims["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{"missing subject", func(claims jwt.MapClaims) { delete(claims, "sub") }},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := testLogin(t, provider, issuer, tc.edit)
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("got %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestExchangeAcceptsAudienceList(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	_, err := testLogin(t, provider, issuer, func(claims jwt.MapClaims) {
+		claims["aud"] = []string{"another-client", testClientID}
+		claims["azp"] = testClientID
+	})
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+}
+
+func TestExchangeToleratesClockSkew(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	_, err := testLogin(t, provider, issuer, func(claims jwt.MapClaims) {
+		claims["iat"] = time.Now().Add(20 * time.Second).Unix()
+	})
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+}
+
+func TestExchangeRequiresPKCEVerifier(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	nonce, _ := NewVerifier()
+	verifier, _ := NewVerifier()
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	if !strings.Contains(authURL, "code_challenge="+Challenge(verifier)) {
+		t.Fatalf("authorization URL %s does not carry the S256 challenge", authURL)
+	}
+
+	code, _, err := issuer.Authorize(authURL, nil)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+
+	other, _ := NewVerifier()
+	if _, err := provider.Exchange(context.Background(), code, other, nonce); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("exchange with another verifier: got %v, want invalid_grant", err)
+	}
+
+	// The code was spent by the failed attempt and cannot be replayed with the right verifier
+	if _, err := provider.Exchange(context.Background(), code, verifier, nonce); err == nil {
+		t.Fatal("exchange of a used code succeeded")
+	}
+}
+
+func TestVerifyRejectsUnknownKey(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kid := range []string{"unknown-key", issuer.KeyID} {
+		token, err := oidctest.SignWith(key, kid, issuer.Claims("student", "nonce"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := provider.Verify(context.Background(), token, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("token signed with another key as %q: got %v, want ErrInvalidIDToken", kid, err)
+		}
+	}
+}
+
+func TestVerifyRejectsUnsignedToken(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, issuer.Claims("student", "nonce")).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Verify(context.Background(), token, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("got %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestVerifyEmailVerified(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+
+	cases := []struct {
+		value interface{}
+		want  bool
+	}{
+		{true, true},
+		{"true", true},
+		{false, false},
+		{"false", false},
+		{nil, false},
+	}
+
+	for _, tc := range cases {
+		claims := issuer.Claims("student", "nonce")
+		if tc.value == nil {
+			delete(claims, "email_verified")
+		} else {
+			claims["email_verified"] = tc.value
+		}
+		token, err := issuer.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verified, err := provider.Verify(context.Background(), token, "nonce")
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if verified.EmailVerified != tc.want {
+			t.Errorf("email_verified %v: got %v, want %v", tc.value, verified.EmailVerified, tc.want)
+		}
+	}
+}
+
+func TestMappedRole(t *testing.T) {
+	provider := &Provider{
+		RoleClaim: "realm_access.roles",
+		RoleMap:   []RoleRule{{Value: "staff", Role: "admin"}, {Value: "guru", Role: "teacher"}},
+	}
+
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   string
+	}{
+		{"nested list", jwt.MapClaims{"realm_access": map[string]interface{}{"roles": []interface{}{"siswa", "guru"}}}, "teacher"},
+		{"first rule wins", jwt.MapClaims{"realm_access": map[string]interface{}{"roles": []interface{}{"guru", "staff"}}}, "admin"},
+		{"string value", jwt.MapClaims{"realm_access": map[string]interface{}{"roles": "guru"}}, "teacher"},
+		{"no match", jwt.MapClaims{"realm_access": map[string]interface{}{"roles": []interface{}{"siswa"}}}, ""},
+		{"missing claim", jwt.MapClaims{}, ""},
+		{"claim is not an object", jwt.MapClaims{"realm_access": "guru"}, ""},
+	}
+
+	for _, tc := range cases {
+		if got := provider.MappedRole(Claims{Raw: tc.claims}); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	if got := (&Provider{}).MappedRole(Claims{Raw: jwt.MapClaims{"groups": "guru"}}); got != "" {
+		t.Errorf("provider without role mapping: got %q", got)
+	}
+}
+
+func TestEmailAllowed(t *testing.T) {
+	provider := &Provider{AllowedDomains: []string{"sekolah.test"}}
+
+	if !provider.EmailAllowed("guru@sekolah.test") {
+		t.Error("email in allowed domain was refused")
+	}
+	for _, email := range []string{"guru@other.test", "guru@sub.sekolah.test", "sekolah.test"} {
+		if provider.EmailAllowed(email) {
+			t.Errorf("%s was allowed", email)
+		}
+	}
+}
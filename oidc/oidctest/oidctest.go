@@ -0,0 +1,189 @@
+// Package oidctest runs a mock OpenID Connect issuer for tests. It serves a discovery document,
+// a JWKS and a token endpoint that only hands out an ID token for a known authorization code
+// together with the PKCE verifier of that login.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Issuer is a running mock issuer
+type Issuer struct {
+	Server   *httptest.Server
+	ClientID string
+	Key      *rsa.PrivateKey
+	KeyID    string
+
+	mu     sync.Mutex
+	codes  map[string]grant
+	logins int
+}
+
+// grant is an authorization code waiting to be exchanged
+type grant struct {
+	challenge string
+	idToken   string
+}
+
+// NewIssuer starts a mock issuer for the client; call Close when done
+func NewIssuer(clientID string) (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate issuer key: %w", err)
+	}
+
+	issuer := &Issuer{ClientID: clientID, Key: key, KeyID: "mock-key", codes: make(map[string]grant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/jwks", issuer.jwks)
+	mux.HandleFunc("/token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	return issuer, nil
+}
+
+// URL is the issuer identifier
+func (i *Issuer) URL() string {
+	return i.Server.URL
+}
+
+// Close stops the issuer
+func (i *Issuer) Close() {
+	i.Server.Close()
+}
+
+// Claims returns the claims of a valid ID token for a login with the nonce
+func (i *Issuer) Claims(subject string, nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            i.URL(),
+		"aud":            i.ClientID,
+		"sub":            subject,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"email":          subject + "@mock.test",
+		"email_verified": true,
+		"name":           subject,
+	}
+}
+
+// Sign signs the claims with the issuer key
+func (i *Issuer) Sign(claims jwt.MapClaims) (string, error) {
+	return SignWith(i.Key, i.KeyID, claims)
+}
+
+// SignWith signs the claims with any RSA key, e.g. one the issuer does not publish
+func SignWith(key *rsa.PrivateKey, kid string, claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// Authorize plays the user logging in at the authorization endpoint. It reads the nonce and PKCE
+// challenge from the authorization URL, lets edit change the ID token claims (edit may be nil) and
+// returns the code and state the issuer would send to the redirect URL.
+func (i *Issuer) Authorize(authURL string, edit func(claims jwt.MapClaims)) (code string, state string, err error) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != i.ClientID || query.Get("response_type") != "code" {
+		return "", "", errors.New("authorization request is not for this client")
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		return "", "", errors.New("authorization request has no S256 code challenge")
+	}
+
+	i.mu.Lock()
+	i.logins++
+	subject := fmt.Sprintf("mock-user-%d-%d", time.Now().UnixNano(), i.logins)
+	i.mu.Unlock()
+
+	claims := i.Claims(subject, query.Get("nonce"))
+	if edit != nil {
+		edit(claims)
+	}
+	idToken, err := i.Sign(claims)
+	if err != nil {
+		return "", "", err
+	}
+
+	code = fmt.Sprintf("code-%s", subject)
+	i.mu.Lock()
+	i.codes[code] = grant{challenge: query.Get("code_challenge"), idToken: idToken}
+	i.mu.Unlock()
+
+	return code, query.Get("state"), nil
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 i.URL(),
+		"authorization_endpoint": i.URL() + "/authorize",
+		"token_endpoint":         i.URL() + "/token",
+		"jwks_uri":               i.URL() + "/jwks",
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	encode := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.Bytes())
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": i.KeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encode(i.Key.N),
+			"e":   encode(big.NewInt(int64(i.Key.E))),
+		}},
+	})
+}
+
+// token exchanges a code once, and only with the verifier whose S256 challenge was sent to /authorize
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("client_id") != i.ClientID {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	i.mu.Lock()
+	code := r.PostForm.Get("code")
+	login, ok := i.codes[code]
+	delete(i.codes, code)
+	i.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != login.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown code or wrong code_verifier"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": "mock", "token_type": "Bearer", "id_token": login.idToken})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,216 @@
+// Package passwordpolicy berisi aturan password dan lockout login: panjang minimum, jenis karakter,
+// daftar password umum yang ditolak, batas percobaan login, dan cost bcrypt.
+package passwordpolicy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxLength is the longest password bcrypt can hash
+const maxLength = 72
+
+// Policy is the password and lockout configuration
+type Policy struct {
+	MinLength     int  `json:"min_length"`
+	RequireUpper  bool `json:"require_upper"`
+	RequireLower  bool `json:"require_lower"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+	UseBlocklist  bool `json:"use_blocklist"` // tolak password yang ada di PASSWORD_BLOCKLIST_FILE
+	// Akun dikunci setelah LockoutThreshold login gagal berturut-turut. Dengan LockoutBackoff,
+	// setiap penguncian berikutnya dua kali lebih lama, paling lama MaxLockoutMinutes.
+	LockoutThreshold  int  `json:"lockout_threshold"`
+	LockoutMinutes    int  `json:"lockout_minutes"`
+	LockoutBackoff    bool `json:"lockout_backoff"`
+	MaxLockoutMinutes int  `json:"max_lockout_minutes"`
+	BcryptCost        int  `json:"bcrypt_cost"`
+}
+
+// FromEnv returns the policy configured by environment variables, with defaults for unset ones
+func FromEnv() Policy {
+	return Policy{
+		MinLength:         envInt("PASSWORD_MIN_LENGTH", 8),
+		RequireUpper:      envBool("PASSWORD_REQUIRE_UPPER", false),
+		RequireLower:      envBool("PASSWORD_REQUIRE_LOWER", false),
+		RequireDigit:      envBool("PASSWORD_REQUIRE_DIGIT", false),
+		RequireSymbol:     envBool("PASSWORD_REQUIRE_SYMBOL", false),
+		UseBlocklist:      envBool("PASSWORD_USE_BLOCKLIST", true),
+		LockoutThreshold:  envInt("LOCKOUT_THRESHOLD", 3),
+		LockoutMinutes:    envInt("LOCKOUT_MINUTES", 15),
+		LockoutBackoff:    envBool("LOCKOUT_BACKOFF", true),
+		MaxLockoutMinutes: envInt("LOCKOUT_MAX_MINUTES", 24*60),
+		BcryptCost:        envInt("BCRYPT_COST", 14),
+	}
+}
+
+// Check reports whether the policy itself is usable
+func (p Policy) Check() error {
+	switch {
+	case p.MinLength < 1 || p.MinLength > maxLength:
+		return fmt.Errorf("min_length must be between 1 and %d", maxLength)
+	case p.LockoutThreshold < 1:
+		return errors.New("lockout_threshold must be at least 1")
+	case p.LockoutMinutes < 1:
+		return errors.New("lockout_minutes must be at least 1")
+	case p.MaxLockoutMinutes < p.LockoutMinutes:
+		return errors.New("max_lockout_minutes must not be less than lockout_minutes")
+	case p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost:
+		return fmt.Errorf("bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
+// Validate returns the rules a new password breaks; related values such as the user's
+// name and email may not be used as the password
+func (p Policy) Validate(password string, related ...string) []string {
+	problems := []string{}
+
+	if len(password) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+	if len(password) > maxLength {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes", maxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		problems = append(problems, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		problems = append(problems, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		problems = append(problems, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		problems = append(problems, "must contain a symbol")
+	}
+
+	normalized := strings.ToLower(password)
+	for _, value := range related {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if local, _, found := strings.Cut(value, "@"); found {
+			value = local
+		}
+		if value != "" && normalized == value {
+			problems = append(problems, "must not be your name or email")
+			break
+		}
+	}
+	if p.UseBlocklist && blocked(normalized) {
+		problems = append(problems, "is too common, choose a less predictable password")
+	}
+
+	return problems
+}
+
+// LockoutDuration returns how long to lock an account that has already been locked
+// previousLockouts times since its last successful login
+func (p Policy) LockoutDuration(previousLockouts int) time.Duration {
+	minutes := p.LockoutMinutes
+	if p.LockoutBackoff {
+		for i := 0; i < previousLockouts && minutes < p.MaxLockoutMinutes; i++ {
+			minutes *= 2
+		}
+		if minutes > p.MaxLockoutMinutes {
+			minutes = p.MaxLockoutMinutes
+		}
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// Hash hashes a password with the bcrypt cost of the policy
+func (p Policy) Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+}
+
+// NeedsRehash reports whether a hash was made with a different bcrypt cost than the policy's
+func (p Policy) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err == nil && cost != p.BcryptCost
+}
+
+var (
+	blocklistMu   sync.Mutex
+	blocklistPath string
+	blocklist     map[string]bool
+)
+
+// blocked reports whether a lowercased password is in the blocklist file.
+// The file is read once and read again only when PASSWORD_BLOCKLIST_FILE changes.
+func blocked(password string) bool {
+	path := os.Getenv("PASSWORD_BLOCKLIST_FILE")
+	if path == "" {
+		path = "data/common-passwords.txt"
+	}
+
+	blocklistMu.Lock()
+	defer blocklistMu.Unlock()
+	if blocklist == nil || blocklistPath != path {
+		blocklist = loadBlocklist(path)
+		blocklistPath = path
+	}
+	return blocklist[password]
+}
+
+// loadBlocklist reads one password per line, skipping blank lines and # comments
+func loadBlocklist(path string) map[string]bool {
+	entries := map[string]bool{}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Password blocklist %s not loaded: %v", path, err)
+		return entries
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading password blocklist %s: %v", path, err)
+	}
+	return entries
+}
+
+func envInt(name string, def int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return value
+	}
+	return def
+}
+
+func envBool(name string, def bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DatabaseStore keeps buckets in the rate_limit_buckets table so every instance sees the same limits
+type DatabaseStore struct{}
+
+// Take implements Store. The bucket row is locked for the update so concurrent requests
+// from different instances are counted correctly.
+func (DatabaseStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	var result Result
+
+	// Get DB connection
+	db, err := database.GetDBConnection()
+	if err != nil {
+		return result, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var row models.RateLimitBucket
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&row).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			row = models.RateLimitBucket{Key: key, Tokens: float64(limit.Requests), LastSeen: now}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+				return fmt.Errorf("failed to create rate limit bucket: %w", err)
+			}
+			err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&row).Error
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read rate limit bucket: %w", err)
+		}
+
+		bucket := Bucket{Tokens: row.Tokens, LastSeen: row.LastSeen}
+		result = bucket.take(limit, now)
+
+		if err := tx.Model(&models.RateLimitBucket{}).Where("key = ?", key).
+			Updates(map[string]interface{}{"tokens": bucket.Tokens, "last_seen": bucket.LastSeen}).Error; err != nil {
+			return fmt.Errorf("failed to update rate limit bucket: %w", err)
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// Prune implements Store
+func (DatabaseStore) Prune(before time.Time) error {
+	// Get DB connection
+	db, err := database.GetDBConnection()
+	if err != nil {
+		return err
+	}
+
+	if err := db.Where("last_seen < ?", before).Delete(&models.RateLimitBucket{}).Error; err != nil {
+		return fmt.Errorf("failed to prune rate limit buckets: %w", err)
+	}
+	return nil
+}
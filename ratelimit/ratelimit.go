@@ -0,0 +1,161 @@
+// Package ratelimit membatasi jumlah request dengan token bucket. Bucket disimpan di Store:
+// di memori untuk satu instance, atau di database agar dipakai bersama oleh beberapa instance.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Requests requests per Per, refilled continuously; a full bucket allows a burst of Requests
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// rate is the number of tokens added per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// String formats the limit the way ParseLimit reads it, e.g. "20/1m0s"
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// ParseLimit reads a limit written as "requests/duration", e.g. "20/1m" or "5/15m"
+func ParseLimit(value string) (Limit, error) {
+	requests, per, found := strings.Cut(strings.TrimSpace(value), "/")
+	if !found {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected requests/duration", value)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("invalid request count in rate limit %q", value)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid duration in rate limit %q", value)
+	}
+	return Limit{Requests: n, Per: d}, nil
+}
+
+// Result is the state of a bucket after taking a token
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, zero when allowed
+}
+
+// Store keeps the buckets
+type Store interface {
+	// Take removes one token from the bucket of key if there is one
+	Take(key string, limit Limit, now time.Time) (Result, error)
+	// Prune forgets buckets that were not used since before
+	Prune(before time.Time) error
+}
+
+// Bucket is the stored state of one token bucket
+type Bucket struct {
+	Tokens   float64
+	LastSeen time.Time
+}
+
+// take refills the bucket for the time since it was last seen and takes a token from it
+func (b *Bucket) take(limit Limit, now time.Time) Result {
+	capacity := float64(limit.Requests)
+	if b.LastSeen.IsZero() {
+		b.Tokens = capacity
+	} else if elapsed := now.Sub(b.LastSeen).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(capacity, b.Tokens+elapsed*limit.rate())
+	}
+	b.LastSeen = now
+
+	result := Result{Limit: limit.Requests}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.Tokens) / limit.rate())
+	}
+	result.Remaining = int(math.Floor(b.Tokens))
+	result.Reset = seconds((capacity - b.Tokens) / limit.rate())
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s)) * time.Second
+}
+
+// MemoryStore keeps buckets in the memory of this process
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*Bucket)}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &Bucket{}
+		s.buckets[key] = bucket
+	}
+	return bucket.take(limit, now), nil
+}
+
+// Prune implements Store
+func (s *MemoryStore) Prune(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, bucket := range s.buckets {
+		if bucket.LastSeen.Before(before) {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
+
+var (
+	defaultStore Store
+	once         sync.Once
+)
+
+// Default returns the store configured by RATE_LIMIT_STORE: "database" shares the buckets
+// between instances through the database, anything else keeps them in memory
+func Default() Store {
+	once.Do(func() {
+		switch strings.ToLower(os.Getenv("RATE_LIMIT_STORE")) {
+		case "database":
+			defaultStore = DatabaseStore{}
+		default:
+			defaultStore = NewMemoryStore()
+		}
+	})
+	return defaultStore
+}
+
+// FromEnv returns the limit in the environment variable name, or def when it is unset or invalid
+func FromEnv(name string, def Limit) Limit {
+	if value := os.Getenv(name); value != "" {
+		if limit, err := ParseLimit(value); err == nil {
+			return limit
+		}
+	}
+	return def
+}
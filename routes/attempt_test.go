@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestSubmitJawabanRejectsInvalidAnswers checks that a one-shot submission refuses an answer that
+// does not fit the soal type, like saving a single answer does, and starts no attempt
+func TestSubmitJawabanRejectsInvalidAnswers(t *testing.T) {
+	f := newOwnershipFixture(t)
+	content := f.newContent(t, false)
+	student := createTestUser(t, "student", models.RoleStudent)
+
+	soal, err := database.CreateSoal("The sky is blue", models.SoalTrueFalse, nil, "true", nil, 1, "", nil, content.kuis.ID)
+	if err != nil {
+		t.Fatalf("create soal: %v", err)
+	}
+	if _, err := database.PublishKuis(content.kuis.ID, f.owner.ID); err != nil {
+		t.Fatalf("publish kuis: %v", err)
+	}
+
+	body := fmt.Sprintf(`[{"soal_id":%d,"answer":"maybe"}]`, soal.ID)
+	if got := f.request(t, student, fiber.MethodPost, "/hasil-kuis/submit-jawaban", body); got != fiber.StatusBadRequest {
+		t.Errorf("invalid answer: status %d, want 400", got)
+	}
+
+	var attempts int64
+	database.DB.Model(&models.KuisAttempt{}).Where("users_id = ? AND kuis_id = ?", student.ID, content.kuis.ID).Count(&attempts)
+	if attempts != 0 {
+		t.Errorf("%d attempts were started for the refused submission, want 0", attempts)
+	}
+
+	body = fmt.Sprintf(`[{"soal_id":%d,"answer":"A"},{"soal_id":%d,"answer":"TRUE"}]`, content.soal.ID, soal.ID)
+	if got := f.request(t, student, fiber.MethodPost, "/hasil-kuis/submit-jawaban", body); got != fiber.StatusOK {
+		t.Errorf("valid answers: status %d, want 200", got)
+	}
+}
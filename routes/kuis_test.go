@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestFilterKuisHidesDrafts checks that filter-kuis only lists published kuis with their published details
+func TestFilterKuisHidesDrafts(t *testing.T) {
+	f := newOwnershipFixture(t)
+	published := f.newContent(t, true)
+	draft := f.newContent(t, false)
+	student := createTestUser(t, "student", models.RoleStudent)
+
+	// Judul baru masih draft sampai kuis dipublikasikan lagi
+	id := fmt.Sprintf("%d", published.kuis.ID)
+	if _, err := database.UpdateKuis("Draft title", "", false, 0, 0, 0, 0, id); err != nil {
+		t.Fatalf("update kuis: %v", err)
+	}
+
+	var kuis []models.Kuis
+	path := fmt.Sprintf("/kuis/filter-kuis?kategori_id=%d", f.kategori)
+	if got := f.requestData(t, student, fiber.MethodGet, path, "", &kuis); got != fiber.StatusOK {
+		t.Fatalf("filter kuis: status %d, want 200", got)
+	}
+	if len(kuis) != 1 || kuis[0].ID != published.kuis.ID {
+		t.Fatalf("filter kuis returned %d kuis, want only the published kuis %d (draft %d)", len(kuis), published.kuis.ID, draft.kuis.ID)
+	}
+	if kuis[0].Title != published.kuis.Title {
+		t.Errorf("filter kuis shows title %q, want the published %q", kuis[0].Title, published.kuis.Title)
+	}
+
+	if got := f.request(t, student, fiber.MethodGet, "/kuis/filter-kuis?kategori_id=x", ""); got != fiber.StatusBadRequest {
+		t.Errorf("invalid kategori_id: status %d, want 400", got)
+	}
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/Joko206/UAS_PWEB1/oidc/oidctest"
+)
+
+// The handler tests need a PostgreSQL database they may write to. They run when TEST_DB_NAME is set
+// (with DB_HOST, DB_USER, DB_PASSWORD and DB_SSLMODE as for the app) and are skipped otherwise.
+var testDB bool
+
+// testIssuer is the mock identity provider configured as OIDC provider "mock"
+var testIssuer *oidctest.Issuer
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv("TEST_DB_NAME"); name != "" {
+		os.Setenv("DB_NAME", name)
+		if os.Getenv("JWT_KEYS") == "" && os.Getenv("JWT_SECRET") == "" {
+			os.Setenv("JWT_SECRET", "routes-test-secret-0123456789abcdef")
+		}
+		// Test login berulang kali dari alamat yang sama
+		os.Setenv("RATE_LIMIT_AUTH_IP", "10000/1m")
+		if err := database.InitializeDatabase(); err != nil {
+			log.Fatalf("Failed to initialize test database: %v", err)
+		}
+		testDB = true
+
+		// Provider dibaca sekali per proses, jadi issuer harus jalan sebelum test pertama
+		issuer, err := oidctest.NewIssuer("brainquiz")
+		if err != nil {
+			log.Fatalf("Failed to start mock issuer: %v", err)
+		}
+		testIssuer = issuer
+		os.Setenv("OIDC_PROVIDERS", "mock")
+		os.Setenv("OIDC_MOCK_ISSUER", issuer.URL())
+		os.Setenv("OIDC_MOCK_CLIENT_ID", issuer.ClientID)
+		os.Setenv("OIDC_MOCK_REDIRECT_URL", "http://localhost:5173/oidc/callback")
+		os.Setenv("OIDC_MOCK_ROLE_CLAIM", "groups")
+		os.Setenv("OIDC_MOCK_ROLE_MAP", "guru=teacher")
+	}
+
+	code := m.Run()
+	if testIssuer != nil {
+		testIssuer.Close()
+	}
+	os.Exit(code)
+}
+
+func createTestUser(t *testing.T, name string, role string) models.Users {
+	t.Helper()
+
+	verified := time.Now()
+	user := models.Users{
+		Name:            name,
+		Email:           fmt.Sprintf("%s-%d@routes.test", name, time.Now().UnixNano()),
+		Password:        []byte("-"),
+		Role:            role,
+		EmailVerifiedAt: &verified,
+	}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user %s: %v", name, err)
+	}
+	return user
+}
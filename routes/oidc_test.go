@@ -0,0 +1,178 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+)
+
+// oidcResponse is the body of the login and callback responses
+type oidcResponse struct {
+	Success bool                   `json:"success"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// oidcLogin logs in at the mock provider through the API; edit changes the ID token claims
+func oidcLogin(t *testing.T, app *fiber.App, edit func(claims jwt.MapClaims)) (int, oidcResponse) {
+	t.Helper()
+
+	status, start := oidcGet(t, app, "/user/oidc/mock/login?redirect=false")
+	if status != fiber.StatusOK {
+		t.Fatalf("start login: status %d (%s)", status, start.Message)
+	}
+	authURL, _ := start.Data["authorization_url"].(string)
+
+	code, state, err := testIssuer.Authorize(authURL, edit)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+
+	return oidcGet(t, app, "/user/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode())
+}
+
+func oidcGet(t *testing.T, app *fiber.App, path string) (int, oidcResponse) {
+	t.Helper()
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, path, nil), -1)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	var body oidcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("GET %s: decode response: %v", path, err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestOIDCLogin(t *testing.T) {
+	if !testDB {
+		t.Skip("TEST_DB_NAME is not set")
+	}
+
+	app := fiber.New()
+	Setup(app)
+
+	t.Run("verified email links the existing account", func(t *testing.T) {
+		existing := createTestUser(t, "oidc-existing", models.RoleStudent)
+
+		status, body := oidcLogin(t, app, func(claims jwt.MapClaims) {
+			claims["email"] = existing.Email
+			claims["email_verified"] = true
+		})
+		if status != fiber.StatusOK {
+			t.Fatalf("status %d (%s), want 200", status, body.Message)
+		}
+		if id, _ := body.Data["user_id"].(float64); uint(id) != existing.ID {
+			t.Errorf("logged in as user %v, want existing user %d", body.Data["user_id"], existing.ID)
+		}
+		if created, _ := body.Data["created"].(bool); created {
+			t.Error("a new account was created instead of linking the existing one")
+		}
+
+		var links int64
+		database.DB.Model(&models.UserIdentity{}).Where("users_id = ? AND provider = ?", existing.ID, "mock").Count(&links)
+		if links != 1 {
+			t.Errorf("%d identities linked to the existing account, want 1", links)
+		}
+	})
+
+	t.Run("unverified email is not linked", func(t *testing.T) {
+		existing := createTestUser(t, "oidc-unverified", models.RoleStudent)
+
+		status, body := oidcLogin(t, app, func(claims jwt.MapClaims) {
+			claims["email"] = existing.Email
+			claims["email_verified"] = false
+		})
+		if status != fiber.StatusForbidden {
+			t.Fatalf("status %d (%s), want 403", status, body.Message)
+		}
+
+		var links int64
+		database.DB.Model(&models.UserIdentity{}).Where("users_id = ?", existing.ID).Count(&links)
+		if links != 0 {
+			t.Errorf("%d identities linked to the account, want 0", links)
+		}
+	})
+
+	t.Run("locked account is not linked or changed", func(t *testing.T) {
+		existing := createTestUser(t, "oidc-locked", models.RoleStudent)
+		if _, err := database.LockUser(existing.ID, nil); err != nil {
+			t.Fatalf("lock user: %v", err)
+		}
+
+		status, body := oidcLogin(t, app, func(claims jwt.MapClaims) {
+			claims["email"] = existing.Email
+			claims["groups"] = []string{"guru"}
+		})
+		if status != fiber.StatusForbidden {
+			t.Fatalf("status %d (%s), want 403", status, body.Message)
+		}
+
+		user, err := database.GetUserByID(existing.ID)
+		if err != nil {
+			t.Fatalf("reload user: %v", err)
+		}
+		if user.Role != models.RoleStudent {
+			t.Errorf("role of the locked account changed to %s", user.Role)
+		}
+		var links int64
+		database.DB.Model(&models.UserIdentity{}).Where("users_id = ?", existing.ID).Count(&links)
+		if links != 0 {
+			t.Errorf("%d identities linked to the locked account, want 0", links)
+		}
+	})
+
+	t.Run("role mapping sets the role of a new account", func(t *testing.T) {
+		status, body := oidcLogin(t, app, func(claims jwt.MapClaims) {
+			claims["groups"] = []string{"siswa", "guru"}
+		})
+		if status != fiber.StatusOK {
+			t.Fatalf("status %d (%s), want 200", status, body.Message)
+		}
+		if created, _ := body.Data["created"].(bool); !created {
+			t.Error("no account was created")
+		}
+		if role := body.Data["role"]; role != models.RoleTeacher {
+			t.Errorf("role %v, want %s", role, models.RoleTeacher)
+		}
+	})
+
+	t.Run("unmapped login keeps the student role", func(t *testing.T) {
+		status, body := oidcLogin(t, app, nil)
+		if status != fiber.StatusOK {
+			t.Fatalf("status %d (%s), want 200", status, body.Message)
+		}
+		if role := body.Data["role"]; role != models.RoleStudent {
+			t.Errorf("role %v, want %s", role, models.RoleStudent)
+		}
+	})
+
+	t.Run("state cannot be replayed", func(t *testing.T) {
+		status, start := oidcGet(t, app, "/user/oidc/mock/login?redirect=false")
+		if status != fiber.StatusOK {
+			t.Fatalf("start login: status %d", status)
+		}
+		authURL, _ := start.Data["authorization_url"].(string)
+		code, state, err := testIssuer.Authorize(authURL, nil)
+		if err != nil {
+			t.Fatalf("authorize: %v", err)
+		}
+
+		callback := "/user/oidc/callback?" + url.Values{"code": {code}, "state": {state}}.Encode()
+		if status, body := oidcGet(t, app, callback); status != fiber.StatusOK {
+			t.Fatalf("first callback: status %d (%s)", status, body.Message)
+		}
+		if status, _ := oidcGet(t, app, callback); status != fiber.StatusBadRequest {
+			t.Errorf("replayed callback: status %d, want 400", status)
+		}
+	})
+}
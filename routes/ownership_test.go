@@ -0,0 +1,389 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/jwtkeys"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt"
+)
+
+// ownershipFixture holds the users and lookup rows shared by the ownership tests
+type ownershipFixture struct {
+	app        *fiber.App
+	owner      models.Users // membuat kelas dan kuis
+	coTeacher  models.Users // co-teacher kelas
+	other      models.Users // teacher lain yang tidak mengajar kelas ini
+	admin      models.Users
+	kategori   uint
+	tingkatan  uint
+	pendidikan uint
+}
+
+// ownershipContent is a fresh kelas with one kuis and one soal, owned by the fixture owner
+type ownershipContent struct {
+	kelas models.Kelas
+	kuis  models.Kuis
+	soal  models.Soal
+}
+
+func newOwnershipFixture(t *testing.T) ownershipFixture {
+	t.Helper()
+	if !testDB {
+		t.Skip("TEST_DB_NAME is not set")
+	}
+
+	app := fiber.New()
+	Setup(app)
+
+	f := ownershipFixture{
+		app:       app,
+		owner:     createTestUser(t, "owner", models.RoleTeacher),
+		coTeacher: createTestUser(t, "co-teacher", models.RoleTeacher),
+		other:     createTestUser(t, "other", models.RoleTeacher),
+		admin:     createTestUser(t, "admin", models.RoleAdmin),
+	}
+
+	kategori := models.Kategori_Soal{Name: "Ownership test"}
+	tingkatan := models.Tingkatan{Name: "Ownership test"}
+	pendidikan := models.Pendidikan{Name: "Ownership test"}
+	for _, row := range []interface{}{&kategori, &tingkatan, &pendidikan} {
+		if err := database.DB.Create(row).Error; err != nil {
+			t.Fatalf("create lookup row: %v", err)
+		}
+	}
+	f.kategori, f.tingkatan, f.pendidikan = kategori.ID, tingkatan.ID, pendidikan.ID
+
+	return f
+}
+
+// newContent creates the kelas, kuis and soal one request works on; publish makes the kuis live
+func (f ownershipFixture) newContent(t *testing.T, publish bool) ownershipContent {
+	t.Helper()
+
+	kelas, err := database.CreateKelas("Ownership test", "", f.owner.ID)
+	if err != nil {
+		t.Fatalf("create kelas: %v", err)
+	}
+	if _, err := database.AddKelasTeacher(kelas, f.coTeacher.ID, f.owner.ID); err != nil {
+		t.Fatalf("add co-teacher: %v", err)
+	}
+
+	kuis, err := database.CreateKuis("Ownership test", "", false, f.kategori, f.tingkatan, kelas.ID, f.pendidikan, f.owner.ID, models.KuisSettings{})
+	if err != nil {
+		t.Fatalf("create kuis: %v", err)
+	}
+
+	soal, err := database.CreateSoal("1 + 1?", models.SoalSingleChoice, []byte(`{"A":"2","B":"3"}`), "A", nil, 1, "", nil, kuis.ID)
+	if err != nil {
+		t.Fatalf("create soal: %v", err)
+	}
+
+	if publish {
+		if _, err := database.PublishKuis(kuis.ID, f.owner.ID); err != nil {
+			t.Fatalf("publish kuis: %v", err)
+		}
+	}
+
+	return ownershipContent{kelas: kelas, kuis: kuis, soal: soal}
+}
+
+// request sends a JSON request as the user, with an access token for a new session
+func (f ownershipFixture) request(t *testing.T, user models.Users, method string, path string, body string) int {
+	t.Helper()
+	return f.requestData(t, user, method, path, body, nil)
+}
+
+// requestData is request that also decodes the data of the response into out, unless out is nil
+func (f ownershipFixture) requestData(t *testing.T, user models.Users, method string, path string, body string, out interface{}) int {
+	t.Helper()
+
+	session, _, err := database.CreateSession(user.ID, "ownership test", "127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	keys, err := jwtkeys.Default()
+	if err != nil {
+		t.Fatalf("load jwt keys: %v", err)
+	}
+	token, err := keys.Sign(jwt.MapClaims{
+		"iss":  strconv.Itoa(int(user.ID)),
+		"sid":  session.ID,
+		"exp":  time.Now().Add(time.Hour).Unix(),
+		"role": user.Role,
+	})
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "jwt", Value: token})
+
+	resp, err := f.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		var envelope struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+		if len(envelope.Data) > 0 && string(envelope.Data) != "null" {
+			if err := json.Unmarshal(envelope.Data, out); err != nil {
+				t.Fatalf("%s %s: decode data: %v", method, path, err)
+			}
+		}
+	}
+	return resp.StatusCode
+}
+
+// TestContentMutationOwnership checks every kuis, soal and kelas mutation route for the kuis or kelas
+// owner, a co-teacher of the kelas, another teacher and an admin
+func TestContentMutationOwnership(t *testing.T) {
+	f := newOwnershipFixture(t)
+
+	cases := []struct {
+		name      string
+		method    string
+		path      func(content ownershipContent) string
+		body      func(content ownershipContent) string
+		published bool // regrades need a published kuis
+		ownerOnly bool // co-teachers may not do this either
+	}{
+		{
+			name:   "update kuis",
+			method: fiber.MethodPatch,
+			path:   func(c ownershipContent) string { return fmt.Sprintf("/kuis/update-kuis/%d", c.kuis.ID) },
+			body: func(c ownershipContent) string {
+				return fmt.Sprintf(`{"title":"Updated","description":"","kategori_id":%d,"tingkatan_id":%d,"kelas_id":%d,"pendidikan_id":%d}`,
+					f.kategori, f.tingkatan, c.kelas.ID, f.pendidikan)
+			},
+		},
+		{
+			name:   "add kuis",
+			method: fiber.MethodPost,
+			path:   func(ownershipContent) string { return "/kuis/add-kuis" },
+			body: func(c ownershipContent) string {
+				return fmt.Sprintf(`{"title":"New","description":"","kategori_id":%d,"tingkatan_id":%d,"kelas_id":%d,"pendidikan_id":%d}`,
+					f.kategori, f.tingkatan, c.kelas.ID, f.pendidikan)
+			},
+		},
+		{
+			name:   "update kuis settings",
+			method: fiber.MethodPatch,
+			path:   func(c ownershipContent) string { return fmt.Sprintf("/kuis/update-settings/%d", c.kuis.ID) },
+			body:   func(ownershipContent) string { return `{"scoring_policy":"best"}` },
+		},
+		{
+			name:   "publish kuis",
+			method: fiber.MethodPost,
+			path:   func(c ownershipContent) string { return fmt.Sprintf("/kuis/publish/%d", c.kuis.ID) },
+		},
+		{
+			name:   "regrade kuis",
+			method: fiber.MethodPost,
+			path:   func(c ownershipContent) string { return fmt.Sprintf("/kuis/regrade/%d", c.kuis.ID) },
+			body: func(c ownershipContent) string {
+				return fmt.Sprintf(`{"corrections":[{"soal_id":%d,"correct_answer":"B"}]}`, c.soal.ID)
+			},
+			published: true,
+		},
+		{
+			name:   "delete kuis",
+			method: fiber.MethodDelete,
+			path:   func(c ownershipContent) string { return fmt.Sprintf("/kuis/delete-kuis/%d", c.kuis.ID) },
+		},
+		{
+			name:   "add soal",
+			method: fiber.MethodPost,
+			path:   func(ownershipContent) string { return "/soal/add-soal" },
+			body: func(c ownershipContent) string {
+				return fmt.Sprintf(`{"question":"2 + 2?","type":"single_choice","options_json":{"A":"4","B":"5"},"correct_answer":"A","kuis_id":%d}`, c.kuis.ID)
+			},
+		},
+		{
+			name:   "update soal",
+			method: fiber.MethodPatch,
+			path:   func(c ownershipContent) string { return fmt.Sprintf("/soal/update-soal/%d", c.soal.ID) },
+			body:   func(ownershipContent) string { return `{"question":"1 + 1 = ?"}` },
+		},
+		{
+			name:      "regrade soal",
+			method:    fiber.MethodPost,
+			path:      func(c ownershipContent) string { return fmt.Sprintf("/soal/regrade/%d", c.soal.ID) },
+			body:      func(ownershipContent) string { return `{"correct_answer":"B"}` },
+			published: true,
+		},
+		{
+			name:   "delete soal",
+			method: fiber.MethodDelete,
+			path:   func(c ownershipContent) string { return fmt.Sprintf("/soal/delete-soal/%d", c.soal.ID) },
+		},
+		{
+			name:   "update kelas",
+			method: fiber.MethodPatch,
+			path:   func(c ownershipContent) string { return fmt.Sprintf("/kelas/update-kelas/%d", c.kelas.ID) },
+			body:   func(ownershipContent) string { return `{"name":"Updated","description":""}` },
+		},
+		{
+			name:   "delete kelas",
+			method: fiber.MethodDelete,
+			path:   func(c ownershipContent) string { return fmt.Sprintf("/kelas/delete-kelas/%d", c.kelas.ID) },
+		},
+		{
+			name:      "add co-teacher",
+			method:    fiber.MethodPost,
+			path:      func(c ownershipContent) string { return fmt.Sprintf("/kelas/%d/teachers", c.kelas.ID) },
+			body:      func(ownershipContent) string { return fmt.Sprintf(`{"user_id":%d}`, f.other.ID) },
+			ownerOnly: true,
+		},
+		{
+			name:   "remove co-teacher",
+			method: fiber.MethodDelete,
+			path: func(c ownershipContent) string {
+				return fmt.Sprintf("/kelas/%d/teachers/%d", c.kelas.ID, f.coTeacher.ID)
+			},
+			ownerOnly: true,
+		},
+	}
+
+	for _, tc := range cases {
+		coTeacherStatus := fiber.StatusOK
+		if tc.ownerOnly {
+			coTeacherStatus = fiber.StatusForbidden
+		}
+		actors := []struct {
+			name string
+			user models.Users
+			want int
+		}{
+			{"owner", f.owner, fiber.StatusOK},
+			{"co-teacher", f.coTeacher, coTeacherStatus},
+			{"other teacher", f.other, fiber.StatusForbidden},
+			{"admin", f.admin, fiber.StatusOK},
+		}
+
+		for _, actor := range actors {
+			t.Run(tc.name+"/"+actor.name, func(t *testing.T) {
+				content := f.newContent(t, tc.published)
+				body := ""
+				if tc.body != nil {
+					body = tc.body(content)
+				}
+
+				if got := f.request(t, actor.user, tc.method, tc.path(content), body); got != actor.want {
+					t.Errorf("%s %s as %s: status %d, want %d", tc.method, tc.path(content), actor.name, got, actor.want)
+				}
+			})
+		}
+	}
+}
+
+// TestKuisKelasPlacement checks that a kuis cannot be created in or moved into a kelas of another teacher
+func TestKuisKelasPlacement(t *testing.T) {
+	f := newOwnershipFixture(t)
+	content := f.newContent(t, false)
+
+	foreign, err := database.CreateKelas("Placement test", "", f.other.ID)
+	if err != nil {
+		t.Fatalf("create kelas: %v", err)
+	}
+	kuisBody := func(kelasID uint) string {
+		return fmt.Sprintf(`{"title":"Placement","description":"","is_private":true,"kategori_id":%d,"tingkatan_id":%d,"kelas_id":%d,"pendidikan_id":%d}`,
+			f.kategori, f.tingkatan, kelasID, f.pendidikan)
+	}
+	update := fmt.Sprintf("/kuis/update-kuis/%d", content.kuis.ID)
+
+	for _, actor := range []models.Users{f.owner, f.coTeacher} {
+		if got := f.request(t, actor, fiber.MethodPost, "/kuis/add-kuis", kuisBody(foreign.ID)); got != fiber.StatusForbidden {
+			t.Errorf("%s creates a kuis in another teacher's kelas: status %d, want 403", actor.Name, got)
+		}
+		if got := f.request(t, actor, fiber.MethodPatch, update, kuisBody(foreign.ID)); got != fiber.StatusForbidden {
+			t.Errorf("%s moves the kuis into another teacher's kelas: status %d, want 403", actor.Name, got)
+		}
+	}
+
+	kuis, err := database.GetKuisByID(content.kuis.ID)
+	if err != nil {
+		t.Fatalf("reload kuis: %v", err)
+	}
+	if kuis.Kelas_id != content.kelas.ID {
+		t.Fatalf("kuis was moved to kelas %d", kuis.Kelas_id)
+	}
+
+	if got := f.request(t, f.admin, fiber.MethodPost, "/kuis/add-kuis", kuisBody(foreign.ID)); got != fiber.StatusOK {
+		t.Errorf("admin creates a kuis in any kelas: status %d, want 200", got)
+	}
+	if got := f.request(t, f.admin, fiber.MethodPatch, update, kuisBody(foreign.ID)); got != fiber.StatusOK {
+		t.Errorf("admin moves a kuis into any kelas: status %d, want 200", got)
+	}
+}
+
+// TestResultAccessAfterEnrollment checks that a teacher who enrolls a student in their own kelas
+// still cannot read the student's results for kuis of another teacher
+func TestResultAccessAfterEnrollment(t *testing.T) {
+	f := newOwnershipFixture(t)
+	content := f.newContent(t, true)
+
+	student := createTestUser(t, "student", models.RoleStudent)
+	if err := database.DB.Create(&models.Kelas_Pengguna{Users_id: student.ID, Kelas_id: content.kelas.ID}).Error; err != nil {
+		t.Fatalf("enroll student: %v", err)
+	}
+	if err := database.DB.Create(&models.Hasil_Kuis{Users_id: student.ID, Kuis_id: content.kuis.ID, Score: 100}).Error; err != nil {
+		t.Fatalf("create result: %v", err)
+	}
+
+	// Teacher lain membuat kelas sendiri lalu mendaftarkan siswa tersebut tanpa persetujuannya
+	kelas, err := database.CreateKelas("Result access test", "", f.other.ID)
+	if err != nil {
+		t.Fatalf("create kelas: %v", err)
+	}
+	enroll := fmt.Sprintf(`{"user_id":%d,"kelas_id":%d}`, student.ID, kelas.ID)
+	if got := f.request(t, f.other, fiber.MethodPost, "/kelas/join-kelas", enroll); got != fiber.StatusOK {
+		t.Fatalf("enroll student in own kelas: status %d, want 200", got)
+	}
+
+	// Teacher tidak boleh mendaftarkan staff ke kelasnya
+	enrollTeacher := fmt.Sprintf(`{"user_id":%d,"kelas_id":%d}`, f.coTeacher.ID, kelas.ID)
+	if got := f.request(t, f.other, fiber.MethodPost, "/kelas/join-kelas", enrollTeacher); got != fiber.StatusForbidden {
+		t.Errorf("enroll a teacher: status %d, want 403", got)
+	}
+
+	single := fmt.Sprintf("/hasil-kuis/%d/%d", student.ID, content.kuis.ID)
+	list := fmt.Sprintf("/hasil-kuis/user/%d", student.ID)
+
+	if got := f.request(t, f.other, fiber.MethodGet, single, ""); got != fiber.StatusForbidden {
+		t.Errorf("other teacher reads the result: status %d, want 403", got)
+	}
+	var results []models.Hasil_Kuis
+	if got := f.requestData(t, f.other, fiber.MethodGet, list, "", &results); got != fiber.StatusOK {
+		t.Fatalf("other teacher lists the results: status %d, want 200", got)
+	}
+	if len(results) != 0 {
+		t.Errorf("other teacher sees %d results of kuis they do not teach, want 0", len(results))
+	}
+
+	for _, viewer := range []models.Users{f.owner, f.coTeacher, f.admin} {
+		if got := f.request(t, viewer, fiber.MethodGet, single, ""); got != fiber.StatusOK {
+			t.Errorf("%s reads the result: status %d, want 200", viewer.Name, got)
+		}
+		results = nil
+		if got := f.requestData(t, viewer, fiber.MethodGet, list, "", &results); got != fiber.StatusOK || len(results) != 1 {
+			t.Errorf("%s lists the results: status %d with %d results, want 200 with 1", viewer.Name, got, len(results))
+		}
+	}
+}
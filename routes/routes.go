@@ -1,7 +1,13 @@
 package routes
 
 import (
+	"errors"
+	"time"
+
 	"github.com/Joko206/UAS_PWEB1/controllers"
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/Joko206/UAS_PWEB1/ratelimit"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -9,6 +15,15 @@ import (
 func AuthMiddleware(c *fiber.Ctx) error {
 	_, err := controllers.Authenticate(c)
 	if err != nil {
+		// API key tanpa scope yang dibutuhkan ditolak dengan 403 beserta alasannya
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) && fiberErr.Code == fiber.StatusForbidden {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"data":    nil,
+				"success": false,
+				"message": fiberErr.Message,
+			})
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"data":    nil,
 			"success": false,
@@ -19,77 +34,163 @@ func AuthMiddleware(c *fiber.Ctx) error {
 }
 
 func Setup(app *fiber.App) {
+	// Rate limit untuk endpoint autentikasi (per IP dan per akun) dan pengiriman jawaban (per user dan per IP)
+	authLimit := controllers.RateLimit("auth",
+		controllers.PerIP(ratelimit.FromEnv("RATE_LIMIT_AUTH_IP", ratelimit.Limit{Requests: 20, Per: time.Minute})),
+		controllers.PerAccount(ratelimit.FromEnv("RATE_LIMIT_AUTH_ACCOUNT", ratelimit.Limit{Requests: 10, Per: 15 * time.Minute})),
+	)
+	submissionLimit := controllers.RateLimit("submission",
+		controllers.PerUser(ratelimit.FromEnv("RATE_LIMIT_SUBMISSION_USER", ratelimit.Limit{Requests: 60, Per: time.Minute})),
+		controllers.PerIP(ratelimit.FromEnv("RATE_LIMIT_SUBMISSION_IP", ratelimit.Limit{Requests: 300, Per: time.Minute})),
+	)
+
 	// Root Route
 	start := app.Group("/")
 	start.Get("/", func(ctx *fiber.Ctx) error {
 		return ctx.SendString("Hello World")
 	})
 
+	// Public key untuk memverifikasi access token dari service lain
+	app.Get("/.well-known/jwks.json", controllers.GetJWKS)
+
 	// User Routes
 	api := app.Group("/user")
 	api.Get("/get-user", controllers.User)
-	api.Post("/register", controllers.Register)
-	api.Post("/login", controllers.Login)
+	api.Post("/register", authLimit, controllers.Register)
+	api.Post("/login", authLimit, controllers.Login)
+	api.Post("/login/2fa", authLimit, controllers.LoginTwoFactor)
+	api.Get("/oidc/providers", controllers.GetOIDCProviders)
+	api.Get("/oidc/callback", authLimit, controllers.OIDCCallback)
+	api.Post("/oidc/callback", authLimit, controllers.OIDCCallback)
+	api.Get("/oidc/:provider/login", authLimit, controllers.OIDCLogin)
 	api.Get("/logout", controllers.Logout)
+	api.Post("/refresh", authLimit, controllers.RefreshToken)
+	api.Post("/forgot-password", authLimit, controllers.ForgotPassword)
+	api.Post("/reset-password", authLimit, controllers.ResetPassword)
+	api.Post("/verify-email", authLimit, controllers.VerifyEmail)
+	api.Post("/resend-verification", authLimit, AuthMiddleware, controllers.ResendVerification)
+	api.Post("/2fa/setup", authLimit, controllers.SetupTwoFactor)
+	api.Post("/2fa/enable", authLimit, controllers.EnableTwoFactor)
+	api.Post("/2fa/disable", AuthMiddleware, controllers.DisableTwoFactor)
+	api.Post("/2fa/recovery-codes", AuthMiddleware, controllers.RegenerateRecoveryCodes)
+	api.Post("/2fa/reset/:user_id", controllers.RequirePermission(models.PermUsersManage), controllers.ResetTwoFactor)
+	api.Patch("/profile", AuthMiddleware, controllers.UpdateProfile)
+	api.Post("/change-password", AuthMiddleware, controllers.ChangePassword)
+	api.Post("/api-keys", AuthMiddleware, controllers.CreateAPIKey)
+	api.Get("/api-keys", AuthMiddleware, controllers.GetAPIKeys)
+	api.Delete("/api-keys/:id", AuthMiddleware, controllers.RevokeAPIKey)
+	api.Get("/sessions", AuthMiddleware, controllers.GetSessions)
+	api.Delete("/sessions/:id", AuthMiddleware, controllers.RevokeSession)
+	api.Post("/force-logout/:user_id", controllers.RequirePermission(models.PermUsersManage), controllers.ForceLogout)
+	api.Patch("/role/:user_id", controllers.RequirePermission(models.PermUsersManage), controllers.ChangeUserRole)
+	api.Post("/invitations", controllers.RequirePermission(models.PermUsersManage), controllers.CreateInvitation)
+	api.Get("/invitations", controllers.RequirePermission(models.PermUsersManage), controllers.GetInvitations)
+	api.Delete("/invitations/:id", controllers.RequirePermission(models.PermUsersManage), controllers.RevokeInvitation)
 
 	// Kategori Routes (Only Admin)
 	kategori := app.Group("/kategori", AuthMiddleware)
 	kategori.Get("/get-kategori", controllers.GetKategori)
-	kategori.Post("/add-kategori", controllers.RoleMiddleware([]string{"admin"}), controllers.AddKategori)
-	kategori.Patch("/update-kategori/:id", controllers.RoleMiddleware([]string{"admin"}), controllers.UpdateKategori)
-	kategori.Delete("/delete-kategori/:id", controllers.RoleMiddleware([]string{"admin"}), controllers.DeleteKategori)
+	kategori.Post("/add-kategori", controllers.RequirePermission(models.PermKategoriManage), controllers.AddKategori)
+	kategori.Patch("/update-kategori/:id", controllers.RequirePermission(models.PermKategoriManage), controllers.UpdateKategori)
+	kategori.Delete("/delete-kategori/:id", controllers.RequirePermission(models.PermKategoriManage), controllers.DeleteKategori)
 
 	// Tingkatan Routes (Only Admin and Teacher)
 	tingkatan := app.Group("/tingkatan", AuthMiddleware)
 	tingkatan.Get("/get-tingkatan", controllers.GetTingkatan)
-	tingkatan.Post("/add-tingkatan", controllers.RoleMiddleware([]string{"admin"}), controllers.AddTingkatan)
-	tingkatan.Patch("/update-tingkatan/:id", controllers.RoleMiddleware([]string{"admin"}), controllers.UpdateTingkatan)
-	tingkatan.Delete("/delete-tingkatan/:id", controllers.RoleMiddleware([]string{"admin"}), controllers.DeleteTingkatan)
+	tingkatan.Post("/add-tingkatan", controllers.RequirePermission(models.PermTingkatanManage), controllers.AddTingkatan)
+	tingkatan.Patch("/update-tingkatan/:id", controllers.RequirePermission(models.PermTingkatanManage), controllers.UpdateTingkatan)
+	tingkatan.Delete("/delete-tingkatan/:id", controllers.RequirePermission(models.PermTingkatanManage), controllers.DeleteTingkatan)
 
 	// Kelas Routes (Admin, Teacher, Student)
 	kelas := app.Group("/kelas", AuthMiddleware)
 	kelas.Get("/get-kelas", controllers.GetKelas)
-	kelas.Post("/add-kelas", controllers.RoleMiddleware([]string{"admin", "teacher"}), controllers.AddKelas)
-	kelas.Patch("/update-kelas/:id", controllers.RoleMiddleware([]string{"admin", "teacher"}), controllers.UpdateKelas)
-	kelas.Delete("/delete-kelas/:id", controllers.RoleMiddleware([]string{"admin", "teacher"}), controllers.DeleteKelas)
-	kelas.Post("/join-kelas", controllers.JoinKelas)
-	kelas.Post("/join-by-code", controllers.JoinKelasByCode)
+	kelas.Post("/add-kelas", controllers.RequirePermission(models.PermKelasCreate), controllers.RequireVerifiedEmail(database.ActionCreateKelas), controllers.AddKelas)
+	kelas.Patch("/update-kelas/:id", controllers.RequirePermission(models.PermKelasUpdate), controllers.UpdateKelas)
+	kelas.Delete("/delete-kelas/:id", controllers.RequirePermission(models.PermKelasDelete), controllers.DeleteKelas)
+	kelas.Get("/:id/teachers", controllers.RequirePermission(models.PermKelasUpdate), controllers.GetKelasTeachers)
+	kelas.Post("/:id/teachers", controllers.RequirePermission(models.PermKelasUpdate), controllers.AddKelasTeacher)
+	kelas.Delete("/:id/teachers/:user_id", controllers.RequirePermission(models.PermKelasUpdate), controllers.RemoveKelasTeacher)
+	kelas.Post("/join-kelas", controllers.RequirePermission(models.PermKelasEnroll), controllers.RequireVerifiedEmail(database.ActionJoinKelas), controllers.JoinKelas)
+	kelas.Post("/join-by-code", controllers.RequireVerifiedEmail(database.ActionJoinKelas), controllers.JoinKelasByCode)
 	kelas.Get("/get-kelas-by-user", controllers.GetKelasByUserID)
 
 	// Kuis Routes (Admin, Teacher)
 	kuis := app.Group("/kuis", AuthMiddleware)
 	kuis.Get("/get-kuis", controllers.GetKuis)
-	kuis.Get("/get-all-kuis", controllers.RoleMiddleware([]string{"admin"}), controllers.GetAllKuis)
-	kuis.Post("/add-kuis", controllers.RoleMiddleware([]string{"admin", "teacher"}), controllers.AddKuis)
-	kuis.Patch("/update-kuis/:id", controllers.RoleMiddleware([]string{"admin", "teacher"}), controllers.UpdateKuis)
-	kuis.Delete("/delete-kuis/:id", controllers.RoleMiddleware([]string{"admin", "teacher"}), controllers.DeleteKuis)
+	kuis.Get("/get-all-kuis", controllers.RequirePermission(models.PermKuisReadAll), controllers.GetAllKuis)
+	kuis.Post("/add-kuis", controllers.RequirePermission(models.PermKuisCreate), controllers.RequireVerifiedEmail(database.ActionCreateKuis), controllers.AddKuis)
+	kuis.Patch("/update-kuis/:id", controllers.RequirePermission(models.PermKuisUpdate), controllers.UpdateKuis)
+	kuis.Patch("/update-settings/:id", controllers.RequirePermission(models.PermKuisUpdate), controllers.UpdateKuisSettings)
+	kuis.Post("/publish/:id", controllers.RequirePermission(models.PermKuisUpdate), controllers.PublishKuis)
+	kuis.Post("/regrade/:id", controllers.RequirePermission(models.PermGradingGrade), controllers.RegradeKuis)
+	kuis.Get("/versions/:id", controllers.RequirePermission(models.PermKuisUpdate), controllers.GetKuisVersions)
+	kuis.Delete("/delete-kuis/:id", controllers.RequirePermission(models.PermKuisDelete), controllers.DeleteKuis)
 	kuis.Get("/filter-kuis", controllers.FilterKuis)
 
 	// Soal Routes (Admin, Teacher)
 	soal := app.Group("/soal", AuthMiddleware)
 	soal.Get("/get-soal", controllers.GetSoal)
 	soal.Get("/get-soal/:kuis_id", controllers.GetSoalByKuisID)
-	soal.Post("/add-soal", controllers.RoleMiddleware([]string{"admin", "teacher"}), controllers.AddSoal)
-	soal.Patch("/update-soal/:id", controllers.RoleMiddleware([]string{"admin", "teacher"}), controllers.UpdateSoal)
-	soal.Delete("/delete-soal/:id", controllers.RoleMiddleware([]string{"admin", "teacher"}), controllers.DeleteSoal)
+	soal.Post("/add-soal", controllers.RequirePermission(models.PermSoalCreate), controllers.AddSoal)
+	soal.Patch("/update-soal/:id", controllers.RequirePermission(models.PermSoalUpdate), controllers.UpdateSoal)
+	soal.Post("/regrade/:id", controllers.RequirePermission(models.PermGradingGrade), controllers.RegradeSoal)
+	soal.Delete("/delete-soal/:id", controllers.RequirePermission(models.PermSoalDelete), controllers.DeleteSoal)
 
 	// Pendidikan Routes (Only Admin)
 	pendidikan := app.Group("/pendidikan", AuthMiddleware)
 	pendidikan.Get("/get-pendidikan", controllers.GetPendidikan)
-	pendidikan.Post("/add-pendidikan", controllers.RoleMiddleware([]string{"admin"}), controllers.AddPendidikan)
-	pendidikan.Patch("/update-pendidikan/:id", controllers.RoleMiddleware([]string{"admin"}), controllers.UpdatePendidikan)
-	pendidikan.Delete("/delete-pendidikan/:id", controllers.RoleMiddleware([]string{"admin"}), controllers.DeletePendidikan)
+	pendidikan.Post("/add-pendidikan", controllers.RequirePermission(models.PermPendidikanManage), controllers.AddPendidikan)
+	pendidikan.Patch("/update-pendidikan/:id", controllers.RequirePermission(models.PermPendidikanManage), controllers.UpdatePendidikan)
+	pendidikan.Delete("/delete-pendidikan/:id", controllers.RequirePermission(models.PermPendidikanManage), controllers.DeletePendidikan)
 
 	// Hasil Kuis Routes (Admin, Teacher, Student)
 	result := app.Group("/hasil-kuis", AuthMiddleware)
 	result.Get("/my-results", controllers.GetAllHasilKuisByUser)
-	result.Get("/user/:user_id", controllers.RoleMiddleware([]string{"admin", "teacher"}), controllers.GetHasilKuisByUserID)
-	result.Post("/submit-jawaban", controllers.SubmitJawaban)
+	result.Get("/user/:user_id", controllers.RequirePermission(models.PermResultsRead), controllers.GetHasilKuisByUserID)
+	result.Post("/submit-jawaban", submissionLimit, controllers.RequireVerifiedEmail(database.ActionAttemptKuis), controllers.SubmitJawaban)
+	result.Post("/start-attempt", submissionLimit, controllers.RequireVerifiedEmail(database.ActionAttemptKuis), controllers.StartAttempt)
+	result.Get("/attempt/:attempt_id", controllers.GetAttempt)
+	result.Get("/attempt/:attempt_id/soal", controllers.GetAttemptSoal)
+	result.Post("/attempt/:attempt_id/answer", submissionLimit, controllers.SaveAttemptAnswer)
+	result.Post("/attempt/:attempt_id/finish", submissionLimit, controllers.FinishAttempt)
 	result.Get("/:user_id/:kuis_id", controllers.GetHasilKuis)
 
+	// Grading Routes (Admin, Teacher) - penilaian manual soal essay
+	grading := app.Group("/grading", AuthMiddleware)
+	grading.Get("/queue", controllers.RequirePermission(models.PermGradingGrade), controllers.GetGradingQueue)
+	grading.Post("/answers/:answer_id", controllers.RequirePermission(models.PermGradingGrade), controllers.GradeEssayAnswer)
+
+	// User Management Routes (Admin only)
+	admin := app.Group("/admin/users", AuthMiddleware, controllers.RequirePermission(models.PermUsersManage))
+	admin.Get("/", controllers.ListUsers)
+	admin.Get("/:id", controllers.GetUserDetail)
+	admin.Patch("/:id", controllers.UpdateUserDetails)
+	admin.Post("/:id/lock", controllers.LockUser)
+	admin.Post("/:id/unlock", controllers.UnlockUser)
+	admin.Post("/:id/reset-failed-attempts", controllers.ResetFailedAttempts)
+	admin.Delete("/:id", controllers.DeleteUser)
+	admin.Post("/:id/restore", controllers.RestoreUser)
+
+	// Role & Permission Routes (Admin only)
+	roles := app.Group("/admin/roles", AuthMiddleware, controllers.RequirePermission(models.PermRolesManage))
+	roles.Get("/", controllers.GetRoles)
+	roles.Get("/permissions", controllers.GetPermissions)
+	roles.Post("/", controllers.CreateRole)
+	roles.Patch("/:name", controllers.UpdateRole)
+	roles.Delete("/:name", controllers.DeleteRole)
+
+	// Settings Routes (Admin only)
+	settings := app.Group("/settings", AuthMiddleware)
+	settings.Get("/verification", controllers.RequirePermission(models.PermSettingsManage), controllers.GetVerificationSettings)
+	settings.Put("/verification", controllers.RequirePermission(models.PermSettingsManage), controllers.UpdateVerificationSettings)
+	settings.Get("/two-factor", controllers.RequirePermission(models.PermSettingsManage), controllers.GetTwoFactorSettings)
+	settings.Put("/two-factor", controllers.RequirePermission(models.PermSettingsManage), controllers.UpdateTwoFactorSettings)
+	settings.Get("/password-policy", controllers.RequirePermission(models.PermSettingsManage), controllers.GetPasswordPolicy)
+	settings.Put("/password-policy", controllers.RequirePermission(models.PermSettingsManage), controllers.UpdatePasswordPolicy)
+
 	// Audit Routes (Admin only)
 	audit := app.Group("/audit", AuthMiddleware)
-	audit.Get("/logs", controllers.RoleMiddleware([]string{"admin"}), controllers.GetAuditLogs)
+	audit.Get("/logs", controllers.RequirePermission(models.PermAuditRead), controllers.GetAuditLogs)
 
 	// 404 Handler - must be last
 	app.Use(func(c *fiber.Ctx) error {
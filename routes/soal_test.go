@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Joko206/UAS_PWEB1/database"
+	"github.com/Joko206/UAS_PWEB1/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestGetSoalByKuisForStudent checks that students get the delivery view of a plain kuis
+// and have to start an attempt for a timed kuis
+func TestGetSoalByKuisForStudent(t *testing.T) {
+	f := newOwnershipFixture(t)
+	plain := f.newContent(t, true)
+	student := createTestUser(t, "student", models.RoleStudent)
+
+	var delivery []map[string]interface{}
+	if got := f.requestData(t, student, fiber.MethodGet, fmt.Sprintf("/soal/get-soal/%d", plain.kuis.ID), "", &delivery); got != fiber.StatusOK {
+		t.Fatalf("plain kuis: status %d, want 200", got)
+	}
+	if len(delivery) != 1 {
+		t.Fatalf("plain kuis: %d soal, want 1", len(delivery))
+	}
+	if _, leaked := delivery[0]["correct_answer"]; leaked {
+		t.Error("delivery view contains the answer key")
+	}
+
+	timed, err := database.CreateKuis("Timed", "", false, f.kategori, f.tingkatan, plain.kelas.ID, f.pendidikan, f.owner.ID, models.KuisSettings{TimeLimit: 10})
+	if err != nil {
+		t.Fatalf("create kuis: %v", err)
+	}
+	if _, err := database.CreateSoal("2 + 2?", models.SoalSingleChoice, []byte(`{"A":"4","B":"5"}`), "A", nil, 1, "", nil, timed.ID); err != nil {
+		t.Fatalf("create soal: %v", err)
+	}
+	if _, err := database.PublishKuis(timed.ID, f.owner.ID); err != nil {
+		t.Fatalf("publish kuis: %v", err)
+	}
+
+	if got := f.request(t, student, fiber.MethodGet, fmt.Sprintf("/soal/get-soal/%d", timed.ID), ""); got != fiber.StatusForbidden {
+		t.Errorf("timed kuis: status %d, want 403", got)
+	}
+}